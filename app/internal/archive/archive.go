@@ -0,0 +1,276 @@
+package archive
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+	FormatTarBz = "tar.bz2"
+
+	// Archives with more entries are refused, the index lives in memory
+	maxEntries = 200000
+	maxIndexes = 32
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported archive format")
+	ErrNotFound    = errors.New("no such entry in archive")
+	ErrTooMany     = fmt.Errorf("archive has more than %d entries", maxEntries)
+)
+
+var suffixes = []struct {
+	suffix string
+	format string
+}{
+	{".tar.gz", FormatTarGz},
+	{".tgz", FormatTarGz},
+	{".tar.bz2", FormatTarBz},
+	{".tbz2", FormatTarBz},
+	{".tar", FormatTar},
+	{".zip", FormatZip},
+}
+
+// Source is an archive file as the storage layer sees it.
+type Source struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+	Range    func(ctx context.Context, offset int64, length int64, w io.Writer) error
+}
+
+// Entry is a file or folder inside an archive, Path is relative to the
+// archive root without a leading slash.
+type Entry struct {
+	Path     string
+	Name     string
+	Size     int64
+	Modified time.Time
+	IsFolder bool
+	// Start of the content in the archive, nil when the content is
+	// compressed and can only be read from the beginning.
+	dataOffset func() (int64, error)
+	extract    func(ctx context.Context, w io.Writer) error
+}
+
+// Seekable tells if byte ranges of the entry can be read directly.
+func (self *Entry) Seekable() bool {
+	return self.dataOffset != nil
+}
+
+// Extractable tells if the content can be read, encrypted entries and
+// compression methods other than deflate can't.
+func (self *Entry) Extractable() bool {
+	return self.extract != nil
+}
+
+type Index struct {
+	Format   string
+	src      *Source
+	entries  map[string]*Entry
+	children map[string][]*Entry
+}
+
+// Format returns the archive format of a file name, empty for other files.
+func Format(name string) string {
+	name = strings.ToLower(name)
+	for _, v := range suffixes {
+		if strings.HasSuffix(name, v.suffix) && len(name) > len(v.suffix) {
+			return v.format
+		}
+	}
+
+	return ""
+}
+
+// Split finds the first archive in rpath, inner is the rest of the path and
+// is empty when rpath is the archive itself.
+func Split(rpath string) (archive string, inner string, ok bool) {
+	parts := strings.Split(strings.Trim(rpath, "/"), "/")
+	// The first part is the mount, never an archive
+	for i := 1; i < len(parts); i++ {
+		if Format(parts[i]) != "" {
+			return "/" + strings.Join(parts[:i+1], "/"), strings.Join(parts[i+1:], "/"), true
+		}
+	}
+
+	return "", "", false
+}
+
+var (
+	cacheLock sync.Mutex
+	cacheList = list.New()
+	cacheMap  = map[string]*list.Element{}
+)
+
+type cacheItem struct {
+	key   string
+	index *Index
+}
+
+// Load reads the entry list of src, indexes of recently used archives are
+// kept until the file changes.
+func Load(ctx context.Context, src *Source) (*Index, error) {
+	key := fmt.Sprintf("%s|%d|%d", src.Key, src.Size, src.Modified.Unix())
+	cacheLock.Lock()
+	if e, ok := cacheMap[key]; ok {
+		cacheList.MoveToFront(e)
+		cacheLock.Unlock()
+		return e.Value.(*cacheItem).index, nil
+	}
+	cacheLock.Unlock()
+
+	var index *Index
+	var err error
+	switch Format(path.Base(src.Key)) {
+	case FormatZip:
+		index, err = loadZip(ctx, src)
+	case FormatTar, FormatTarGz, FormatTarBz:
+		index, err = loadTar(ctx, src)
+	default:
+		err = ErrUnsupported
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	cacheMap[key] = cacheList.PushFront(&cacheItem{key: key, index: index})
+	if cacheList.Len() > maxIndexes {
+		e := cacheList.Back()
+		cacheList.Remove(e)
+		delete(cacheMap, e.Value.(*cacheItem).key)
+	}
+
+	return index, nil
+}
+
+func newIndex(format string, src *Source) *Index {
+	index := &Index{
+		Format:   format,
+		src:      src,
+		entries:  map[string]*Entry{},
+		children: map[string][]*Entry{},
+	}
+	index.entries[""] = &Entry{IsFolder: true}
+	return index
+}
+
+// cleanName turns a stored name into an entry path, names escaping the
+// archive root are dropped.
+func cleanName(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" || name == "." {
+		return "", false
+	}
+
+	return name, true
+}
+
+func (self *Index) add(e *Entry) error {
+	if len(self.entries) >= maxEntries {
+		return ErrTooMany
+	}
+
+	e.Name = path.Base(e.Path)
+	if old, ok := self.entries[e.Path]; ok {
+		// Folders are often implied by their files before their own entry
+		if old.IsFolder && e.IsFolder {
+			old.Modified = e.Modified
+		}
+
+		return nil
+	}
+
+	self.entries[e.Path] = e
+	parent := path.Dir(e.Path)
+	if parent == "." {
+		parent = ""
+	}
+
+	if _, ok := self.entries[parent]; !ok {
+		err := self.add(&Entry{Path: parent, IsFolder: true, Modified: e.Modified})
+		if err != nil {
+			return err
+		}
+	}
+
+	self.children[parent] = append(self.children[parent], e)
+	return nil
+}
+
+func (self *Index) sort() {
+	for _, v := range self.children {
+		sort.Slice(v, func(i, j int) bool {
+			if v[i].IsFolder != v[j].IsFolder {
+				return v[i].IsFolder
+			}
+
+			return v[i].Name < v[j].Name
+		})
+	}
+}
+
+func (self *Index) Get(inner string) (*Entry, error) {
+	e, ok := self.entries[strings.Trim(inner, "/")]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return e, nil
+}
+
+func (self *Index) List(inner string) ([]*Entry, error) {
+	e, err := self.Get(inner)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.IsFolder {
+		return nil, ErrNotFound
+	}
+
+	return self.children[e.Path], nil
+}
+
+// Extract writes the content of a file entry.
+func (self *Index) Extract(ctx context.Context, e *Entry, w io.Writer) error {
+	if e.IsFolder {
+		return ErrNotFound
+	}
+
+	if e.extract == nil {
+		return ErrUnsupported
+	}
+
+	return e.extract(ctx, w)
+}
+
+// ExtractRange writes length bytes of a seekable entry from offset.
+func (self *Index) ExtractRange(ctx context.Context, e *Entry, offset int64, length int64, w io.Writer) error {
+	if !e.Seekable() {
+		return ErrUnsupported
+	}
+
+	start, err := e.dataOffset()
+	if err != nil {
+		return err
+	}
+
+	return self.src.Range(ctx, start+offset, length, w)
+}
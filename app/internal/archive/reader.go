@@ -0,0 +1,99 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+const (
+	// Bytes fetched per StreamRange call, headers of neighbouring entries
+	// usually land in the same block.
+	blockSize = 256 << 10
+	maxBlocks = 64
+)
+
+// readerAt turns StreamRange into an io.ReaderAt with a small block cache,
+// archive/zip and archive/tar issue many tiny reads.
+type readerAt struct {
+	ctx    context.Context
+	src    *Source
+	lock   sync.Mutex
+	blocks map[int64][]byte
+	order  []int64
+}
+
+func newReaderAt(ctx context.Context, src *Source) *readerAt {
+	return &readerAt{ctx: ctx, src: src, blocks: map[int64][]byte{}}
+}
+
+func (self *readerAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= self.src.Size {
+			return n, io.EOF
+		}
+
+		block, err := self.block(pos / blockSize * blockSize)
+		if err != nil {
+			return n, err
+		}
+
+		start := pos % blockSize
+		if start >= int64(len(block)) {
+			return n, io.ErrUnexpectedEOF
+		}
+
+		n += copy(p[n:], block[start:])
+	}
+
+	return n, nil
+}
+
+func (self *readerAt) block(start int64) ([]byte, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if data, ok := self.blocks[start]; ok {
+		return data, nil
+	}
+
+	var b bytes.Buffer
+	err := self.src.Range(self.ctx, start, min(blockSize, self.src.Size-start), &b)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(self.order) >= maxBlocks {
+		delete(self.blocks, self.order[0])
+		self.order = self.order[1:]
+	}
+
+	self.blocks[start] = b.Bytes()
+	self.order = append(self.order, start)
+	return b.Bytes(), nil
+}
+
+// release drops the cached blocks and detaches reads from the request that
+// loaded the index, later reads come from other requests.
+func (self *readerAt) release() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.ctx = context.WithoutCancel(self.ctx)
+	self.blocks = map[int64][]byte{}
+	self.order = nil
+}
+
+// rangeReader streams length bytes from offset through a pipe, the caller
+// must Close it to stop the transfer early.
+func rangeReader(ctx context.Context, src *Source, offset int64, length int64) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(src.Range(ctx, offset, length, pw))
+	}()
+
+	return pr
+}
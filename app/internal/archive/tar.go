@@ -0,0 +1,156 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"io"
+	"path"
+)
+
+// loadTar walks the headers of a tar. Plain tars are read through ranges so
+// file contents are skipped, compressed ones have to be read in full.
+func loadTar(ctx context.Context, src *Source) (*Index, error) {
+	format := Format(path.Base(src.Key))
+	index := newIndex(format, src)
+	if format == FormatTar {
+		ra := newReaderAt(ctx, src)
+		defer ra.release()
+
+		sr := io.NewSectionReader(ra, 0, src.Size)
+		err := walkTar(sr, func(h *tar.Header, name string) error {
+			offset, err := sr.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+
+			e := tarEntry(h, name)
+			if !e.IsFolder {
+				e.dataOffset = func() (int64, error) { return offset, nil }
+				e.extract = func(ctx context.Context, w io.Writer) error {
+					return src.Range(ctx, offset, h.Size, w)
+				}
+			}
+
+			return index.add(e)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		index.sort()
+		return index, nil
+	}
+
+	body := rangeReader(ctx, src, 0, src.Size)
+	defer body.Close()
+
+	r, err := decompress(format, body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = walkTar(r, func(h *tar.Header, name string) error {
+		e := tarEntry(h, name)
+		if !e.IsFolder {
+			stored := h.Name
+			e.extract = func(ctx context.Context, w io.Writer) error {
+				return extractTar(ctx, src, format, stored, w)
+			}
+		}
+
+		return index.add(e)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	index.sort()
+	return index, nil
+}
+
+func decompress(format string, r io.Reader) (io.Reader, error) {
+	switch format {
+	case FormatTarGz:
+		return gzip.NewReader(r)
+	case FormatTarBz:
+		return bzip2.NewReader(r), nil
+	}
+
+	return r, nil
+}
+
+// walkTar calls fn for regular files and folders, links and devices are
+// left out.
+func walkTar(r io.Reader, fn func(h *tar.Header, name string) error) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		mode := h.FileInfo().Mode()
+		if !mode.IsRegular() && !mode.IsDir() {
+			continue
+		}
+
+		name, ok := cleanName(h.Name)
+		if !ok {
+			continue
+		}
+
+		err = fn(h, name)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func tarEntry(h *tar.Header, name string) *Entry {
+	e := &Entry{
+		Path:     name,
+		Modified: h.ModTime,
+		IsFolder: h.FileInfo().IsDir(),
+	}
+
+	if !e.IsFolder {
+		e.Size = h.Size
+	}
+
+	return e
+}
+
+// extractTar reads a compressed tar from the start up to the entry stored
+// as name.
+func extractTar(ctx context.Context, src *Source, format string, name string, w io.Writer) error {
+	body := rangeReader(ctx, src, 0, src.Size)
+	defer body.Close()
+
+	r, err := decompress(format, body)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return ErrNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if h.Name == name {
+			_, err = io.Copy(w, tr)
+			return err
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package archive
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// loadZip reads the central directory at the end of the archive, only the
+// blocks holding it are fetched.
+func loadZip(ctx context.Context, src *Source) (*Index, error) {
+	ra := newReaderAt(ctx, src)
+	r, err := zip.NewReader(ra, src.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	defer ra.release()
+
+	index := newIndex(FormatZip, src)
+	for _, f := range r.File {
+		name := f.Name
+		// Archives made on Chinese Windows store names in GBK
+		if f.NonUTF8 && !utf8.ValidString(name) {
+			if decoded, err := simplifiedchinese.GB18030.NewDecoder().String(name); err == nil {
+				name = decoded
+			}
+		}
+
+		name, ok := cleanName(name)
+		if !ok {
+			continue
+		}
+
+		e := &Entry{
+			Path:     name,
+			Size:     int64(f.UncompressedSize64),
+			Modified: f.Modified,
+			IsFolder: f.FileInfo().IsDir(),
+		}
+
+		if !e.IsFolder && f.Flags&0x1 == 0 && (f.Method == zip.Store || f.Method == zip.Deflate) {
+			file := f
+			dataOffset := cachedOffset(file)
+			e.extract = func(ctx context.Context, w io.Writer) error {
+				return extractZip(ctx, src, file, dataOffset, w)
+			}
+
+			if file.Method == zip.Store {
+				e.dataOffset = dataOffset
+			}
+		}
+
+		err = index.add(e)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	index.sort()
+	return index, nil
+}
+
+// extractZip streams the compressed bytes of one entry in a single range
+// and inflates them on the fly.
+func extractZip(ctx context.Context, src *Source, f *zip.File, dataOffset func() (int64, error), w io.Writer) error {
+	offset, err := dataOffset()
+	if err != nil {
+		return err
+	}
+
+	body := rangeReader(ctx, src, offset, int64(f.CompressedSize64))
+	defer body.Close()
+
+	var r io.Reader = body
+	if f.Method == zip.Deflate {
+		fr := flate.NewReader(body)
+		defer fr.Close()
+		r = fr
+	}
+
+	_, err = io.Copy(w, io.LimitReader(r, int64(f.UncompressedSize64)))
+	return err
+}
+
+// cachedOffset reads the local header of f once it succeeds, the central
+// directory doesn't record where the data starts.
+func cachedOffset(f *zip.File) func() (int64, error) {
+	var lock sync.Mutex
+	offset := int64(-1)
+	return func() (int64, error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if offset >= 0 {
+			return offset, nil
+		}
+
+		v, err := f.DataOffset()
+		if err != nil {
+			return 0, err
+		}
+
+		offset = v
+		return offset, nil
+	}
+}
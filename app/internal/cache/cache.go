@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"overlink.top/app/lib/util"
+	"overlink.top/app/system/conf"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	List = "list:"
+	Link = "link:"
+
+	TypeMemory = "memory"
+	TypeDisk   = "disk"
+	TypeRedis  = "redis"
+)
+
+// Backend stores raw values by key. Implementations must be safe for
+// concurrent use, a zero ttl means the entry never expires.
+type Backend interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+	Delete(keys ...string)
+	DeletePrefix(prefix string)
+	Close() error
+}
+
+// Broadcaster is implemented by backends shared between instances, it lets
+// one instance tell the others to drop their in-process caches.
+type Broadcaster interface {
+	Publish(data []byte) error
+	Subscribe(fn func(data []byte))
+}
+
+type InvalidateFunc func(rpath string, subtree bool)
+
+type invalidateMsg struct {
+	Origin  string `json:"origin"`
+	Path    string `json:"path"`
+	Subtree bool   `json:"subtree"`
+}
+
+var (
+	backend  Backend = newMemory()
+	prefix   string
+	hooks    []InvalidateFunc
+	hookLock sync.RWMutex
+	instance = util.GenSecureStr(12)
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+)
+
+// Stats returns the hit and miss counts of Get since start
+func Stats() (uint64, uint64) {
+	return hits.Load(), misses.Load()
+}
+
+func Init(cfg conf.Cache) error {
+	var inst Backend
+	var err error
+	switch cfg.Type {
+	case "", TypeMemory:
+		inst = newMemory()
+	case TypeDisk:
+		if cfg.Path == "" {
+			cfg.Path = "runtime/data/cache.db"
+		}
+
+		inst, err = newDisk(conf.AbsPath(cfg.Path))
+	case TypeRedis:
+		inst, err = newRedis(cfg)
+	default:
+		err = fmt.Errorf("unknown cache type: %s", cfg.Type)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	old := backend
+	backend, prefix = inst, cfg.Prefix
+	old.Close()
+
+	if b, ok := inst.(Broadcaster); ok {
+		b.Subscribe(onBroadcast)
+	}
+
+	return nil
+}
+
+func Get(group string, k string, v interface{}) bool {
+	data, ok := backend.Get(prefix + group + k)
+	if !ok || json.Unmarshal(data, v) != nil {
+		misses.Add(1)
+		return false
+	}
+
+	hits.Add(1)
+	return true
+}
+
+func Set(group string, k string, v interface{}, ttl time.Duration) {
+	if ttl < 0 {
+		return
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	backend.Set(prefix+group+k, data, ttl)
+}
+
+func Delete(group string, k string) {
+	backend.Delete(prefix + group + k)
+}
+
+// OnInvalidate registers an in-process cache that must follow invalidations,
+// hooks also run for invalidations received from other instances.
+func OnInvalidate(fn InvalidateFunc) {
+	hookLock.Lock()
+	defer hookLock.Unlock()
+
+	hooks = append(hooks, fn)
+}
+
+// InvalidatePath drops everything cached about rpath after it changed: its
+// own listing and link, and the listing of its parent.
+func InvalidatePath(rpath string) {
+	parent := util.GetParentDir(rpath)
+	backend.Delete(prefix+List+rpath, prefix+List+parent, prefix+Link+rpath)
+	notify(rpath, false)
+	notify(parent, false)
+}
+
+// InvalidateTree drops rpath, everything below it and the parent listing.
+func InvalidateTree(rpath string) {
+	for _, group := range []string{List, Link} {
+		backend.DeletePrefix(prefix + group + rpath)
+	}
+
+	parent := util.GetParentDir(rpath)
+	backend.Delete(prefix + List + parent)
+	notify(rpath, true)
+	notify(parent, false)
+}
+
+func notify(rpath string, subtree bool) {
+	runHooks(rpath, subtree)
+	if b, ok := backend.(Broadcaster); ok {
+		data, _ := json.Marshal(invalidateMsg{Origin: instance, Path: rpath, Subtree: subtree})
+		b.Publish(data)
+	}
+}
+
+func onBroadcast(data []byte) {
+	var m invalidateMsg
+	if json.Unmarshal(data, &m) != nil || m.Origin == instance {
+		return
+	}
+
+	runHooks(m.Path, m.Subtree)
+}
+
+func runHooks(rpath string, subtree bool) {
+	hookLock.RLock()
+	defer hookLock.RUnlock()
+
+	for _, fn := range hooks {
+		fn(rpath, subtree)
+	}
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const diskCleanInterval = 10 * time.Minute
+
+type diskEntry struct {
+	Key      string `gorm:"primaryKey"`
+	Value    []byte
+	ExpireAt int64 `gorm:"index"`
+}
+
+// disk keeps entries in a dedicated sqlite file so they survive restarts
+// without touching the main database.
+type disk struct {
+	db   *gorm.DB
+	done chan struct{}
+}
+
+func newDisk(path string) (*disk, error) {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, err
+	}
+
+	db.Exec("PRAGMA journal_mode=WAL;")
+	err = db.AutoMigrate(&diskEntry{})
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &disk{db: db, done: make(chan struct{})}
+	go inst.clean()
+	return inst, nil
+}
+
+func (self *disk) Get(key string) ([]byte, bool) {
+	var data diskEntry
+	err := self.db.Where("key = ? AND (expire_at = 0 OR expire_at > ?)", key, time.Now().UnixMilli()).
+		Limit(1).Find(&data).Error
+	if err != nil || data.Key == "" {
+		return nil, false
+	}
+
+	return data.Value, true
+}
+
+func (self *disk) Set(key string, value []byte, ttl time.Duration) {
+	data := diskEntry{Key: key, Value: value}
+	if ttl > 0 {
+		data.ExpireAt = time.Now().Add(ttl).UnixMilli()
+	}
+
+	self.db.Save(&data)
+}
+
+func (self *disk) Delete(keys ...string) {
+	self.db.Where("key IN ?", keys).Delete(&diskEntry{})
+}
+
+func (self *disk) DeletePrefix(prefix string) {
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	self.db.Where(`key LIKE ? ESCAPE '\'`, escaper.Replace(prefix)+"%").Delete(&diskEntry{})
+}
+
+func (self *disk) Close() error {
+	close(self.done)
+	sqlDB, err := self.db.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.Close()
+}
+
+func (self *disk) clean() {
+	ticker := time.NewTicker(diskCleanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			self.db.Where("expire_at > 0 AND expire_at <= ?", time.Now().UnixMilli()).Delete(&diskEntry{})
+		case <-self.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"github.com/patrickmn/go-cache"
+	"strings"
+	"time"
+)
+
+type memory struct {
+	c *cache.Cache
+}
+
+func newMemory() *memory {
+	return &memory{c: cache.New(5*time.Minute, 10*time.Minute)}
+}
+
+func (self *memory) Get(key string) ([]byte, bool) {
+	data, ok := self.c.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	return data.([]byte), true
+}
+
+func (self *memory) Set(key string, value []byte, ttl time.Duration) {
+	if ttl == 0 {
+		ttl = cache.NoExpiration
+	}
+
+	self.c.Set(key, value, ttl)
+}
+
+func (self *memory) Delete(keys ...string) {
+	for _, k := range keys {
+		self.c.Delete(k)
+	}
+}
+
+func (self *memory) DeletePrefix(prefix string) {
+	for k := range self.c.Items() {
+		if strings.HasPrefix(k, prefix) {
+			self.c.Delete(k)
+		}
+	}
+}
+
+func (self *memory) Close() error {
+	return nil
+}
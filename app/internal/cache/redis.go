@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"overlink.top/app/system/conf"
+	"time"
+)
+
+const (
+	redisTimeout  = 3 * time.Second
+	redisScanStep = 500
+)
+
+// rediscache works with any server speaking the Redis protocol, entries are
+// shared between instances and invalidations go out over pub/sub.
+type rediscache struct {
+	client  *redis.Client
+	channel string
+	pubsub  *redis.PubSub
+}
+
+func newRedis(cfg conf.Cache) (*rediscache, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDb,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &rediscache{client: client, channel: cfg.Prefix + "invalidate"}, nil
+}
+
+func (self *rediscache) Get(key string) ([]byte, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	data, err := self.client.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
+
+func (self *rediscache) Set(key string, value []byte, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	self.client.Set(ctx, key, value, ttl)
+}
+
+func (self *rediscache) Delete(keys ...string) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	self.client.Unlink(ctx, keys...)
+}
+
+func (self *rediscache) DeletePrefix(prefix string) {
+	ctx := context.Background()
+	iter := self.client.Scan(ctx, 0, escapeGlob(prefix)+"*", redisScanStep).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) >= redisScanStep {
+			self.client.Unlink(ctx, keys...)
+			keys = keys[:0]
+		}
+	}
+
+	if len(keys) > 0 {
+		self.client.Unlink(ctx, keys...)
+	}
+}
+
+func (self *rediscache) Close() error {
+	if self.pubsub != nil {
+		self.pubsub.Close()
+	}
+
+	return self.client.Close()
+}
+
+func (self *rediscache) Publish(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	return self.client.Publish(ctx, self.channel, data).Err()
+}
+
+func (self *rediscache) Subscribe(fn func(data []byte)) {
+	self.pubsub = self.client.Subscribe(context.Background(), self.channel)
+	go func() {
+		for m := range self.pubsub.Channel() {
+			fn([]byte(m.Payload))
+		}
+	}()
+}
+
+func escapeGlob(str string) string {
+	buf := make([]byte, 0, len(str))
+	for i := 0; i < len(str); i++ {
+		switch str[i] {
+		case '*', '?', '[', ']', '\\':
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, str[i])
+	}
+
+	return string(buf)
+}
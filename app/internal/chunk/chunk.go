@@ -0,0 +1,269 @@
+package chunk
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"overlink.top/app/system/conf"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const fillPrefix = ".fill-"
+
+var ErrDisabled = errors.New("chunk cache is not initialized")
+
+var (
+	dir          string
+	chunkSize    int64
+	inflightLock sync.Mutex
+	inflight     = map[string]*filling{}
+)
+
+// File is a remote file read through the cache, Range fetches uncached
+// chunks from the storage.
+type File struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+	Range    func(ctx context.Context, offset int64, length int64, w io.Writer) error
+}
+
+func Init(cfg conf.ChunkCache) error {
+	if cfg.Path == "" {
+		cfg.Path = "runtime/chunks"
+	}
+
+	if cfg.ChunkSize < 1 {
+		cfg.ChunkSize = 4
+	}
+
+	if cfg.MaxSize < 1 {
+		cfg.MaxSize = 10240
+	}
+
+	chunkSize = int64(cfg.ChunkSize) << 20
+	maxSize = int64(cfg.MaxSize) << 20
+	path := conf.AbsPath(cfg.Path)
+	err := os.MkdirAll(path, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = loadLru(path)
+	if err != nil {
+		return err
+	}
+
+	dir = path
+	return nil
+}
+
+// Enabled tells if Init succeeded, ReadRange fails with ErrDisabled otherwise.
+func Enabled() bool {
+	return dir != ""
+}
+
+// fileDir is where the chunks of f live, a new size, modified time or chunk
+// size makes it a different file.
+func fileDir(f *File) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%d|%d|%d", f.Key, f.Size, f.Modified.Unix(), chunkSize)))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(dir, name[:2], name)
+}
+
+// ReadRange writes length bytes of f from offset. Chunks on disk are served
+// from there, missing ones are fetched whole while the bytes asked for are
+// passed on as they arrive.
+func ReadRange(ctx context.Context, f *File, offset int64, length int64, w io.Writer) error {
+	if !Enabled() {
+		return ErrDisabled
+	}
+
+	base := fileDir(f)
+	end := min(offset+length, f.Size)
+	for pos := offset; pos < end; {
+		index := pos / chunkSize
+		n := min(end, (index+1)*chunkSize) - pos
+		err := readChunk(ctx, f, base, index, pos-index*chunkSize, n, w)
+		if err != nil {
+			return err
+		}
+
+		pos += n
+	}
+
+	return nil
+}
+
+func readChunk(ctx context.Context, f *File, base string, index int64, offset int64, length int64, w io.Writer) error {
+	file := filepath.Join(base, strconv.FormatInt(index, 10))
+	inflightLock.Lock()
+	fl, ok := inflight[file]
+	if !ok {
+		fp, err := os.Open(file)
+		if err == nil {
+			inflightLock.Unlock()
+			defer fp.Close()
+
+			touch(file)
+			_, err = io.Copy(w, io.NewSectionReader(fp, offset, length))
+			return err
+		}
+
+		fl, err = startFill(ctx, f, file, index)
+		if err != nil {
+			inflightLock.Unlock()
+			return err
+		}
+	}
+
+	// Opened before unlocking, the temp file is renamed once the fill is
+	// done and removed on failure
+	fp, err := os.Open(fl.tmp)
+	inflightLock.Unlock()
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	return fl.copy(ctx, fp, offset, length, w)
+}
+
+// filling is a chunk being downloaded, any number of readers follow the
+// temp file as it grows.
+type filling struct {
+	tmp     string
+	lock    sync.Mutex
+	cond    *sync.Cond
+	written int64
+	done    bool
+	err     error
+}
+
+func (self *filling) Write(p []byte) (int, error) {
+	self.lock.Lock()
+	self.written += int64(len(p))
+	self.lock.Unlock()
+	self.cond.Broadcast()
+	return len(p), nil
+}
+
+func (self *filling) finish(err error) {
+	self.lock.Lock()
+	self.done, self.err = true, err
+	self.lock.Unlock()
+	self.cond.Broadcast()
+}
+
+// copy passes on [offset, offset+length) of the chunk once written.
+func (self *filling) copy(ctx context.Context, fp *os.File, offset int64, length int64, w io.Writer) error {
+	// A stalled download must not hold a reader that went away
+	stop := context.AfterFunc(ctx, func() {
+		self.lock.Lock()
+		self.cond.Broadcast()
+		self.lock.Unlock()
+	})
+	defer stop()
+
+	end := offset + length
+	for offset < end {
+		self.lock.Lock()
+		for self.written <= offset && !self.done && ctx.Err() == nil {
+			self.cond.Wait()
+		}
+		written, err := self.written, self.err
+		self.lock.Unlock()
+
+		if written <= offset {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return err
+		}
+
+		n := min(written, end) - offset
+		_, err = io.Copy(w, io.NewSectionReader(fp, offset, n))
+		if err != nil {
+			return err
+		}
+
+		offset += n
+		if ctx.Err() != nil && offset < end {
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// startFill downloads a chunk in the background, it goes on when the reader
+// that asked for it leaves so the others and the cache still get it. Must be
+// called with inflightLock held.
+func startFill(ctx context.Context, f *File, file string, index int64) (*filling, error) {
+	err := os.MkdirAll(filepath.Dir(file), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), fillPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	fl := &filling{tmp: tmp.Name()}
+	fl.cond = sync.NewCond(&fl.lock)
+	inflight[file] = fl
+
+	start := index * chunkSize
+	length := min(chunkSize, f.Size-start)
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		err := f.Range(ctx, start, length, io.MultiWriter(tmp, fl))
+		fl.lock.Lock()
+		written := fl.written
+		fl.lock.Unlock()
+		if err == nil && written != length {
+			err = fmt.Errorf("chunk %d of %s: got %d bytes, want %d", index, f.Key, written, length)
+		}
+
+		closeErr := tmp.Close()
+		if err == nil {
+			err = closeErr
+		}
+
+		fl.finish(err)
+		inflightLock.Lock()
+		defer inflightLock.Unlock()
+
+		delete(inflight, file)
+		if err == nil {
+			err = os.Rename(tmp.Name(), file)
+		}
+
+		if err != nil {
+			os.Remove(tmp.Name())
+			return
+		}
+
+		add(file, length)
+	}()
+
+	return fl, nil
+}
+
+func isFillFile(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), fillPrefix)
+}
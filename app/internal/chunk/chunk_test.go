@@ -0,0 +1,254 @@
+package chunk
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setup points the cache at a fresh directory with tiny chunks so tests
+// can count storage calls per chunk.
+func setup(t *testing.T, chunk int64, max int64) {
+	dir = t.TempDir()
+	chunkSize, maxSize = chunk, max
+	resetLru()
+	t.Cleanup(func() {
+		waitIdle(t)
+		dir = ""
+	})
+}
+
+func resetLru() {
+	lruLock.Lock()
+	lruList, lruMap, lruTotal = list.New(), map[string]*list.Element{}, 0
+	lruLock.Unlock()
+}
+
+// waitIdle blocks until background fills are done and their chunks added
+// to the LRU.
+func waitIdle(t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		inflightLock.Lock()
+		n := len(inflight)
+		inflightLock.Unlock()
+		if n == 0 {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%d fills still running", n)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// source is a remote file counting the Range calls made for each chunk, a
+// non nil gate holds every call until it is closed.
+type source struct {
+	data  []byte
+	gate  chan struct{}
+	calls atomic.Int64
+}
+
+func newSource(size int) *source {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	return &source{data: data}
+}
+
+func (self *source) file(key string) *File {
+	return &File{
+		Key:      key,
+		Size:     int64(len(self.data)),
+		Modified: time.Unix(1700000000, 0),
+		Range: func(ctx context.Context, offset int64, length int64, w io.Writer) error {
+			self.calls.Add(1)
+			if self.gate != nil {
+				<-self.gate
+			}
+
+			_, err := w.Write(self.data[offset : offset+length])
+			return err
+		},
+	}
+}
+
+func chunkFile(f *File, index int64) string {
+	return filepath.Join(fileDir(f), strconv.FormatInt(index, 10))
+}
+
+func read(t *testing.T, f *File, offset int64, length int64) []byte {
+	var buf bytes.Buffer
+	err := ReadRange(context.Background(), f, offset, length, &buf)
+	if err != nil {
+		t.Fatalf("ReadRange(%d, %d): %v", offset, length, err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestReadRangeDedup(t *testing.T) {
+	setup(t, 16, 1<<20)
+	src := newSource(40)
+	src.gate = make(chan struct{})
+	f := src.file("dedup")
+
+	const readers = 8
+	results := make([][]byte, readers)
+	errs := make([]error, readers)
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var buf bytes.Buffer
+			errs[i] = ReadRange(context.Background(), f, 0, f.Size, &buf)
+			results[i] = buf.Bytes()
+		}(i)
+	}
+
+	// Let the readers pile up behind the first chunk
+	time.Sleep(50 * time.Millisecond)
+	close(src.gate)
+	wg.Wait()
+
+	for i := range results {
+		if errs[i] != nil {
+			t.Fatalf("reader %d: %v", i, errs[i])
+		}
+
+		if !bytes.Equal(results[i], src.data) {
+			t.Fatalf("reader %d got %d bytes, not the file", i, len(results[i]))
+		}
+	}
+
+	if got := src.calls.Load(); got != 3 {
+		t.Errorf("Range called %d times for 3 chunks", got)
+	}
+
+	waitIdle(t)
+	if got := read(t, f, 10, 20); !bytes.Equal(got, src.data[10:30]) {
+		t.Errorf("cached read = %v, want %v", got, src.data[10:30])
+	}
+
+	if got := src.calls.Load(); got != 3 {
+		t.Errorf("cached read called Range, %d calls", got)
+	}
+}
+
+func TestReadRangeCancelWhileFilling(t *testing.T) {
+	setup(t, 16, 1<<20)
+	src := newSource(16)
+	src.gate = make(chan struct{})
+	f := src.file("cancel")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- ReadRange(ctx, f, 0, f.Size, io.Discard)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("reader still waiting on the stalled chunk after cancel")
+	}
+
+	// The fill goes on for the cache once the storage answers
+	close(src.gate)
+	waitIdle(t)
+	if _, err := os.Stat(chunkFile(f, 0)); err != nil {
+		t.Errorf("chunk not cached after the reader left: %v", err)
+	}
+}
+
+func TestEvictLeastRecentlyRead(t *testing.T) {
+	setup(t, 16, 48)
+	src := newSource(64)
+	f := src.file("evict")
+
+	for _, index := range []int64{0, 1, 2, 0, 3} {
+		read(t, f, index*16, 16)
+		waitIdle(t)
+	}
+
+	for index, want := range []bool{true, false, true, true} {
+		_, err := os.Stat(chunkFile(f, int64(index)))
+		if exist := err == nil; exist != want {
+			t.Errorf("chunk %d on disk = %v, want %v", index, exist, want)
+		}
+	}
+
+	if lruTotal > maxSize {
+		t.Errorf("cache holds %d bytes, max %d", lruTotal, maxSize)
+	}
+}
+
+func TestLoadLruAfterRestart(t *testing.T) {
+	setup(t, 16, 1<<20)
+	src := newSource(48)
+	f := src.file("restart")
+	read(t, f, 0, f.Size)
+	waitIdle(t)
+
+	// Chunk 1 was read last, chunk 0 first
+	now := time.Now()
+	for index, age := range []time.Duration{3, 1, 2} {
+		mtime := now.Add(-age * time.Hour)
+		os.Chtimes(chunkFile(f, int64(index)), mtime, mtime)
+	}
+
+	stale := filepath.Join(fileDir(f), fillPrefix+"stale")
+	os.WriteFile(stale, []byte("partial"), 0o644)
+
+	// Restarting with room for two chunks keeps the two read last
+	maxSize = 32
+	resetLru()
+	err := loadLru(dir)
+	if err != nil {
+		t.Fatalf("loadLru: %v", err)
+	}
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("leftover fill file survived the restart: %v", err)
+	}
+
+	for index, want := range []bool{false, true, true} {
+		_, err := os.Stat(chunkFile(f, int64(index)))
+		if exist := err == nil; exist != want {
+			t.Errorf("chunk %d on disk = %v, want %v", index, exist, want)
+		}
+	}
+
+	if lruTotal != 32 || lruList.Len() != 2 {
+		t.Errorf("lru holds %d chunks of %d bytes, want 2 of 32", lruList.Len(), lruTotal)
+	}
+
+	calls := src.calls.Load()
+	if got := read(t, f, 16, 32); !bytes.Equal(got, src.data[16:]) {
+		t.Errorf("read after restart = %v, want %v", got, src.data[16:])
+	}
+
+	if got := src.calls.Load(); got != calls {
+		t.Errorf("chunks kept on disk were fetched again, %d calls", got-calls)
+	}
+}
@@ -0,0 +1,115 @@
+package chunk
+
+import (
+	"container/list"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type lruItem struct {
+	file string
+	size int64
+}
+
+var (
+	maxSize  int64
+	lruLock  sync.Mutex
+	lruList  = list.New()
+	lruMap   = map[string]*list.Element{}
+	lruTotal int64
+)
+
+// loadLru rebuilds the usage order from the modified times of the chunks on
+// disk, hits bump them so the order survives restarts. Leftovers of fills
+// cut short by a restart are removed.
+func loadLru(root string) error {
+	var items []lruItem
+	var times []time.Time
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		if isFillFile(path) {
+			os.Remove(path)
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		items = append(items, lruItem{file: path, size: info.Size()})
+		times = append(times, info.ModTime())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		return times[order[i]].Before(times[order[j]])
+	})
+
+	lruLock.Lock()
+	defer lruLock.Unlock()
+
+	for _, i := range order {
+		item := items[i]
+		lruMap[item.file] = lruList.PushFront(&item)
+		lruTotal += item.size
+	}
+
+	evict()
+	return nil
+}
+
+func touch(file string) {
+	lruLock.Lock()
+	if e, ok := lruMap[file]; ok {
+		lruList.MoveToFront(e)
+	}
+	lruLock.Unlock()
+
+	now := time.Now()
+	os.Chtimes(file, now, now)
+}
+
+func add(file string, size int64) {
+	lruLock.Lock()
+	defer lruLock.Unlock()
+
+	if e, ok := lruMap[file]; ok {
+		lruTotal -= e.Value.(*lruItem).size
+		lruList.Remove(e)
+	}
+
+	lruMap[file] = lruList.PushFront(&lruItem{file: file, size: size})
+	lruTotal += size
+	evict()
+}
+
+// evict drops the least recently read chunks until the cache fits max_size,
+// readers holding one open keep reading it. Must be called with lruLock held.
+func evict() {
+	for lruTotal > maxSize && lruList.Len() > 0 {
+		e := lruList.Back()
+		item := e.Value.(*lruItem)
+		lruList.Remove(e)
+		delete(lruMap, item.file)
+		lruTotal -= item.size
+		os.Remove(item.file)
+		// Gone once its last chunk is, fails while others remain
+		os.Remove(filepath.Dir(item.file))
+	}
+}
@@ -0,0 +1,554 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	sourceRendition = "source"
+	doneMarker      = "done"
+	probeFile       = "probe.json"
+	playlistFile    = "index.m3u8"
+	waitTimeout     = 30 * time.Second
+	janitorInterval = 30 * time.Second
+)
+
+var (
+	ErrDisabled  = errors.New("hls is disabled")
+	ErrNoFfmpeg  = errors.New("ffmpeg is not available")
+	ErrBusy      = errors.New("too many transcodes running")
+	ErrNotFound  = errors.New("no such hls resource")
+	segmentRegex = regexp.MustCompile(`^seg\d{5}\.ts$`)
+)
+
+var (
+	cfg         conf.Hls
+	root        string
+	ffmpeg      string
+	ffprobe     string
+	sessionLock sync.Mutex
+	sessions    = map[string]*session{}
+)
+
+// session is one running ffmpeg writing the segments of a rendition.
+type session struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+	access atomic.Int64
+}
+
+func (self *session) touch() {
+	self.access.Store(time.Now().Unix())
+}
+
+type probe struct {
+	Codec    string  `json:"codec"`
+	Height   int     `json:"height"`
+	Duration float64 `json:"duration"`
+	BitRate  int64   `json:"bit_rate"`
+}
+
+func Init(c conf.Hls) error {
+	cfg = c
+	if !cfg.Enable {
+		return nil
+	}
+
+	if cfg.Path == "" {
+		cfg.Path = "runtime/hls"
+	}
+
+	if cfg.Segment < 1 {
+		cfg.Segment = 6
+	}
+
+	if cfg.IdleTimeout < 1 {
+		cfg.IdleTimeout = 120
+	}
+
+	if cfg.CacheHours < 1 {
+		cfg.CacheHours = 24
+	}
+
+	if cfg.MaxSessions < 1 {
+		cfg.MaxSessions = 2
+	}
+
+	ffmpeg, ffprobe = cfg.Ffmpeg, cfg.Ffprobe
+	if ffmpeg == "" {
+		ffmpeg, _ = exec.LookPath("ffmpeg")
+	}
+
+	if ffprobe == "" {
+		ffprobe, _ = exec.LookPath("ffprobe")
+	}
+
+	if ffmpeg == "" {
+		return ErrNoFfmpeg
+	}
+
+	root = conf.AbsPath(cfg.Path)
+	err := os.MkdirAll(root, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = startLoopback()
+	if err != nil {
+		return err
+	}
+
+	go janitor()
+	return nil
+}
+
+// Serve answers one request of a player, f names the resource: the master
+// playlist (empty), "<rendition>/index.m3u8", "<rendition>/segNNNNN.ts",
+// "sub/<n>.m3u8" or "sub/<n>.vtt". query is appended to every URI written into
+// playlists, it carries the link signature.
+func Serve(w http.ResponseWriter, r *http.Request, src *Source, subs []Subtitle, f string, query string) {
+	if root == "" {
+		writeError(w, ErrDisabled)
+		return
+	}
+
+	keyDir := filepath.Join(root, hashKey(src.Key, src.Modified))
+	err := os.MkdirAll(keyDir, os.ModePerm)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	now := time.Now()
+	os.Chtimes(keyDir, now, now)
+
+	dir, name := "", f
+	if i := strings.Index(f, "/"); i >= 0 {
+		dir, name = f[:i], f[i+1:]
+	}
+
+	switch {
+	case f == "" || f == "master.m3u8":
+		err = serveMaster(w, r, src, subs, keyDir, query)
+	case dir == "sub":
+		err = serveSubtitle(w, r, src, subs, keyDir, name, query)
+	case name == playlistFile:
+		err = servePlaylist(w, r, src, keyDir, dir, query)
+	case segmentRegex.MatchString(name):
+		err = serveSegment(w, r, src, keyDir, dir, name)
+	default:
+		err = ErrNotFound
+	}
+
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, os.ErrNotExist):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrDisabled), errors.Is(err, ErrNoFfmpeg):
+		status = http.StatusNotImplemented
+	case errors.Is(err, ErrBusy):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, context.Canceled):
+		return
+	}
+
+	w.WriteHeader(status)
+	fmt.Fprint(w, "hls error:", err)
+}
+
+func hashKey(key string, modified time.Time) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%d", key, modified.Unix())))
+	return hex.EncodeToString(sum[:])
+}
+
+func serveMaster(w http.ResponseWriter, r *http.Request, src *Source, subs []Subtitle, keyDir string, query string) error {
+	p := getProbe(r.Context(), src, keyDir)
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	group := ""
+	if len(subs) > 0 {
+		group = `,SUBTITLES="subs"`
+		for i, v := range subs {
+			fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"%s\",DEFAULT=%s,AUTOSELECT=YES,URI=\"?f=sub/%d.m3u8%s\"\n",
+				strings.ReplaceAll(v.Label, `"`, "'"), yesNo(i == 0), i, query)
+		}
+	}
+
+	for _, v := range renditions(p) {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d%s\n?f=%s/%s%s\n", bandwidth(v, p), group, v, playlistFile, query)
+	}
+
+	writePlaylist(w, b.String())
+	return nil
+}
+
+func yesNo(v bool) string {
+	if v {
+		return "YES"
+	}
+
+	return "NO"
+}
+
+func writePlaylist(w http.ResponseWriter, data string) {
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(data))
+}
+
+// getProbe reads the codec, height and duration of the source once per key,
+// unknown values stay zero when ffprobe is missing or fails.
+func getProbe(ctx context.Context, src *Source, keyDir string) (p probe) {
+	file := filepath.Join(keyDir, probeFile)
+	if data, err := os.ReadFile(file); err == nil && json.Unmarshal(data, &p) == nil {
+		return
+	}
+
+	if ffprobe == "" {
+		return
+	}
+
+	url, unpublish := publish(src)
+	defer unpublish()
+
+	ctx, cancel := context.WithTimeout(ctx, waitTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, ffprobe, "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=codec_name,height:format=duration,bit_rate", "-of", "json", url).Output()
+	if err != nil {
+		log.Warnf("ffprobe %s err: %+v", src.Key, err)
+		return
+	}
+
+	var result struct {
+		Streams []struct {
+			CodecName string `json:"codec_name"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+			BitRate  string `json:"bit_rate"`
+		} `json:"format"`
+	}
+
+	if json.Unmarshal(out, &result) != nil {
+		return
+	}
+
+	if len(result.Streams) > 0 {
+		p.Codec, p.Height = result.Streams[0].CodecName, result.Streams[0].Height
+	}
+
+	p.Duration, _ = strconv.ParseFloat(result.Format.Duration, 64)
+	p.BitRate, _ = strconv.ParseInt(result.Format.BitRate, 10, 64)
+	data, _ := json.Marshal(p)
+	os.WriteFile(file, data, 0644)
+	return
+}
+
+// renditions lists what the master playlist offers: the source remuxed when
+// browsers can play its codec, then every configured height below it.
+func renditions(p probe) []string {
+	var list []string
+	if p.Codec == "h264" {
+		list = append(list, sourceRendition)
+	}
+
+	for _, h := range cfg.Renditions {
+		if p.Height == 0 || h < p.Height || h == p.Height && p.Codec != "h264" {
+			list = append(list, strconv.Itoa(h)+"p")
+		}
+	}
+
+	if len(list) == 0 {
+		h := p.Height
+		if h == 0 {
+			h = 480
+		}
+
+		list = append(list, strconv.Itoa(h)+"p")
+	}
+
+	return list
+}
+
+func validRendition(p probe, name string) bool {
+	for _, v := range renditions(p) {
+		if v == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bandwidth estimates the bits per second of a rendition, players only use
+// it to pick one.
+func bandwidth(name string, p probe) int64 {
+	if name == sourceRendition && p.BitRate > 0 {
+		return p.BitRate
+	}
+
+	h := int64(p.Height)
+	if name != sourceRendition {
+		v, _ := strconv.Atoi(strings.TrimSuffix(name, "p"))
+		h = int64(v)
+	}
+
+	if h == 0 {
+		h = 720
+	}
+
+	return max(h*h*43/10, 400000) + 128000
+}
+
+// ensure starts ffmpeg for a rendition unless it completed before or is
+// running, the returned session is nil for a completed rendition.
+func ensure(src *Source, keyDir string, rendition string) (*session, error) {
+	dir := filepath.Join(keyDir, rendition)
+	if _, err := os.Stat(filepath.Join(dir, doneMarker)); err == nil {
+		return nil, nil
+	}
+
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	if s, ok := sessions[dir]; ok {
+		s.touch()
+		return s, nil
+	}
+
+	if len(sessions) >= cfg.MaxSessions {
+		return nil, ErrBusy
+	}
+
+	os.RemoveAll(dir)
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	url, unpublish := publish(src)
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, ffmpeg, transcodeArgs(url, dir, rendition)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Start()
+	if err != nil {
+		unpublish()
+		cancel()
+		return nil, err
+	}
+
+	s := &session{cancel: cancel, done: make(chan struct{})}
+	s.touch()
+	sessions[dir] = s
+	log.Infof("hls transcode %s [%s] started", src.Key, rendition)
+
+	go func() {
+		err := cmd.Wait()
+		unpublish()
+		if err == nil {
+			os.WriteFile(filepath.Join(dir, doneMarker), nil, 0644)
+			log.Infof("hls transcode %s [%s] done", src.Key, rendition)
+		} else {
+			if ctx.Err() == nil {
+				s.err = fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(stderr.String()))
+				log.Errorf("hls transcode %s [%s] err: %+v", src.Key, rendition, s.err)
+			} else {
+				s.err = context.Canceled
+			}
+
+			// A partial event playlist can't be resumed, the next request starts over
+			os.RemoveAll(dir)
+		}
+
+		sessionLock.Lock()
+		delete(sessions, dir)
+		sessionLock.Unlock()
+		cancel()
+		close(s.done)
+	}()
+
+	return s, nil
+}
+
+func transcodeArgs(url string, dir string, rendition string) []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-i", url,
+		"-map", "0:v:0", "-map", "0:a:0?", "-sn", "-dn"}
+	if rendition == sourceRendition {
+		args = append(args, "-c:v", "copy")
+	} else {
+		height, _ := strconv.Atoi(strings.TrimSuffix(rendition, "p"))
+		rate := bandwidth(rendition, probe{}) / 1000
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-profile:v", "main", "-pix_fmt", "yuv420p",
+			"-vf", fmt.Sprintf("scale=-2:%d", height),
+			"-b:v", fmt.Sprintf("%dk", rate), "-maxrate", fmt.Sprintf("%dk", rate*6/5), "-bufsize", fmt.Sprintf("%dk", rate*2),
+			"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", cfg.Segment))
+	}
+
+	return append(args, "-c:a", "aac", "-ac", "2", "-b:a", "128k",
+		"-f", "hls", "-hls_time", strconv.Itoa(cfg.Segment), "-hls_playlist_type", "event",
+		"-hls_flags", "temp_file+independent_segments",
+		"-hls_segment_filename", filepath.Join(dir, "seg%05d.ts"), filepath.Join(dir, playlistFile))
+}
+
+// waitFile polls for a file ffmpeg is about to write, s is nil when the
+// rendition is complete and nothing more will appear.
+func waitFile(ctx context.Context, s *session, file string) error {
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		if _, err := os.Stat(file); err == nil {
+			return nil
+		}
+
+		if s == nil {
+			return ErrNotFound
+		}
+
+		select {
+		case <-s.done:
+			if _, err := os.Stat(file); err == nil {
+				return nil
+			}
+
+			if s.err != nil {
+				return s.err
+			}
+
+			return ErrNotFound
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+
+		if time.Now().After(deadline) {
+			return ErrNotFound
+		}
+
+		s.touch()
+	}
+}
+
+func servePlaylist(w http.ResponseWriter, r *http.Request, src *Source, keyDir string, rendition string, query string) error {
+	if !validRendition(getProbe(r.Context(), src, keyDir), rendition) {
+		return ErrNotFound
+	}
+
+	s, err := ensure(src, keyDir, rendition)
+	if err != nil {
+		return err
+	}
+
+	file := filepath.Join(keyDir, rendition, playlistFile)
+	err = waitFile(r.Context(), s, file)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	// Segment URIs are relative to the rendition dir, players resolve them
+	// against the /hls/ URL instead
+	lines := strings.Split(string(data), "\n")
+	for i, v := range lines {
+		if v != "" && !strings.HasPrefix(v, "#") {
+			lines[i] = "?f=" + rendition + "/" + v + query
+		}
+	}
+
+	writePlaylist(w, strings.Join(lines, "\n"))
+	return nil
+}
+
+func serveSegment(w http.ResponseWriter, r *http.Request, src *Source, keyDir string, rendition string, name string) error {
+	file := filepath.Join(keyDir, rendition, name)
+	if _, err := os.Stat(file); err != nil {
+		if !validRendition(getProbe(r.Context(), src, keyDir), rendition) {
+			return ErrNotFound
+		}
+
+		s, err := ensure(src, keyDir, rendition)
+		if err != nil {
+			return err
+		}
+
+		err = waitFile(r.Context(), s, file)
+		if err != nil {
+			return err
+		}
+	} else {
+		sessionLock.Lock()
+		if s, ok := sessions[filepath.Join(keyDir, rendition)]; ok {
+			s.touch()
+		}
+		sessionLock.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeFile(w, r, file)
+	return nil
+}
+
+// janitor stops transcodes nobody watches and drops cached keys that went
+// unused for cache_hours.
+func janitor() {
+	for {
+		time.Sleep(janitorInterval)
+		idle := time.Now().Add(-time.Duration(cfg.IdleTimeout) * time.Second).Unix()
+		running := map[string]bool{}
+		sessionLock.Lock()
+		for dir, s := range sessions {
+			running[filepath.Dir(dir)] = true
+			if s.access.Load() < idle {
+				log.Infof("hls transcode %s idle, stopped", dir)
+				s.cancel()
+			}
+		}
+		sessionLock.Unlock()
+
+		expire := time.Now().Add(-time.Duration(cfg.CacheHours) * time.Hour)
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+
+		for _, v := range entries {
+			keyDir := filepath.Join(root, v.Name())
+			info, err := v.Info()
+			if err == nil && v.IsDir() && !running[keyDir] && info.ModTime().Before(expire) {
+				os.RemoveAll(keyDir)
+			}
+		}
+	}
+}
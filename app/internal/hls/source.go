@@ -0,0 +1,129 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bytes fetched per StreamRange call while ffmpeg reads the source
+const chunkSize = 4 << 20
+
+// Source is a video as the storage layer sees it, ffmpeg reads it through a
+// loopback HTTP server so every engine with StreamRange can be transcoded.
+type Source struct {
+	Key      string
+	Name     string
+	Size     int64
+	Modified time.Time
+	Range    func(ctx context.Context, offset int64, length int64, w io.Writer) error
+}
+
+var (
+	loopback   net.Listener
+	sourceLock sync.RWMutex
+	sources    = map[string]*Source{}
+)
+
+func startLoopback() error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	loopback = l
+	go http.Serve(l, http.HandlerFunc(serveSource))
+	return nil
+}
+
+// publish makes src readable by ffmpeg until the returned func is called.
+func publish(src *Source) (string, func()) {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	token := hex.EncodeToString(buf)
+
+	sourceLock.Lock()
+	sources[token] = src
+	sourceLock.Unlock()
+
+	url := "http://" + loopback.Addr().String() + "/" + token
+	return url, func() {
+		sourceLock.Lock()
+		delete(sources, token)
+		sourceLock.Unlock()
+	}
+}
+
+func serveSource(w http.ResponseWriter, r *http.Request) {
+	sourceLock.RLock()
+	src, ok := sources[strings.TrimPrefix(r.URL.Path, "/")]
+	sourceLock.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeContent(w, r, src.Name, src.Modified, &rangeReader{ctx: r.Context(), src: src})
+}
+
+// rangeReader turns StreamRange into an io.ReadSeeker, reads are served from
+// one buffered chunk at a time.
+type rangeReader struct {
+	ctx      context.Context
+	src      *Source
+	pos      int64
+	buf      []byte
+	bufStart int64
+}
+
+func (self *rangeReader) Read(p []byte) (int, error) {
+	if self.pos >= self.src.Size {
+		return 0, io.EOF
+	}
+
+	if self.pos < self.bufStart || self.pos >= self.bufStart+int64(len(self.buf)) {
+		length := min(int64(chunkSize), self.src.Size-self.pos)
+		var b bytes.Buffer
+		err := self.src.Range(self.ctx, self.pos, length, &b)
+		if err != nil {
+			return 0, err
+		}
+
+		if b.Len() == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+
+		self.buf, self.bufStart = b.Bytes(), self.pos
+	}
+
+	n := copy(p, self.buf[self.pos-self.bufStart:])
+	self.pos += int64(n)
+	return n, nil
+}
+
+func (self *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += self.pos
+	case io.SeekEnd:
+		offset += self.src.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	self.pos = offset
+	return offset, nil
+}
@@ -0,0 +1,181 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Subtitle files above this size are not converted
+const maxSubtitle = 10 << 20
+
+var (
+	SubtitleExts = []string{".vtt", ".srt", ".ass", ".ssa"}
+	srtTimeRegex = regexp.MustCompile(`(\d{2}:\d{2}:\d{2}),(\d{3})`)
+)
+
+// Subtitle is a text track sitting next to the video, Read writes the raw
+// file whatever its format.
+type Subtitle struct {
+	Name     string
+	Label    string
+	Modified time.Time
+	Read     func(w io.Writer) error
+}
+
+// MatchSubtitle tells if name is a subtitle of video, "movie.zh.srt" matches
+// "movie.mkv" with the label "zh".
+func MatchSubtitle(video string, name string) (string, bool) {
+	ext := strings.ToLower(path.Ext(name))
+	found := false
+	for _, v := range SubtitleExts {
+		if v == ext {
+			found = true
+			break
+		}
+	}
+
+	base := strings.TrimSuffix(video, path.Ext(video))
+	rest := strings.TrimSuffix(name, path.Ext(name))
+	if !found || !strings.HasPrefix(rest, base) {
+		return "", false
+	}
+
+	rest = rest[len(base):]
+	if rest == "" {
+		return "default", true
+	}
+
+	if rest[0] != '.' && rest[0] != '_' && rest[0] != '-' && rest[0] != ' ' {
+		return "", false
+	}
+
+	return rest[1:], true
+}
+
+func SortSubtitle(subs []Subtitle) {
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].Name < subs[j].Name
+	})
+}
+
+func serveSubtitle(w http.ResponseWriter, r *http.Request, src *Source, subs []Subtitle, keyDir string, name string, query string) error {
+	ext := path.Ext(name)
+	n, err := strconv.Atoi(strings.TrimSuffix(name, ext))
+	if err != nil || n < 0 || n >= len(subs) {
+		return ErrNotFound
+	}
+
+	switch ext {
+	case ".m3u8":
+		// One segment spanning the whole video, players fetch the vtt once
+		duration := getProbe(r.Context(), src, keyDir).Duration
+		if duration <= 0 {
+			duration = 86400
+		}
+
+		writePlaylist(w, fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXTINF:%.3f,\n?f=sub/%d.vtt%s\n#EXT-X-ENDLIST\n",
+			int(duration)+1, duration, n, query))
+		return nil
+	case ".vtt":
+		file, err := subtitleFile(r.Context(), keyDir, subs[n])
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		http.ServeFile(w, r, file)
+		return nil
+	}
+
+	return ErrNotFound
+}
+
+// subtitleFile converts a subtitle to WebVTT once, the cache file name
+// changes along with the subtitle's modified time.
+func subtitleFile(ctx context.Context, keyDir string, sub Subtitle) (string, error) {
+	file := filepath.Join(keyDir, "sub", hashKey(sub.Name, sub.Modified)+".vtt")
+	if _, err := os.Stat(file); err == nil {
+		return file, nil
+	}
+
+	raw := &limitBuffer{max: maxSubtitle}
+	err := sub.Read(raw)
+	if err != nil {
+		return "", err
+	}
+
+	var data []byte
+	switch strings.ToLower(path.Ext(sub.Name)) {
+	case ".vtt":
+		data = raw.Bytes()
+	case ".srt":
+		data = srtToVtt(raw.Bytes())
+	default:
+		data, err = assToVtt(ctx, raw)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(file), os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+
+	tmp := file + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return file, os.Rename(tmp, file)
+}
+
+func srtToVtt(data []byte) []byte {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = srtTimeRegex.ReplaceAll(data, []byte("$1.$2"))
+	return append([]byte("WEBVTT\n\n"), data...)
+}
+
+func assToVtt(ctx context.Context, in io.Reader) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, waitTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, ffmpeg, "-hide_banner", "-loglevel", "error", "-f", "ass", "-i", "pipe:0", "-f", "webvtt", "pipe:1")
+	cmd.Stdin = in
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+type limitBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (self *limitBuffer) Write(p []byte) (int, error) {
+	if self.Len()+len(p) > self.max {
+		return 0, fmt.Errorf("subtitle larger than %d bytes", self.max)
+	}
+
+	return self.Buffer.Write(p)
+}
@@ -27,6 +27,23 @@ func GenToken(username string, pwdStamp int64) (tokenString string, err error) {
 	return tokenString, err
 }
 
+// GenScopedToken mints a short-lived token that only the routes serving
+// audience accept, for clients such as EventSource that can only pass a
+// token in the query string.
+func GenScopedToken(username string, pwdStamp int64, audience string, ttl time.Duration) (string, error) {
+	claim := AppClaims{
+		Username: username,
+		PwdStamp: pwdStamp,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		}}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
+	return token.SignedString([]byte(conf.AppConf.Secure.JwtSecret))
+}
+
 func Secret() jwt.Keyfunc {
 	return func(token *jwt.Token) (interface{}, error) {
 		return []byte(conf.AppConf.Secure.JwtSecret), nil
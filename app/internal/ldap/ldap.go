@@ -0,0 +1,121 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"overlink.top/app/system/conf"
+	"strings"
+)
+
+var (
+	ErrUserNotFound = errors.New("ldap user not found")
+	ErrUserNotUniq  = errors.New("ldap user not unique")
+)
+
+type Entry struct {
+	DN     string
+	Groups []string
+}
+
+// Authenticate looks the user up with the service account and then binds
+// as the found DN to verify the password.
+func Authenticate(cfg conf.Ldap, username string, password string) (*Entry, error) {
+	if username == "" || password == "" {
+		return nil, ErrUserNotFound
+	}
+
+	conn, err := dial(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if cfg.BindDN != "" {
+		err = conn.Bind(cfg.BindDN, cfg.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap service bind err: %w", err)
+	}
+
+	filter := cfg.UserFilter
+	if filter == "" {
+		filter = "(uid=%s)"
+	}
+
+	groupAttr := cfg.GroupAttr
+	if groupAttr == "" {
+		groupAttr = "memberOf"
+	}
+
+	req := ldap.NewSearchRequest(
+		cfg.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		strings.ReplaceAll(filter, "%s", ldap.EscapeFilter(username)),
+		[]string{"dn", groupAttr},
+		nil,
+	)
+
+	result, err := conn.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("ldap search err: %w", err)
+	}
+
+	if len(result.Entries) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	if len(result.Entries) > 1 {
+		return nil, ErrUserNotUniq
+	}
+
+	entry := result.Entries[0]
+	err = conn.Bind(entry.DN, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Entry{
+		DN:     entry.DN,
+		Groups: entry.GetAttributeValues(groupAttr),
+	}, nil
+}
+
+func dial(cfg conf.Ldap) (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.SkipVerify}
+	conn, err := ldap.DialURL(cfg.Url, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("ldap dial err: %w", err)
+	}
+
+	if cfg.StartTLS {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap start tls err: %w", err)
+		}
+	}
+
+	return conn, nil
+}
+
+// InGroup reports whether any of the user groups matches one of the
+// configured group DNs (case-insensitive).
+func (self *Entry) InGroup(groups []string) bool {
+	for _, v := range groups {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		for _, g := range self.Groups {
+			if strings.EqualFold(v, g) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
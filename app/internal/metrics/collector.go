@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+// CacheStats is what a cache reports at scrape time, counters only grow
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+// StorageState is the health of one mount at scrape time
+type StorageState struct {
+	Mount    string
+	Engine   string
+	Disabled bool
+	Err      bool
+}
+
+var (
+	cacheHitsDesc      = prometheus.NewDesc(namespace+"_cache_hits_total", "Cache lookups that found an entry.", []string{"cache"}, nil)
+	cacheMissesDesc    = prometheus.NewDesc(namespace+"_cache_misses_total", "Cache lookups that found nothing.", []string{"cache"}, nil)
+	cacheEvictionsDesc = prometheus.NewDesc(namespace+"_cache_evictions_total", "Entries dropped to stay within the size limit.", []string{"cache"}, nil)
+	cacheSizeDesc      = prometheus.NewDesc(namespace+"_cache_entries", "Entries currently held, -1 when the backend can't tell.", []string{"cache"}, nil)
+	storageUpDesc      = prometheus.NewDesc(namespace+"_storage_up", "1 when the mount works, 0 when disabled or failing.", []string{"mount", "engine", "status"}, nil)
+)
+
+// cacheCollector reads every registered cache on scrape, one collector
+// serves them all since they share the same series.
+type cacheCollector struct {
+	lock    sync.RWMutex
+	sources map[string]func() CacheStats
+}
+
+var caches = &cacheCollector{sources: map[string]func() CacheStats{}}
+
+func (self *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheHitsDesc
+	ch <- cacheMissesDesc
+	ch <- cacheEvictionsDesc
+	ch <- cacheSizeDesc
+}
+
+func (self *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	for name, fn := range self.sources {
+		stats := fn()
+		ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(stats.Hits), name)
+		ch <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(stats.Misses), name)
+		ch <- prometheus.MustNewConstMetric(cacheEvictionsDesc, prometheus.CounterValue, float64(stats.Evictions), name)
+		ch <- prometheus.MustNewConstMetric(cacheSizeDesc, prometheus.GaugeValue, float64(stats.Size), name)
+	}
+}
+
+// RegisterCache exports the stats of a named cache, a later call with the
+// same name replaces the source.
+func RegisterCache(name string, fn func() CacheStats) {
+	caches.lock.Lock()
+	defer caches.lock.Unlock()
+
+	caches.sources[name] = fn
+}
+
+type storageCollector struct {
+	fn func() []StorageState
+}
+
+func (self *storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- storageUpDesc
+}
+
+func (self *storageCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, v := range self.fn() {
+		up, status := 1.0, "work"
+		if v.Disabled {
+			up, status = 0, "disabled"
+		} else if v.Err {
+			up, status = 0, "error"
+		}
+
+		ch <- prometheus.MustNewConstMetric(storageUpDesc, prometheus.GaugeValue, up, v.Mount, v.Engine, status)
+	}
+}
+
+// RegisterStorage exports the health of every mount, fn runs on each scrape.
+func RegisterStorage(fn func() []StorageState) {
+	Register(&storageCollector{fn: fn})
+}
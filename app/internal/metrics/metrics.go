@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+const namespace = "showta"
+
+var (
+	registry = prometheus.NewRegistry()
+
+	HttpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	HttpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	StreamBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stream_bytes_total",
+		Help:      "Bytes streamed to clients by ProxyFile, by mount.",
+	}, []string{"mount"})
+
+	ApiLimitRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "apilimit_rejected_total",
+		Help:      "Engine API calls refused by the rate limiter, by engine and bucket.",
+	}, []string{"engine", "bucket"})
+
+	EngineDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "engine_request_duration_seconds",
+		Help:      "Latency of remote engine API calls by engine, api and result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"engine", "api", "result"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HttpRequests,
+		HttpDuration,
+		StreamBytes,
+		ApiLimitRejected,
+		EngineDuration,
+		caches,
+	)
+}
+
+// Register adds collectors owned by other packages, duplicates are ignored
+// so callers can register from init or on reload.
+func Register(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		err := registry.Register(c)
+		if _, ok := err.(prometheus.AlreadyRegisteredError); err != nil && !ok {
+			panic(err)
+		}
+	}
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveEngine records one remote call of an engine, started at start.
+func ObserveEngine(engine string, api string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	EngineDuration.WithLabelValues(engine, api, result).Observe(time.Since(start).Seconds())
+}
+
+// CountWriter tallies the bytes written through it, callers add the total to
+// a counter once the stream ends to keep the hot path cheap.
+type CountWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (self *CountWriter) Write(p []byte) (int, error) {
+	n, err := self.ResponseWriter.Write(p)
+	self.n += int64(n)
+	return n, err
+}
+
+func (self *CountWriter) Flush() {
+	if f, ok := self.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (self *CountWriter) Unwrap() http.ResponseWriter {
+	return self.ResponseWriter
+}
+
+func (self *CountWriter) Count() int64 {
+	return self.n
+}
@@ -0,0 +1,286 @@
+package search
+
+import (
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Names shorter than a trigram can't use the full-text index and fall back
+// to LIKE.
+const minMatchLen = 3
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Entry is one file or folder seen by a crawler, paths are real paths of the
+// virtual tree.
+type Entry struct {
+	ID       uint   `gorm:"primaryKey"`
+	Mount    string `gorm:"index"`
+	Path     string `gorm:"uniqueIndex"`
+	Parent   string `gorm:"index"`
+	FileId   string
+	Name     string
+	Ext      string `gorm:"index"`
+	Size     int64
+	Modified int64 `gorm:"index"`
+	IsFolder bool
+}
+
+func (Entry) TableName() string {
+	return "search_entries"
+}
+
+type Query struct {
+	Name    string
+	Exts    []string
+	MinSize int64
+	MaxSize int64
+	Start   time.Time
+	End     time.Time
+	// Only entries below Prefix, used for jailed users
+	Prefix string
+	// Mounts left out, those the ACL denies the user
+	ExcludeMounts []string
+	// Folders left out, password folders still locked
+	ExcludeFolders []FolderExclusion
+	// "file", "folder" or empty for both
+	Type   string
+	Limit  int
+	Offset int
+}
+
+// FolderExclusion leaves out the entries directly in Folder, with Sub also
+// those further down except below the folders in Keep.
+type FolderExclusion struct {
+	Folder string
+	Sub    bool
+	Keep   []string
+}
+
+// Index keeps file names in a dedicated sqlite file, an FTS5 trigram table
+// mirrors the names so substring matches work for any script.
+type Index struct {
+	db *gorm.DB
+}
+
+func Open(path string) (*Index, error) {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, err
+	}
+
+	db.Exec("PRAGMA journal_mode=WAL;")
+	err = db.AutoMigrate(&Entry{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stmt := range []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS search_fts USING fts5(name, content='search_entries', content_rowid='id', tokenize='trigram')`,
+		`CREATE TRIGGER IF NOT EXISTS search_ai AFTER INSERT ON search_entries BEGIN
+			INSERT INTO search_fts(rowid, name) VALUES (new.id, new.name);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS search_ad AFTER DELETE ON search_entries BEGIN
+			INSERT INTO search_fts(search_fts, rowid, name) VALUES ('delete', old.id, old.name);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS search_au AFTER UPDATE OF name ON search_entries BEGIN
+			INSERT INTO search_fts(search_fts, rowid, name) VALUES ('delete', old.id, old.name);
+			INSERT INTO search_fts(rowid, name) VALUES (new.id, new.name);
+		END`,
+	} {
+		err = db.Exec(stmt).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Index{db: db}, nil
+}
+
+func (self *Index) Children(parent string) ([]Entry, error) {
+	var list []Entry
+	err := self.db.Where("parent = ?", parent).Find(&list).Error
+	return list, err
+}
+
+// Sync makes the indexed children of parent match list, entries that are
+// gone are removed together with everything below them.
+func (self *Index) Sync(parent string, list []Entry) error {
+	return self.db.Transaction(func(tx *gorm.DB) error {
+		var old []Entry
+		err := tx.Where("parent = ?", parent).Find(&old).Error
+		if err != nil {
+			return err
+		}
+
+		oldMap := make(map[string]Entry, len(old))
+		for _, v := range old {
+			oldMap[v.Path] = v
+		}
+
+		for _, v := range list {
+			prev, ok := oldMap[v.Path]
+			delete(oldMap, v.Path)
+			if ok {
+				v.ID = prev.ID
+				if v == prev {
+					continue
+				}
+			}
+
+			err = tx.Save(&v).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		for rpath := range oldMap {
+			err = deleteTree(tx, rpath)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Expire clears the mtime kept for paths so the next incremental crawl lists
+// them again, used when a crawl failed half way.
+func (self *Index) Expire(paths ...string) error {
+	return self.db.Model(&Entry{}).Where("path IN ?", paths).Update("modified", 0).Error
+}
+
+func (self *Index) DeleteTree(rpath string) error {
+	return deleteTree(self.db, rpath)
+}
+
+func deleteTree(db *gorm.DB, rpath string) error {
+	return db.Where(`path = ? OR path LIKE ? ESCAPE '\'`, rpath, likeEscaper.Replace(rpath)+"/%").Delete(&Entry{}).Error
+}
+
+// Retain drops the entries of every mount not in mounts.
+func (self *Index) Retain(mounts []string) error {
+	db := self.db
+	if len(mounts) > 0 {
+		db = db.Where("mount NOT IN ?", mounts)
+	} else {
+		db = db.Where("1 = 1")
+	}
+
+	return db.Delete(&Entry{}).Error
+}
+
+func (self *Index) Count() (int64, error) {
+	var count int64
+	err := self.db.Model(&Entry{}).Count(&count).Error
+	return count, err
+}
+
+// Search returns the matching entries, most recently modified first.
+func (self *Index) Search(q Query) ([]Entry, error) {
+	db := self.filter(q)
+	if q.Limit > 0 {
+		db = db.Limit(q.Limit)
+	}
+
+	if q.Offset > 0 {
+		db = db.Offset(q.Offset)
+	}
+
+	var list []Entry
+	err := db.Order("modified DESC, id DESC").Find(&list).Error
+	return list, err
+}
+
+// CountMatch returns how many entries Search would find without a limit.
+func (self *Index) CountMatch(q Query) (int64, error) {
+	var count int64
+	err := self.filter(q).Count(&count).Error
+	return count, err
+}
+
+func (self *Index) filter(q Query) *gorm.DB {
+	db := self.db.Model(&Entry{})
+	name := strings.TrimSpace(q.Name)
+	if utf8.RuneCountInString(name) >= minMatchLen {
+		phrase := `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+		db = db.Where("id IN (SELECT rowid FROM search_fts WHERE search_fts MATCH ?)", phrase)
+	} else if name != "" {
+		db = db.Where(`name LIKE ? ESCAPE '\'`, "%"+likeEscaper.Replace(name)+"%")
+	}
+
+	if len(q.Exts) > 0 {
+		db = db.Where("ext IN ?", q.Exts)
+	}
+
+	if q.MinSize > 0 {
+		db = db.Where("size >= ?", q.MinSize)
+	}
+
+	if q.MaxSize > 0 {
+		db = db.Where("size <= ?", q.MaxSize)
+	}
+
+	if !q.Start.IsZero() {
+		db = db.Where("modified >= ?", q.Start.Unix())
+	}
+
+	if !q.End.IsZero() {
+		db = db.Where("modified <= ?", q.End.Unix())
+	}
+
+	if q.Prefix != "" {
+		db = db.Where(`path LIKE ? ESCAPE '\'`, likeEscaper.Replace(q.Prefix)+"/%")
+	}
+
+	if len(q.ExcludeMounts) > 0 {
+		db = db.Where("mount NOT IN ?", q.ExcludeMounts)
+	}
+
+	for _, v := range q.ExcludeFolders {
+		cond := "parent = ?"
+		args := []interface{}{v.Folder}
+		if v.Sub {
+			sub := `parent LIKE ? ESCAPE '\'`
+			args = append(args, likeEscaper.Replace(v.Folder)+"/%")
+			for _, keep := range v.Keep {
+				sub += ` AND parent <> ? AND parent NOT LIKE ? ESCAPE '\'`
+				args = append(args, keep, likeEscaper.Replace(keep)+"/%")
+			}
+
+			cond += " OR (" + sub + ")"
+		}
+
+		db = db.Where("NOT ("+cond+")", args...)
+	}
+
+	switch q.Type {
+	case "file":
+		db = db.Where("is_folder = ?", false)
+	case "folder":
+		db = db.Where("is_folder = ?", true)
+	}
+
+	return db
+}
+
+func (self *Index) Close() error {
+	sqlDB, err := self.db.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.Close()
+}
@@ -58,3 +58,17 @@ func Verify(rpath string, data string) error {
 
 	return nil
 }
+
+// Check is Verify without the shortcut for links that never expire, it tells
+// whether the signature was really minted for rpath.
+func Check(rpath string, data string) error {
+	if conf.SignExpiration > 0 {
+		return Verify(rpath, data)
+	}
+
+	if data == "" || !hmac.Equal([]byte(Gen(rpath, "")), []byte(data)) {
+		return ErrInvalidSign
+	}
+
+	return nil
+}
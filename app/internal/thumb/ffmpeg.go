@@ -0,0 +1,70 @@
+package thumb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ffmpegTimeout = 30 * time.Second
+
+// Seconds into the video tried for the poster, later offsets skip black intro
+// frames and zero covers clips shorter than that.
+var posterOffsets = []string{"3", "0"}
+
+func runFfmpeg(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, ffmpegTimeout)
+	defer cancel()
+
+	base := []string{"-hide_banner", "-loglevel", "error"}
+	if stdin == nil {
+		base = append(base, "-nostdin")
+	}
+
+	cmd := exec.CommandContext(ctx, ffmpeg, append(base, args...)...)
+	cmd.Stdin = stdin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func makePoster(ctx context.Context, file string, input string, size int, format string) error {
+	scale := fmt.Sprintf("scale=w=%d:h=%d:force_original_aspect_ratio=decrease", size, size)
+	codec := []string{"-c:v", "mjpeg", "-q:v", strconv.Itoa(jpegQscale(cfg.Quality)), "-f", "image2"}
+	if format == FormatWebp {
+		codec = []string{"-c:v", "libwebp", "-quality", strconv.Itoa(cfg.Quality), "-f", "webp"}
+	}
+
+	var data []byte
+	var err error
+	for _, offset := range posterOffsets {
+		args := append([]string{"-ss", offset, "-i", input, "-frames:v", "1", "-vf", scale}, codec...)
+		data, err = runFfmpeg(ctx, nil, append(args, "pipe:1")...)
+		if err == nil && len(data) > 0 {
+			return writeFile(file, data)
+		}
+	}
+
+	if err == nil {
+		err = fmt.Errorf("ffmpeg: no frame decoded from %s", input)
+	}
+
+	return err
+}
+
+// jpegQscale maps a 1-100 quality onto the 2-31 qscale of the mjpeg encoder,
+// lower is better.
+func jpegQscale(quality int) int {
+	return 2 + (100-quality)*29/100
+}
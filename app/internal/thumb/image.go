@@ -0,0 +1,98 @@
+package thumb
+
+import (
+	"bytes"
+	"context"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+)
+
+// Pictures above this many pixels are refused before decoding, a small file
+// may still expand to gigabytes.
+const maxPixels = 64 << 20
+
+// LimitWriter buffers the original picture and fails once it grows past the
+// configured max_source.
+type LimitWriter struct {
+	bytes.Buffer
+	max int
+}
+
+func (self *LimitWriter) Write(p []byte) (int, error) {
+	if self.Len()+len(p) > self.max {
+		return 0, ErrTooLarge
+	}
+
+	return self.Buffer.Write(p)
+}
+
+func makeImage(ctx context.Context, file string, size int, format string, read func(w *LimitWriter) error) error {
+	w := &LimitWriter{max: cfg.MaxSource << 20}
+	err := read(w)
+	if err != nil {
+		return err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(w.Bytes()))
+	if err != nil {
+		return ErrUnsupported
+	}
+
+	if config.Width*config.Height > maxPixels {
+		return ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(w.Bytes()))
+	if err != nil {
+		return err
+	}
+
+	dst := resize(src, size)
+	var out bytes.Buffer
+	if format == FormatWebp {
+		err = png.Encode(&out, dst)
+		if err != nil {
+			return err
+		}
+
+		data, err := runFfmpeg(ctx, &out, "-f", "png_pipe", "-i", "pipe:0", "-c:v", "libwebp", "-quality", strconv.Itoa(cfg.Quality), "-f", "webp", "pipe:1")
+		if err != nil {
+			return err
+		}
+
+		return writeFile(file, data)
+	}
+
+	err = jpeg.Encode(&out, dst, &jpeg.Options{Quality: cfg.Quality})
+	if err != nil {
+		return err
+	}
+
+	return writeFile(file, out.Bytes())
+}
+
+// resize scales src to fit in a size x size box, pictures are never
+// enlarged. Transparent areas turn white since JPEG has no alpha.
+func resize(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/width)
+		} else {
+			width, height = max(1, width*size/height), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+	return dst
+}
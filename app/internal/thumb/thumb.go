@@ -0,0 +1,243 @@
+package thumb
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"overlink.top/app/system/conf"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	FormatJpeg = "jpeg"
+	FormatWebp = "webp"
+
+	cleanInterval = 24 * time.Hour
+)
+
+var (
+	ErrDisabled    = errors.New("thumbnails are disabled")
+	ErrUnsupported = errors.New("no thumbnail for this file type")
+	ErrNoFfmpeg    = errors.New("ffmpeg is not available")
+	ErrTooLarge    = errors.New("file is too large for a thumbnail")
+
+	// Requested sizes are rounded up to one of these to bound the cache
+	Sizes = []int{128, 256, 512, 1024}
+)
+
+var (
+	cfg      conf.Thumb
+	dir      string
+	ffmpeg   string
+	workers  chan struct{}
+	lock     sync.Mutex
+	inflight = map[string]*call{}
+)
+
+type call struct {
+	done chan struct{}
+	err  error
+}
+
+func Init(c conf.Thumb) error {
+	cfg = c
+	if cfg.Disable {
+		return nil
+	}
+
+	if cfg.Path == "" {
+		cfg.Path = "runtime/thumbs"
+	}
+
+	if cfg.Workers < 1 {
+		cfg.Workers = 2
+	}
+
+	if cfg.MaxSource < 1 {
+		cfg.MaxSource = 50
+	}
+
+	if cfg.Quality < 1 || cfg.Quality > 100 {
+		cfg.Quality = 80
+	}
+
+	dir = conf.AbsPath(cfg.Path)
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	ffmpeg = cfg.Ffmpeg
+	if ffmpeg == "" {
+		ffmpeg, _ = exec.LookPath("ffmpeg")
+	}
+
+	workers = make(chan struct{}, cfg.Workers)
+	go clean()
+	return nil
+}
+
+// Ffmpeg returns the ffmpeg binary in use, empty when there is none.
+func Ffmpeg() string {
+	return ffmpeg
+}
+
+// Size rounds a requested edge length up to one of Sizes.
+func Size(size int) int {
+	if size <= 0 {
+		return Sizes[1]
+	}
+
+	for _, v := range Sizes {
+		if size <= v {
+			return v
+		}
+	}
+
+	return Sizes[len(Sizes)-1]
+}
+
+// Image returns the cached thumbnail of a picture, read writes the original
+// and only runs when the thumbnail isn't cached yet.
+func Image(ctx context.Context, key string, modified time.Time, size int, format string, read func(w *LimitWriter) error) (string, error) {
+	if workers == nil {
+		return "", ErrDisabled
+	}
+
+	format = outFormat(format)
+	file := cachePath(key, modified, size, format)
+	return file, generate(ctx, file, func() error {
+		return makeImage(ctx, file, size, format, read)
+	})
+}
+
+// Video returns the cached poster frame of a video, input is a local path or
+// a URL ffmpeg can read.
+func Video(ctx context.Context, key string, modified time.Time, size int, format string, input func() (string, error)) (string, error) {
+	if workers == nil {
+		return "", ErrDisabled
+	}
+
+	if ffmpeg == "" {
+		return "", ErrNoFfmpeg
+	}
+
+	format = outFormat(format)
+	file := cachePath(key, modified, size, format)
+	return file, generate(ctx, file, func() error {
+		src, err := input()
+		if err != nil {
+			return err
+		}
+
+		return makePoster(ctx, file, src, size, format)
+	})
+}
+
+// outFormat falls back to JPEG when WebP can't be encoded, there is no pure
+// Go WebP encoder.
+func outFormat(format string) string {
+	if format == FormatWebp && ffmpeg != "" {
+		return FormatWebp
+	}
+
+	return FormatJpeg
+}
+
+func cachePath(key string, modified time.Time, size int, format string) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%d|%d", key, modified.Unix(), size)))
+	name := hex.EncodeToString(sum[:])
+	ext := ".jpg"
+	if format == FormatWebp {
+		ext = ".webp"
+	}
+
+	return filepath.Join(dir, name[:2], name+ext)
+}
+
+// generate runs fn on a worker unless file is cached, callers asking for the
+// same file at the same time share one run.
+func generate(ctx context.Context, file string, fn func() error) error {
+	if _, err := os.Stat(file); err == nil {
+		now := time.Now()
+		os.Chtimes(file, now, now)
+		return nil
+	}
+
+	lock.Lock()
+	c, ok := inflight[file]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		inflight[file] = c
+	}
+	lock.Unlock()
+
+	if ok {
+		select {
+		case <-c.done:
+			return c.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	defer func() {
+		lock.Lock()
+		delete(inflight, file)
+		lock.Unlock()
+		close(c.done)
+	}()
+
+	select {
+	case workers <- struct{}{}:
+	case <-ctx.Done():
+		c.err = ctx.Err()
+		return c.err
+	}
+	defer func() { <-workers }()
+
+	err := os.MkdirAll(filepath.Dir(file), os.ModePerm)
+	if err == nil {
+		err = fn()
+	}
+
+	c.err = err
+	return err
+}
+
+// writeFile moves data in place through a temporary file so readers never
+// see a partial thumbnail.
+func writeFile(file string, data []byte) error {
+	tmp := file + ".tmp"
+	err := os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
+
+// clean drops thumbnails nobody asked for within the retention days, a hit
+// refreshes the mtime of a file.
+func clean() {
+	for {
+		if days := cfg.RetentionDays; days > 0 {
+			expire := time.Now().AddDate(0, 0, -days)
+			filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() && info.ModTime().Before(expire) {
+					os.Remove(file)
+				}
+
+				return nil
+			})
+		}
+
+		time.Sleep(cleanInterval)
+	}
+}
@@ -0,0 +1,112 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"overlink.top/app/system/conf"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newCollector stands in for an OTLP/HTTP collector, every export request
+// body is passed on to the returned channel.
+func newCollector(t *testing.T) (*httptest.Server, chan []byte) {
+	received := make(chan []byte, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read export body: %v", err)
+		}
+
+		received <- body
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, received
+}
+
+func TestInitExportsSpans(t *testing.T) {
+	srv, received := newCollector(t)
+	shutdown, err := Init(conf.Trace{
+		Enable:      true,
+		Endpoint:    strings.TrimPrefix(srv.URL, "http://"),
+		Insecure:    true,
+		ServiceName: "showta-test",
+	})
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	ctx, span := Start(context.Background(), "test.parent", attribute.String("path", "/a"))
+	_, child := Start(ctx, "test.child")
+	End(child, errors.New("boom"))
+	End(span, nil)
+
+	// Shutdown flushes the batch, nothing is sent before that
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	var all []byte
+	for len(received) > 0 {
+		all = append(all, <-received...)
+	}
+
+	if len(all) == 0 {
+		t.Fatal("collector received no spans")
+	}
+
+	for _, v := range []string{"test.parent", "test.child", "showta-test", "boom"} {
+		if !bytes.Contains(all, []byte(v)) {
+			t.Errorf("exported spans lack %q", v)
+		}
+	}
+}
+
+func TestInitDisabled(t *testing.T) {
+	shutdown, err := Init(conf.Trace{})
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+}
+
+func TestStartServerContinuesTrace(t *testing.T) {
+	if _, err := Init(conf.Trace{}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	r := httptest.NewRequest(http.MethodGet, "/fd/a", nil)
+	r.Header.Set("traceparent", "00-"+traceId+"-00f067aa0ba902b7-01")
+	ctx, span := StartServer(r, "test.server")
+	defer span.End()
+
+	if got, _ := Ids(ctx); got != traceId {
+		t.Errorf("trace id = %q, want %q", got, traceId)
+	}
+
+	header := http.Header{}
+	Inject(ctx, header)
+	if !strings.Contains(header.Get("traceparent"), traceId) {
+		t.Errorf("injected traceparent %q lacks %s", header.Get("traceparent"), traceId)
+	}
+}
@@ -323,11 +323,11 @@ func (m *benchmarkMockStorage) Mount() error {
 	return nil
 }
 
-func (m *benchmarkMockStorage) List(info msg.Finfo) ([]msg.Finfo, error) {
+func (m *benchmarkMockStorage) List(ctx context.Context, info msg.Finfo) ([]msg.Finfo, error) {
 	return nil, nil
 }
 
-func (m *benchmarkMockStorage) Link(info msg.Finfo) (*msg.LinkInfo, error) {
+func (m *benchmarkMockStorage) Link(ctx context.Context, info msg.Finfo) (*msg.LinkInfo, error) {
 	path := info.GetPath()
 	if _, exists := m.files[path]; exists {
 		return &msg.LinkInfo{Url: "mock://" + path}, nil
@@ -366,4 +366,63 @@ func (m *benchmarkMockStorage) StreamRange(ctx context.Context, path string, off
 		return err
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// BenchmarkFileInfoCacheSetFull benchmarks inserts into a full cache, every
+// insert evicts the least recently used entry
+func BenchmarkFileInfoCacheSetFull(b *testing.B) {
+	testCache := NewFileInfoCache(5*time.Minute, 10000)
+	mockInfo := &mockFileInfo{name: "file.txt", size: 1024, path: "/test/file.txt"}
+	paths := make([]string, 20000)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/test/full/file_%d.txt", i)
+	}
+
+	for i := 0; i < 10000; i++ {
+		testCache.SetFile(paths[i], mockInfo)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		testCache.SetFile(paths[i%len(paths)], mockInfo)
+	}
+}
+
+// BenchmarkFileInfoCacheInvalidate benchmarks invalidating entries of a full cache
+func BenchmarkFileInfoCacheInvalidate(b *testing.B) {
+	testCache := NewFileInfoCache(5*time.Minute, 10000)
+	mockInfo := &mockFileInfo{name: "file.txt", size: 1024, path: "/test/file.txt"}
+	paths := make([]string, 10000)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/test/invalidate/file_%d.txt", i)
+		testCache.SetFile(paths[i], mockInfo)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		path := paths[i%len(paths)]
+		testCache.Invalidate(path)
+		testCache.SetFile(path, mockInfo)
+	}
+}
+
+// BenchmarkFileInfoCacheParallelGet benchmarks concurrent lookups, as seen
+// with several clients running deep PROPFINDs
+func BenchmarkFileInfoCacheParallelGet(b *testing.B) {
+	testCache := NewFileInfoCache(5*time.Minute, 10000)
+	mockInfo := &mockFileInfo{name: "file.txt", size: 1024, path: "/test/file.txt"}
+	paths := make([]string, 10000)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/test/parallel/file_%d.txt", i)
+		testCache.SetFile(paths[i], mockInfo)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			testCache.GetFile(paths[i%len(paths)])
+			i++
+		}
+	})
+}
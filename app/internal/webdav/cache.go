@@ -1,183 +1,258 @@
 package webdav
 
 import (
+	"container/list"
 	"context"
+	"overlink.top/app/internal/cache"
+	"overlink.top/app/internal/metrics"
 	"overlink.top/app/system/conf"
+	"overlink.top/app/system/logic"
 	"overlink.top/app/system/msg"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// FileInfoCache caches file information to eliminate double lookups
+const (
+	cacheShardCount = 16
+	// Below this many entries per shard a single shard is used, so small
+	// caches still evict in exact LRU order
+	cacheMinShardSize = 256
+)
+
+// FileInfoCache caches file information to eliminate double lookups. Entries
+// are spread over shards by path, each shard is an LRU of its own.
 type FileInfoCache struct {
-	cache   map[string]*cachedItem
-	mutex   sync.RWMutex
-	ttl     time.Duration
+	shards    []*cacheShard
+	ttl       time.Duration
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// CacheStats is a snapshot of the cache counters, they only grow
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+type cacheShard struct {
+	mutex   sync.Mutex
+	items   map[string]*list.Element
+	lru     *list.List // Front is the most recently used
 	maxSize int
-	order   []string // Track insertion order for LRU eviction
 }
 
 // cachedItem wraps cached data with expiration time
 type cachedItem struct {
-	fileInfo   msg.Finfo        // For individual files
-	dirList    []msg.Finfo      // For directory listings
-	isDirList  bool             // Flag to indicate if this is a directory listing
+	path       string
+	fileInfo   msg.Finfo   // For individual files
+	dirList    []msg.Finfo // For directory listings
+	isDirList  bool        // Flag to indicate if this is a directory listing
 	expireTime time.Time
 }
 
 // NewFileInfoCache creates a new file info cache with the specified TTL and max size
 func NewFileInfoCache(ttl time.Duration, maxSize int) *FileInfoCache {
-	return &FileInfoCache{
-		cache:   make(map[string]*cachedItem),
-		ttl:     ttl,
-		maxSize: maxSize,
-		order:   make([]string, 0),
+	count := 1
+	if maxSize <= 0 || maxSize >= cacheShardCount*cacheMinShardSize {
+		count = cacheShardCount
 	}
-}
 
-// GetFile retrieves file info from cache if it exists and hasn't expired
-func (c *FileInfoCache) GetFile(path string) (msg.Finfo, bool) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
+	c := &FileInfoCache{
+		shards: make([]*cacheShard, count),
+		ttl:    ttl,
+	}
+
+	shardSize := 0
+	if maxSize > 0 {
+		shardSize = (maxSize + count - 1) / count
+	}
 
-	if item, exists := c.cache[path]; exists && !item.isDirList {
-		if time.Now().Before(item.expireTime) {
-			return item.fileInfo, true
+	for i := range c.shards {
+		c.shards[i] = &cacheShard{
+			items:   make(map[string]*list.Element),
+			lru:     list.New(),
+			maxSize: shardSize,
 		}
-		// Item expired, remove it
-		go c.remove(path)
 	}
 
-	return nil, false
+	return c
 }
 
-// GetDirList retrieves directory listing from cache if it exists and hasn't expired
-func (c *FileInfoCache) GetDirList(path string) ([]msg.Finfo, bool) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
+func (c *FileInfoCache) shard(path string) *cacheShard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
 
-	if item, exists := c.cache[path]; exists && item.isDirList {
-		if time.Now().Before(item.expireTime) {
-			return item.dirList, true
-		}
-		// Item expired, remove it
-		go c.remove(path)
+	// Inline FNV-1a, hash/fnv would allocate on every lookup
+	h := uint32(2166136261)
+	for i := 0; i < len(path); i++ {
+		h ^= uint32(path[i])
+		h *= 16777619
 	}
 
-	return nil, false
+	return c.shards[h%uint32(len(c.shards))]
 }
 
-// SetFile stores file info in cache with expiration
-func (c *FileInfoCache) SetFile(path string, info msg.Finfo) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+// get returns the live entry of the wanted kind and marks it recently used
+func (c *FileInfoCache) get(path string, isDirList bool) (*cachedItem, bool) {
+	s := c.shard(path)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	// Remove the path from order if it already exists
-	c.removeFromOrder(path)
+	elem, exists := s.items[path]
+	if !exists {
+		c.misses.Add(1)
+		return nil, false
+	}
 
-	// Add to cache
-	c.cache[path] = &cachedItem{
-		fileInfo:   info,
-		isDirList:  false,
-		expireTime: time.Now().Add(c.ttl),
+	item := elem.Value.(*cachedItem)
+	if !time.Now().Before(item.expireTime) {
+		s.removeElement(elem)
+		c.misses.Add(1)
+		return nil, false
 	}
 
-	// Add to order
-	c.order = append(c.order, path)
+	if item.isDirList != isDirList {
+		c.misses.Add(1)
+		return nil, false
+	}
 
-	// Evict oldest items if cache is too large
-	c.evictIfNeeded()
+	s.lru.MoveToFront(elem)
+	c.hits.Add(1)
+	return item, true
 }
 
-// SetDirList stores directory listing in cache with expiration
-func (c *FileInfoCache) SetDirList(path string, list []msg.Finfo) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+// GetFile retrieves file info from cache if it exists and hasn't expired
+func (c *FileInfoCache) GetFile(path string) (msg.Finfo, bool) {
+	item, ok := c.get(path, false)
+	if !ok {
+		return nil, false
+	}
 
-	// Remove the path from order if it already exists
-	c.removeFromOrder(path)
+	return item.fileInfo, true
+}
 
-	// Add to cache
-	c.cache[path] = &cachedItem{
-		dirList:    list,
-		isDirList:  true,
-		expireTime: time.Now().Add(c.ttl),
+// GetDirList retrieves directory listing from cache if it exists and hasn't expired
+func (c *FileInfoCache) GetDirList(path string) ([]msg.Finfo, bool) {
+	item, ok := c.get(path, true)
+	if !ok {
+		return nil, false
 	}
 
-	// Add to order
-	c.order = append(c.order, path)
-
-	// Evict oldest items if cache is too large
-	c.evictIfNeeded()
+	return item.dirList, true
 }
 
-// Remove removes an item from cache
-func (c *FileInfoCache) remove(path string) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+func (c *FileInfoCache) set(item *cachedItem) {
+	item.expireTime = time.Now().Add(c.ttl)
+	s := c.shard(item.path)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	delete(c.cache, path)
+	if elem, exists := s.items[item.path]; exists {
+		elem.Value = item
+		s.lru.MoveToFront(elem)
+		return
+	}
+
+	s.items[item.path] = s.lru.PushFront(item)
+	if s.maxSize <= 0 {
+		return // No size limit
+	}
+
+	for s.lru.Len() > s.maxSize {
+		s.removeElement(s.lru.Back())
+		c.evictions.Add(1)
+	}
 }
 
-// Clear removes all items from cache
-func (c *FileInfoCache) Clear() {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+// SetFile stores file info in cache with expiration
+func (c *FileInfoCache) SetFile(path string, info msg.Finfo) {
+	c.set(&cachedItem{path: path, fileInfo: info})
+}
 
-	c.cache = make(map[string]*cachedItem)
-	c.order = make([]string, 0)
+// SetDirList stores directory listing in cache with expiration
+func (c *FileInfoCache) SetDirList(path string, list []msg.Finfo) {
+	c.set(&cachedItem{path: path, dirList: list, isDirList: true})
 }
 
-// removeFromOrder removes a path from the order slice
-func (c *FileInfoCache) removeFromOrder(path string) {
-	for i, p := range c.order {
-		if p == path {
-			// Remove the element at index i
-			c.order = append(c.order[:i], c.order[i+1:]...)
-			break
-		}
-	}
+func (s *cacheShard) removeElement(elem *list.Element) {
+	s.lru.Remove(elem)
+	delete(s.items, elem.Value.(*cachedItem).path)
 }
 
-// evictIfNeeded removes the oldest items if cache exceeds maxSize
-func (c *FileInfoCache) evictIfNeeded() {
-	if c.maxSize <= 0 {
-		return // No size limit
+// removeIf drops every entry whose path matches, shard by shard
+func (c *FileInfoCache) removeIf(match func(path string) bool) {
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		for path, elem := range s.items {
+			if match(path) {
+				s.removeElement(elem)
+			}
+		}
+		s.mutex.Unlock()
 	}
+}
 
-	for len(c.cache) > c.maxSize && len(c.order) > 0 {
-		// Remove the oldest item (first in order)
-		oldestPath := c.order[0]
-		delete(c.cache, oldestPath)
-		c.order = c.order[1:]
+// Clear removes all items from cache
+func (c *FileInfoCache) Clear() {
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		s.items = make(map[string]*list.Element)
+		s.lru.Init()
+		s.mutex.Unlock()
 	}
 }
 
 // Invalidate removes a specific item from cache
 func (c *FileInfoCache) Invalidate(path string) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+	s := c.shard(path)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	delete(c.cache, path)
-	c.removeFromOrder(path)
+	if elem, exists := s.items[path]; exists {
+		s.removeElement(elem)
+	}
+}
+
+// InvalidateTree removes an item and everything below it
+func (c *FileInfoCache) InvalidateTree(prefix string) {
+	c.removeIf(func(path string) bool {
+		return path == prefix || prefix == "/" || strings.HasPrefix(path, prefix+"/") || strings.HasPrefix(path, prefix+"#")
+	})
 }
 
 // InvalidatePattern removes items from cache that match a pattern
 func (c *FileInfoCache) InvalidatePattern(pattern string) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+	c.removeIf(func(path string) bool {
+		return strings.Contains(path, pattern)
+	})
+}
 
-	pathsToRemove := make([]string, 0)
-	for path := range c.cache {
-		if strings.Contains(path, pattern) {
-			pathsToRemove = append(pathsToRemove, path)
-		}
+// Len returns the number of entries, expired ones not yet touched included
+func (c *FileInfoCache) Len() int {
+	size := 0
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		size += s.lru.Len()
+		s.mutex.Unlock()
 	}
 
-	for _, path := range pathsToRemove {
-		delete(c.cache, path)
-		c.removeFromOrder(path)
+	return size
+}
+
+// Stats returns the hit, miss and eviction counters with the current size
+func (c *FileInfoCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      c.Len(),
 	}
 }
 
@@ -198,6 +273,48 @@ func init() {
 	}
 
 	globalFileInfoCache = NewFileInfoCache(ttl, maxSize)
+	cache.OnInvalidate(invalidateLocal)
+	metrics.RegisterCache("webdav", func() metrics.CacheStats {
+		return metrics.CacheStats(FileInfoCacheStats())
+	})
+}
+
+// invalidateLocal follows invalidations from the shared cache, the root
+// listing is kept per user so all of its variants go.
+func invalidateLocal(rpath string, subtree bool) {
+	if subtree {
+		globalFileInfoCache.InvalidateTree(rpath)
+		return
+	}
+
+	globalFileInfoCache.Invalidate(rpath)
+	if rpath == "/" {
+		globalFileInfoCache.InvalidatePattern("/#")
+	}
+}
+
+// invalidatePath drops a changed entry and its parent listing from every
+// cache layer, the file API included.
+func invalidatePath(ctx context.Context, reqPath string) {
+	cache.InvalidatePath(logic.RealPath(ctx, reqPath))
+}
+
+func invalidateTree(ctx context.Context, reqPath string) {
+	cache.InvalidateTree(logic.RealPath(ctx, reqPath))
+}
+
+// cacheKey scopes cache entries to what the request identity can see: paths
+// are mapped into the user's home directory and the ACL filtered mount list
+// at the root is kept per user.
+func cacheKey(ctx context.Context, reqPath string) string {
+	key := logic.RealPath(ctx, reqPath)
+	if key == "/" {
+		if user := logic.Identity(ctx); user != nil {
+			key += "#" + strconv.FormatUint(uint64(user.ID), 10)
+		}
+	}
+
+	return key
 }
 
 // GetCachedFileInfo retrieves file info from cache or fetches it
@@ -242,4 +359,9 @@ func InvalidateCache(path string) {
 // InvalidateCachePattern removes items from cache that match a pattern
 func InvalidateCachePattern(pattern string) {
 	globalFileInfoCache.InvalidatePattern(pattern)
+}
+
+// FileInfoCacheStats exposes the counters of the global WebDAV cache for metrics
+func FileInfoCacheStats() CacheStats {
+	return globalFileInfoCache.Stats()
 }
\ No newline at end of file
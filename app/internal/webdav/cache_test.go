@@ -268,4 +268,35 @@ func TestWebDAVCacheSizeLimit(t *testing.T) {
 			t.Errorf("Expected item %s to be in cache", path)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestWebDAVCacheLRUOrder(t *testing.T) {
+	// Reading an entry should protect it from the next eviction
+	testCache := NewFileInfoCache(5*time.Minute, 3)
+	for i := 0; i < 3; i++ {
+		path := fmt.Sprintf("/test/lru_%d.txt", i)
+		testCache.SetFile(path, &mockFileInfo{name: fmt.Sprintf("lru_%d.txt", i), path: path})
+	}
+
+	// Touch the oldest item, the second one becomes the eviction candidate
+	if _, found := testCache.GetFile("/test/lru_0.txt"); !found {
+		t.Fatal("Expected /test/lru_0.txt to be found in cache")
+	}
+
+	testCache.SetFile("/test/lru_3.txt", &mockFileInfo{name: "lru_3.txt", path: "/test/lru_3.txt"})
+
+	if _, found := testCache.GetFile("/test/lru_1.txt"); found {
+		t.Error("Expected least recently used item /test/lru_1.txt to be evicted")
+	}
+
+	for _, path := range []string{"/test/lru_0.txt", "/test/lru_2.txt", "/test/lru_3.txt"} {
+		if _, found := testCache.GetFile(path); !found {
+			t.Errorf("Expected item %s to be in cache", path)
+		}
+	}
+
+	stats := testCache.Stats()
+	if stats.Hits != 4 || stats.Misses != 1 || stats.Evictions != 1 || stats.Size != 3 {
+		t.Errorf("Unexpected cache stats: %+v", stats)
+	}
+}
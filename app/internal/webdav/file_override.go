@@ -45,7 +45,8 @@ func walkFSOverride(ctx context.Context, fs FileSystem, depth int, name string,
 
 	// Read directory names.
 	// Try to get directory listing from cache first
-	fileInfos, err := GetCachedDirList(ctx, name)
+	key := cacheKey(ctx, name)
+	fileInfos, err := GetCachedDirList(ctx, key)
 	if err != nil || fileInfos == nil {
 		// Not in cache, fetch it
 		fileInfos, err = logic.ListFile(ctx, name)
@@ -53,7 +54,7 @@ func walkFSOverride(ctx context.Context, fs FileSystem, depth int, name string,
 			return walkFn(name, info, err)
 		}
 		// Cache the directory listing for future use
-		CacheDirList(name, fileInfos)
+		CacheDirList(key, fileInfos)
 	}
 
 	// Sort file infos by name for consistent ordering
@@ -94,7 +95,8 @@ func getPagedDirectoryListing(ctx context.Context, name string, page, pageSize i
 	}
 
 	// Try to get directory listing from cache first
-	fileInfos, err := GetCachedDirList(ctx, name)
+	key := cacheKey(ctx, name)
+	fileInfos, err := GetCachedDirList(ctx, key)
 	if err != nil || fileInfos == nil {
 		// Not in cache, fetch it
 		fileInfos, err = logic.ListFile(ctx, name)
@@ -102,7 +104,7 @@ func getPagedDirectoryListing(ctx context.Context, name string, page, pageSize i
 			return nil, err
 		}
 		// Cache the directory listing for future use
-		CacheDirList(name, fileInfos)
+		CacheDirList(key, fileInfos)
 	}
 
 	// Sort file infos by name for consistent ordering
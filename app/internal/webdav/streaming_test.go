@@ -37,11 +37,11 @@ func (m *streamingMockStorage) Mount() error {
 	return nil
 }
 
-func (m *streamingMockStorage) List(info msg.Finfo) ([]msg.Finfo, error) {
+func (m *streamingMockStorage) List(ctx context.Context, info msg.Finfo) ([]msg.Finfo, error) {
 	return nil, nil
 }
 
-func (m *streamingMockStorage) Link(info msg.Finfo) (*msg.LinkInfo, error) {
+func (m *streamingMockStorage) Link(ctx context.Context, info msg.Finfo) (*msg.LinkInfo, error) {
 	path := info.GetPath()
 	if _, exists := m.files[path]; exists {
 		return &msg.LinkInfo{Url: "mock://" + path}, nil
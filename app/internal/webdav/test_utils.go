@@ -1,6 +1,7 @@
 package webdav
 
 import (
+	"context"
 	"time"
 
 	"overlink.top/app/storage"
@@ -33,11 +34,11 @@ func (m *mockStorage) Mount() error {
 	return nil
 }
 
-func (m *mockStorage) List(info msg.Finfo) ([]msg.Finfo, error) {
+func (m *mockStorage) List(ctx context.Context, info msg.Finfo) ([]msg.Finfo, error) {
 	return nil, nil
 }
 
-func (m *mockStorage) Link(info msg.Finfo) (*msg.LinkInfo, error) {
+func (m *mockStorage) Link(ctx context.Context, info msg.Finfo) (*msg.LinkInfo, error) {
 	path := info.GetPath()
 	if _, exists := m.files[path]; exists {
 		return &msg.LinkInfo{Url: "mock://" + path}, nil
@@ -1,21 +1,35 @@
 package webdav
 
 import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"overlink.top/app/internal/tracing"
+	"overlink.top/app/system/conf"
 	"overlink.top/app/system/logic"
 	"overlink.top/app/system/msg"
+	"strings"
 )
 
 func (h *Handler) ServeHTTPOverride(w http.ResponseWriter, r *http.Request) {
 	status, err := http.StatusBadRequest, errUnsupportedMethod
+	ctx, span := tracing.Start(r.Context(), "webdav."+r.Method, attribute.String("path", r.URL.Path))
+	defer func() {
+		span.SetAttributes(attribute.Int("http.status_code", status))
+		tracing.End(span, err)
+	}()
+
+	r = r.WithContext(ctx)
 	if h.FileSystem == nil {
 		status, err = http.StatusInternalServerError, errNoFileSystem
 	} else if h.LockSystem == nil {
 		status, err = http.StatusInternalServerError, errNoLockSystem
+	} else if !h.allowWrite(r) {
+		status, err = http.StatusForbidden, msg.ErrNoPermission
 	} else {
 		switch r.Method {
 		case "OPTIONS":
@@ -39,6 +53,10 @@ func (h *Handler) ServeHTTPOverride(w http.ResponseWriter, r *http.Request) {
 		case "PROPPATCH":
 			status, err = h.handleProppatch(w, r)
 		}
+
+		if err == nil && status < http.StatusBadRequest {
+			h.audit(r)
+		}
 	}
 
 	if status != 0 {
@@ -52,6 +70,72 @@ func (h *Handler) ServeHTTPOverride(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// allowWrite checks the mount write ACL for methods that modify the tree.
+// COPY only needs the destination to be writable, MOVE needs both ends.
+func (h *Handler) allowWrite(r *http.Request) bool {
+	var paths []string
+	switch r.Method {
+	case "DELETE", "PUT", "MKCOL", "PROPPATCH", "LOCK", "UNLOCK", "MOVE":
+		paths = append(paths, r.URL.Path)
+	case "COPY":
+	default:
+		return true
+	}
+
+	if r.Method == "COPY" || r.Method == "MOVE" {
+		if u, err := url.Parse(r.Header.Get("Destination")); err == nil && u.Path != "" {
+			paths = append(paths, u.Path)
+		}
+	}
+
+	for _, v := range paths {
+		reqPath, _, err := h.stripPrefix(v)
+		if err != nil {
+			continue
+		}
+
+		// ACLs are keyed on real paths, jailed users see their home as /
+		if !logic.CheckAcl(r.Context(), logic.RealPath(r.Context(), reqPath), conf.ActionWrite) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// audit records downloads and successful changes to the tree, paths are
+// logged as seen on the storage side so jailed users stay traceable.
+func (h *Handler) audit(r *http.Request) {
+	switch r.Method {
+	case "GET", "DELETE", "PUT", "MKCOL", "COPY", "MOVE":
+	default:
+		return
+	}
+
+	ctx := r.Context()
+	reqPath, _, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return
+	}
+
+	reqPath = logic.RealPath(ctx, reqPath)
+	if r.Method == "GET" {
+		logic.AuditDownload(r, reqPath)
+		return
+	}
+
+	var after interface{}
+	if r.Method == "COPY" || r.Method == "MOVE" {
+		if u, err := url.Parse(r.Header.Get("Destination")); err == nil {
+			if dst, _, err := h.stripPrefix(u.Path); err == nil {
+				after = map[string]string{"destination": logic.RealPath(ctx, dst)}
+			}
+		}
+	}
+
+	logic.Audit(ctx, "webdav."+strings.ToLower(r.Method), reqPath, nil, after)
+}
+
 func (h *Handler) handleGetHeadPostOverride(w http.ResponseWriter, r *http.Request) (status int, err error) {
 	reqPath, status, err := h.stripPrefix(r.URL.Path)
 	if err != nil {
@@ -61,7 +145,8 @@ func (h *Handler) handleGetHeadPostOverride(w http.ResponseWriter, r *http.Reque
 	ctx := r.Context()
 
 	// Try to get file info from cache first
-	fi, err := GetCachedFileInfo(ctx, reqPath)
+	key := cacheKey(ctx, reqPath)
+	fi, err := GetCachedFileInfo(ctx, key)
 	if err != nil || fi == nil {
 		// Not in cache, fetch it
 		fi, err = logic.GetFile(ctx, reqPath)
@@ -69,7 +154,7 @@ func (h *Handler) handleGetHeadPostOverride(w http.ResponseWriter, r *http.Reque
 			return http.StatusNotFound, err
 		}
 		// Cache the file info for future use
-		CacheFileInfo(reqPath, fi)
+		CacheFileInfo(key, fi)
 	}
 
 	if fi.IsDir() {
@@ -113,10 +198,8 @@ func (h *Handler) handleDeleteOverride(w http.ResponseWriter, r *http.Request) (
 		return http.StatusMethodNotAllowed, err
 	}
 
-	// Invalidate cache for the deleted path and its parent directory
-	InvalidateCache(reqPath)
-	parentDir := path.Dir(reqPath)
-	InvalidateCache(parentDir)
+	// Invalidate cache for the deleted path, its children and parent directory
+	invalidateTree(ctx, reqPath)
 
 	if err := h.FileSystem.RemoveAll(ctx, reqPath); err != nil {
 		return http.StatusMethodNotAllowed, err
@@ -148,9 +231,7 @@ func (h *Handler) handlePutOverride(w http.ResponseWriter, r *http.Request) (sta
 	closeErr := f.Close()
 
 	// Invalidate cache for the modified path and its parent directory
-	InvalidateCache(reqPath)
-	parentDir := path.Dir(reqPath)
-	InvalidateCache(parentDir)
+	invalidatePath(ctx, reqPath)
 
 	// TODO(rost): Returning 405 Method Not Allowed might not be appropriate.
 	if copyErr != nil {
@@ -189,8 +270,7 @@ func (h *Handler) handleMkcolOverride(w http.ResponseWriter, r *http.Request) (s
 	}
 
 	// Invalidate cache for the parent directory
-	parentDir := path.Dir(reqPath)
-	InvalidateCache(parentDir)
+	invalidatePath(ctx, reqPath)
 
 	if err := h.FileSystem.Mkdir(ctx, reqPath, 0777); err != nil {
 		if os.IsNotExist(err) {
@@ -259,9 +339,7 @@ func (h *Handler) handleCopyMoveOverride(w http.ResponseWriter, r *http.Request)
 		}
 
 		// Invalidate cache for destination and its parent directory
-		InvalidateCache(dst)
-		dstParentDir := path.Dir(dst)
-		InvalidateCache(dstParentDir)
+		invalidateTree(ctx, dst)
 
 		return copyFiles(ctx, h.FileSystem, src, dst, r.Header.Get("Overwrite") != "F", depth, 0)
 	}
@@ -282,12 +360,8 @@ func (h *Handler) handleCopyMoveOverride(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Invalidate cache for source, destination, and their parent directories
-	InvalidateCache(src)
-	srcParentDir := path.Dir(src)
-	InvalidateCache(srcParentDir)
-	InvalidateCache(dst)
-	dstParentDir := path.Dir(dst)
-	InvalidateCache(dstParentDir)
+	invalidateTree(ctx, src)
+	invalidateTree(ctx, dst)
 
 	return moveFiles(ctx, h.FileSystem, src, dst, r.Header.Get("Overwrite") == "T")
 }
@@ -299,8 +373,15 @@ func (h *Handler) handlePropfindOverride(w http.ResponseWriter, r *http.Request)
 	}
 	ctx := r.Context()
 
+	// Cached entries were fetched on behalf of someone else, check the ACL
+	// before handing them out.
+	if !logic.CheckAcl(ctx, logic.RealPath(ctx, reqPath), conf.ActionRead) {
+		return http.StatusForbidden, msg.ErrNoPermission
+	}
+
 	// Try to get file info from cache first
-	fi, err := GetCachedFileInfo(ctx, reqPath)
+	key := cacheKey(ctx, reqPath)
+	fi, err := GetCachedFileInfo(ctx, key)
 	if err != nil || fi == nil {
 		// Not in cache, fetch it
 		fi, err = logic.GetFile(ctx, reqPath)
@@ -311,7 +392,7 @@ func (h *Handler) handlePropfindOverride(w http.ResponseWriter, r *http.Request)
 			// return http.StatusMethodNotAllowed, err
 		}
 		// Cache the file info for future use
-		CacheFileInfo(reqPath, fi)
+		CacheFileInfo(key, fi)
 	}
 
 	depth := infiniteDepth
@@ -335,7 +416,7 @@ func (h *Handler) handlePropfindOverride(w http.ResponseWriter, r *http.Request)
 		}
 
 		// Cache the file info for this path as well
-		CacheFileInfo(reqPath, info)
+		CacheFileInfo(cacheKey(ctx, reqPath), info)
 
 		var pstats []Propstat
 		if pf.Propname != nil {
@@ -375,3 +456,35 @@ func (h *Handler) handlePropfindOverride(w http.ResponseWriter, r *http.Request)
 	}
 	return 0, nil
 }
+
+// jailFileSystem maps every path onto the tree of the user bound to ctx, the
+// handlers keep working with the paths jailed users see.
+type jailFileSystem struct {
+	fs FileSystem
+}
+
+// JailFileSystem wraps fs so that jailed users only reach their home
+// directory.
+func JailFileSystem(fs FileSystem) FileSystem {
+	return jailFileSystem{fs: fs}
+}
+
+func (self jailFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return self.fs.Mkdir(ctx, logic.RealPath(ctx, name), perm)
+}
+
+func (self jailFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (File, error) {
+	return self.fs.OpenFile(ctx, logic.RealPath(ctx, name), flag, perm)
+}
+
+func (self jailFileSystem) RemoveAll(ctx context.Context, name string) error {
+	return self.fs.RemoveAll(ctx, logic.RealPath(ctx, name))
+}
+
+func (self jailFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return self.fs.Rename(ctx, logic.RealPath(ctx, oldName), logic.RealPath(ctx, newName))
+}
+
+func (self jailFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return self.fs.Stat(ctx, logic.RealPath(ctx, name))
+}
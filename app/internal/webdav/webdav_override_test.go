@@ -0,0 +1,72 @@
+package webdav
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"overlink.top/app/system/logic"
+	"overlink.top/app/system/model"
+)
+
+func TestJailedWritesStayHome(t *testing.T) {
+	root := t.TempDir()
+	home := filepath.Join(root, "home", "u")
+	if err := os.MkdirAll(home, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Handler{
+		Prefix:     "/dav",
+		FileSystem: JailFileSystem(Dir(root)),
+		LockSystem: NewMemLS(),
+	}
+	user := &model.User{ID: 7, Username: "u", BasePath: "/home/u"}
+	serve := func(method string, target string, body string, header map[string]string) int {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+
+		r = r.WithContext(logic.WithIdentity(r.Context(), user))
+		w := httptest.NewRecorder()
+		h.ServeHTTPOverride(w, r)
+		return w.Code
+	}
+
+	steps := []struct {
+		method string
+		target string
+		body   string
+		header map[string]string
+		want   int
+	}{
+		{"MKCOL", "/dav/docs", "", nil, http.StatusCreated},
+		{"PUT", "/dav/docs/a.txt", "hello", nil, http.StatusCreated},
+		{"PUT", "/dav/../escape.txt", "out", nil, http.StatusCreated},
+		{"COPY", "/dav/docs/a.txt", "", map[string]string{"Destination": "/dav/b.txt"}, http.StatusCreated},
+		{"MOVE", "/dav/b.txt", "", map[string]string{"Destination": "/dav/docs/c.txt"}, http.StatusCreated},
+		{"DELETE", "/dav/docs/a.txt", "", nil, http.StatusNoContent},
+	}
+
+	for _, v := range steps {
+		if got := serve(v.method, v.target, v.body, v.header); got != v.want {
+			t.Fatalf("%s %s = %d, want %d", v.method, v.target, got, v.want)
+		}
+	}
+
+	for _, v := range []string{"docs/c.txt", "escape.txt"} {
+		if _, err := os.Stat(filepath.Join(home, v)); err != nil {
+			t.Errorf("%s not written inside the home directory: %v", v, err)
+		}
+	}
+
+	for _, v := range []string{"docs", "escape.txt", "b.txt", "home/u/docs/a.txt", "home/u/b.txt"} {
+		if _, err := os.Stat(filepath.Join(root, v)); err == nil {
+			t.Errorf("%s exists outside what the jailed user should have left", v)
+		}
+	}
+}
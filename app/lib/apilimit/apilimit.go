@@ -54,6 +54,24 @@ func (l *ApiRateLimiter) Allow(api string) bool {
     return false
 }
 
+// Budget reports the tokens left for api and the bucket size, max is 0 when
+// api has no limit.
+func (l *ApiRateLimiter) Budget(api string) (left int, max int) {
+    l.Mutex.Lock()
+    defer l.Mutex.Unlock()
+
+    limit, ok := l.limits[api]
+    if !ok {
+        return 0, 0
+    }
+
+    if time.Since(limit.LastTick) >= limit.Interval {
+        return limit.MaxCount, limit.MaxCount
+    }
+
+    return limit.Tokens, limit.MaxCount
+}
+
 func NewApiRateLimiter(list map[string]ApiLimit) *ApiRateLimiter {
     limiter := &ApiRateLimiter{
         limits: make(map[string]*ApiLimit),
@@ -2,6 +2,8 @@ package util
 
 import (
 	"crypto/md5"
+	crand "crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"math/rand"
 	"time"
@@ -26,6 +28,24 @@ func GenRandStr(size int) string {
 	return string(randomString)
 }
 
+// GenSecureStr is like GenRandStr but reads from crypto/rand, use it for
+// anything that ends up as a credential.
+func GenSecureStr(size int) string {
+	charLen := byte(len(charset))
+	randomBytes := make([]byte, size)
+	crand.Read(randomBytes)
+	for i := range randomBytes {
+		randomBytes[i] = charset[randomBytes[i]%charLen]
+	}
+
+	return string(randomBytes)
+}
+
+func ToSHA256(data string) string {
+	hash := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(hash[:])
+}
+
 func ToMD5(data string) string {
 	hash := md5.New()
 	hash.Write([]byte(data))
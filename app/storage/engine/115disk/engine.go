@@ -24,7 +24,8 @@ type Disk115 struct {
 }
 
 var config = storage.Config{
-	Name: "115disk",
+	Name:           "115disk",
+	MaxConnections: 2,
 }
 
 func (self *Disk115) GetConfig() storage.Config {
@@ -47,11 +48,11 @@ func (self *Disk115) Mount() error {
 	return nil
 }
 
-func (self *Disk115) List(info msg.Finfo) (list []msg.Finfo, err error) {
+func (self *Disk115) List(ctx context.Context, info msg.Finfo) (list []msg.Finfo, err error) {
 	return
 }
 
-func (self *Disk115) Link(info msg.Finfo) (*msg.LinkInfo, error) {
+func (self *Disk115) Link(ctx context.Context, info msg.Finfo) (*msg.LinkInfo, error) {
 	return nil, nil
 }
 
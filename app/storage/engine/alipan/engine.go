@@ -4,14 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 	"net/http"
 	"path/filepath"
+	"overlink.top/app/internal/metrics"
+	"overlink.top/app/internal/tracing"
 	"overlink.top/app/lib/apilimit"
 	"overlink.top/app/lib/util"
 	"overlink.top/app/storage"
-	"overlink.top/app/system/log"
 	"overlink.top/app/system/logic"
 	"overlink.top/app/system/model"
 	"overlink.top/app/system/msg"
@@ -22,9 +25,9 @@ import (
 type Extra struct {
 	SpaceType    string `json:"space_type" required:"true" etype:"select" options:"resource,backup" tip:"true"`
 	RootId       string `json:"root_id" dvalue:"root" required:"true" tip:"true"`
-	RefreshToken string `json:"refresh_token" required:"true" etype:"textarea" tip:"true"`
+	RefreshToken string `json:"refresh_token" required:"true" etype:"textarea" tip:"true" secret:"true"`
 	ClientId     string `json:"client_id" tip:"true"`
-	ClientSecret string `json:"client_secret" tip:"true"`
+	ClientSecret string `json:"client_secret" tip:"true" secret:"true"`
 }
 
 var UserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.88 Safari/537.36"
@@ -48,7 +51,8 @@ type Alipan struct {
 }
 
 var config = storage.Config{
-	Name: "alipan",
+	Name:           "alipan",
+	MaxConnections: 8,
 }
 
 func (self *Alipan) GetConfig() storage.Config {
@@ -75,7 +79,7 @@ func (self *Alipan) Mount() error {
 	})
 
 	var result GetDriveInfoResp
-	err := self.remote("/adrive/v1.0/user/getDriveInfo", func(req *resty.Request) {
+	err := self.remote(context.Background(), "/adrive/v1.0/user/getDriveInfo", func(req *resty.Request) {
 		req.SetResult(&result)
 	}, true)
 	if err != nil {
@@ -93,16 +97,16 @@ func (self *Alipan) Mount() error {
 	return nil
 }
 
-func (self *Alipan) List(info msg.Finfo) (list []msg.Finfo, err error) {
-	if !self.rateLimiter.Allow("list") {
-		err = errors.New("too many requests")
+func (self *Alipan) List(ctx context.Context, info msg.Finfo) (list []msg.Finfo, err error) {
+	if !self.allow("list") {
+		err = storage.ErrRateLimited
 		return
 	}
 
 	rpath := info.GetPath()
 	fileId := info.GetFileId()
 	if fileId == "" {
-		fileItem, err := self.getByPath(rpath)
+		fileItem, err := self.getByPath(ctx, rpath)
 		if err != nil {
 			return list, err
 		}
@@ -111,7 +115,7 @@ func (self *Alipan) List(info msg.Finfo) (list []msg.Finfo, err error) {
 	}
 
 	var result ListResp
-	err = self.remote("/adrive/v1.0/openFile/list", func(req *resty.Request) {
+	err = self.remote(ctx, "/adrive/v1.0/openFile/list", func(req *resty.Request) {
 		req.SetResult(&result).SetBody(map[string]string{
 			"drive_id":       self.DriveId,
 			"parent_file_id": fileId,
@@ -137,13 +141,13 @@ func (self *Alipan) List(info msg.Finfo) (list []msg.Finfo, err error) {
 	return
 }
 
-func (self *Alipan) Link(info msg.Finfo) (*msg.LinkInfo, error) {
-	if !self.rateLimiter.Allow("getDownloadUrl") {
-		return nil, errors.New("too many requests")
+func (self *Alipan) Link(ctx context.Context, info msg.Finfo) (*msg.LinkInfo, error) {
+	if !self.allow("getDownloadUrl") {
+		return nil, storage.ErrRateLimited
 	}
 
 	var result getDownloadUrlResp
-	err := self.remote("/adrive/v1.0/openFile/getDownloadUrl", func(req *resty.Request) {
+	err := self.remote(ctx, "/adrive/v1.0/openFile/getDownloadUrl", func(req *resty.Request) {
 		req.SetResult(&result).SetBody(map[string]interface{}{
 			"drive_id":   self.DriveId,
 			"file_id":    info.GetFileId(),
@@ -157,7 +161,7 @@ func (self *Alipan) Link(info msg.Finfo) (*msg.LinkInfo, error) {
 	return &msg.LinkInfo{Url: result.Url, Expire: 900 * time.Second}, nil
 }
 
-func (self *Alipan) getByPath(rpath string) (result FileItem, err error) {
+func (self *Alipan) getByPath(ctx context.Context, rpath string) (result FileItem, err error) {
 	mountPath := self.GetData().MountPath
 	subpath := strings.TrimPrefix(rpath, mountPath)
 	if subpath == "" {
@@ -165,12 +169,12 @@ func (self *Alipan) getByPath(rpath string) (result FileItem, err error) {
 		return
 	}
 
-	if !self.rateLimiter.Allow("others") {
-		err = errors.New("too many requests")
+	if !self.allow("others") {
+		err = storage.ErrRateLimited
 		return
 	}
 
-	err = self.remote("/adrive/v1.0/openFile/get_by_path", func(req *resty.Request) {
+	err = self.remote(ctx, "/adrive/v1.0/openFile/get_by_path", func(req *resty.Request) {
 		req.SetResult(&result).SetBody(map[string]string{
 			"drive_id":  self.DriveId,
 			"file_path": subpath,
@@ -180,9 +184,27 @@ func (self *Alipan) getByPath(rpath string) (result FileItem, err error) {
 	return
 }
 
-func (self *Alipan) remote(api string, callback func(req *resty.Request), refresh bool) error {
+// ListBudget reports the list calls left in the current rate limit window.
+func (self *Alipan) ListBudget() (int, int) {
+	return self.rateLimiter.Budget("list")
+}
+
+// allow takes a token from the bucket and counts refused calls
+func (self *Alipan) allow(bucket string) bool {
+	if self.rateLimiter.Allow(bucket) {
+		return true
+	}
+
+	metrics.ApiLimitRejected.WithLabelValues(config.Name, bucket).Inc()
+	return false
+}
+
+func (self *Alipan) remote(ctx context.Context, api string, callback func(req *resty.Request), refresh bool) (err error) {
+	ctx, span := tracing.Start(ctx, "alipan.remote", attribute.String("api", api), attribute.String("mount", self.GetData().MountPath))
+	defer func() { tracing.End(span, err) }()
+
 	var simpleResp SimpleResp
-	req := util.HttpClient().R()
+	req := util.HttpClient().R().SetContext(ctx)
 	req.SetHeader("Authorization", "Bearer "+self.GetData().Token)
 	req.SetHeader("Content-Type", "application/json")
 	if callback != nil {
@@ -190,25 +212,31 @@ func (self *Alipan) remote(api string, callback func(req *resty.Request), refres
 	}
 
 	req.SetError(&simpleResp)
+	start := time.Now()
 	resp, err := req.Execute(http.MethodPost, self.Domain+api)
+	metrics.ObserveEngine(config.Name, api, start, err)
 	if err != nil {
-		log.Errorf("alipan remote execute err:%+v", err)
+		storage.Log(ctx, self).Errorf("remote execute err:%+v", err)
 		return err
 	}
 
 	if simpleResp.Code != "" {
 		if !refresh {
-			log.Errorf("alipan remote resp err:%+v", simpleResp)
+			storage.Log(ctx, self).Errorf("remote resp err:%+v", simpleResp)
 		}
 
 		if resp.StatusCode() > 399 && refresh &&
 			(self.GetData().Token == "" || simpleResp.Code == "AccessTokenExpired") {
-			err = self.auth()
+			err = self.auth(ctx)
 			if err != nil {
 				return err
 			}
 
-			return self.remote(api, callback, false)
+			return self.remote(ctx, api, callback, false)
+		}
+
+		if resp.StatusCode() == http.StatusTooManyRequests {
+			return fmt.Errorf("%s: %w", simpleResp.Code, storage.ErrRateLimited)
 		}
 
 		return errors.New(simpleResp.Code)
@@ -217,14 +245,17 @@ func (self *Alipan) remote(api string, callback func(req *resty.Request), refres
 	return nil
 }
 
-func (self *Alipan) auth() error {
+func (self *Alipan) auth(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "alipan.auth", attribute.String("mount", self.GetData().MountPath))
+	defer func() { tracing.End(span, err) }()
+
 	var result AccessTokenResp
 	url := self.Domain + "/oauth/access_token"
 	if self.ClientId == "" {
 		url = self.ProxyOauthUrl
 	}
 
-	req := util.HttpClient().SetHeader("user-agent", UserAgent).R()
+	req := util.HttpClient().SetHeader("user-agent", UserAgent).R().SetContext(ctx)
 	req.SetResult(&result).SetBody(map[string]string{
 		"grant_type":    "refresh_token",
 		"refresh_token": self.RefreshToken,
@@ -232,9 +263,11 @@ func (self *Alipan) auth() error {
 		"client_secret": self.ClientSecret,
 	})
 
+	start := time.Now()
 	resp, err := req.Execute(http.MethodPost, url)
+	metrics.ObserveEngine(config.Name, "/oauth/access_token", start, err)
 	if err != nil {
-		log.Errorf("auth err:%+v", err)
+		storage.Log(ctx, self).Errorf("auth err:%+v", err)
 		return err
 	}
 
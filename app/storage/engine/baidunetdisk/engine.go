@@ -24,7 +24,8 @@ type Baidunetdisk struct {
 }
 
 var config = storage.Config{
-	Name: "baidunetdisk",
+	Name:           "baidunetdisk",
+	MaxConnections: 4,
 }
 
 func (self *Baidunetdisk) GetConfig() storage.Config {
@@ -47,11 +48,11 @@ func (self *Baidunetdisk) Mount() error {
 	return nil
 }
 
-func (self *Baidunetdisk) List(info msg.Finfo) (list []msg.Finfo, err error) {
+func (self *Baidunetdisk) List(ctx context.Context, info msg.Finfo) (list []msg.Finfo, err error) {
 	return
 }
 
-func (self *Baidunetdisk) Link(info msg.Finfo) (*msg.LinkInfo, error) {
+func (self *Baidunetdisk) Link(ctx context.Context, info msg.Finfo) (*msg.LinkInfo, error) {
 	return nil, nil
 }
 
@@ -67,8 +67,12 @@ func (self *Native) Mount() error {
 	return nil
 }
 
-func (self *Native) Get(rpath string) (info msg.Finfo, err error) {
-	apath := self.getApath(rpath)
+func (self *Native) Get(ctx context.Context, rpath string) (info msg.Finfo, err error) {
+	apath, err := self.getApath(rpath)
+	if err != nil {
+		return
+	}
+
 	fileinfo, err := os.Stat(apath)
 	if err != nil {
 		err = errors.New("dir err")
@@ -84,9 +88,13 @@ func (self *Native) Get(rpath string) (info msg.Finfo, err error) {
 	return
 }
 
-func (self *Native) List(info msg.Finfo) (list []msg.Finfo, err error) {
+func (self *Native) List(ctx context.Context, info msg.Finfo) (list []msg.Finfo, err error) {
 	rpath := info.GetPath()
-	apath := self.getApath(rpath)
+	apath, err := self.getApath(rpath)
+	if err != nil {
+		return
+	}
+
 	dir, err := ioutil.ReadDir(apath)
 	if err != nil {
 		return
@@ -105,23 +113,53 @@ func (self *Native) List(info msg.Finfo) (list []msg.Finfo, err error) {
 	return
 }
 
-func (self *Native) Link(info msg.Finfo) (*msg.LinkInfo, error) {
+func (self *Native) Link(ctx context.Context, info msg.Finfo) (*msg.LinkInfo, error) {
 	rpath := info.GetPath()
-	apath := self.getApath(rpath)
+	apath, err := self.getApath(rpath)
+	if err != nil {
+		return nil, err
+	}
+
 	return &msg.LinkInfo{Url: apath}, nil
 }
 
-func (self *Native) getApath(rpath string) string {
+// getApath maps rpath into the root folder. Symlinks are resolved so a link
+// pointing outside the root can't be used to escape it.
+func (self *Native) getApath(rpath string) (string, error) {
 	mountPath := self.GetData().MountPath
 	subpath := strings.TrimPrefix(rpath, mountPath)
-	apath := filepath.Join(self.GetRootPath(), subpath)
-	apath = filepath.ToSlash(apath)
-	return apath
+	apath := filepath.Join(self.GetRootPath(), filepath.FromSlash(path.Clean("/"+subpath)))
+
+	root, err := filepath.EvalSymlinks(self.GetRootPath())
+	if err != nil {
+		return "", err
+	}
+
+	// Paths still to be created are checked through their nearest existing
+	// parent, a symlinked folder must not take new files outside either
+	real, err := filepath.EvalSymlinks(apath)
+	for dir := apath; os.IsNotExist(err) && dir != filepath.Dir(dir); {
+		dir = filepath.Dir(dir)
+		real, err = filepath.EvalSymlinks(dir)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if real != root && !strings.HasPrefix(real, root+string(filepath.Separator)) {
+		return "", errors.New("path escapes the root path")
+	}
+
+	return filepath.ToSlash(apath), nil
 }
 
 // StreamFile streams a file directly to the writer
 func (self *Native) StreamFile(ctx context.Context, rpath string, writer io.Writer) error {
-	apath := self.getApath(rpath)
+	apath, err := self.getApath(rpath)
+	if err != nil {
+		return err
+	}
 
 	// Check if file exists and is not a directory
 	fileInfo, err := os.Stat(apath)
@@ -155,7 +193,10 @@ func (self *Native) StreamFile(ctx context.Context, rpath string, writer io.Writ
 
 // StreamRange streams a file range directly to the writer
 func (self *Native) StreamRange(ctx context.Context, rpath string, offset, length int64, writer io.Writer) error {
-	apath := self.getApath(rpath)
+	apath, err := self.getApath(rpath)
+	if err != nil {
+		return err
+	}
 
 	// Check if file exists and is not a directory
 	fileInfo, err := os.Stat(apath)
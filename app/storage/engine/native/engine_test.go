@@ -0,0 +1,59 @@
+package native
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"overlink.top/app/system/model"
+)
+
+func TestGetApathStaysInRoot(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	for _, v := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(v, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0o644)
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("s"), 0o644)
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	os.Symlink(filepath.Join(root, "sub"), filepath.Join(root, "inside"))
+
+	store := &Native{Storage: model.Storage{MountPath: "/m"}, Extra: Extra{RootPath: root}}
+	tests := []struct {
+		rpath string
+		want  string
+	}{
+		{"/m", root},
+		{"/m/a.txt", filepath.Join(root, "a.txt")},
+		{"/m/new/file.txt", filepath.Join(root, "new", "file.txt")},
+		{"/m/../../outside/secret.txt", filepath.Join(root, "outside", "secret.txt")},
+		{"/m/sub/../../a.txt", filepath.Join(root, "a.txt")},
+		{"/m/inside/x.txt", filepath.Join(root, "inside", "x.txt")},
+		{"/m/escape/secret.txt", ""},
+		{"/m/escape", ""},
+		{"/m/escape/new.txt", ""},
+		{"/m/escape/new/deeper.txt", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := store.getApath(tt.rpath)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("getApath(%s) = %s, want an escape error", tt.rpath, got)
+			}
+
+			continue
+		}
+
+		if err != nil || got != filepath.ToSlash(tt.want) {
+			t.Errorf("getApath(%s) = %s, %v, want %s", tt.rpath, got, err, tt.want)
+		}
+	}
+}
@@ -5,14 +5,19 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 	"net/http"
 	"path/filepath"
+	"overlink.top/app/internal/metrics"
+	"overlink.top/app/internal/tracing"
 	"overlink.top/app/storage"
+	"overlink.top/app/system/log"
 	"overlink.top/app/system/logic"
 	"overlink.top/app/system/model"
 	"overlink.top/app/system/msg"
 	"strings"
+	"time"
 )
 
 type SimpleResp struct {
@@ -22,9 +27,9 @@ type SimpleResp struct {
 type Extra struct {
 	Url       string `json:"url" required:"true" tip:"true"`
 	RootPath  string `json:"root_path" required:"true" tip:"true"`
-	FolderPwd string `json:"folder_pwd" tip:"true"`
+	FolderPwd string `json:"folder_pwd" tip:"true" secret:"true"`
 	Username  string `json:"username" required:"true" tip:"true"`
-	Password  string `json:"password" required:"true" tip:"true"`
+	Password  string `json:"password" required:"true" tip:"true" secret:"true"`
 }
 
 func (self *Extra) GetRootPath() string {
@@ -43,7 +48,8 @@ type Showta struct {
 }
 
 var config = storage.Config{
-	Name: "showta",
+	Name:           "showta",
+	MaxConnections: 8,
 }
 
 func (self *Showta) GetConfig() storage.Config {
@@ -63,7 +69,7 @@ func (self *Showta) GetExtra() storage.ExtraItem {
 }
 
 func (self *Showta) Mount() error {
-	err := self.auth()
+	err := self.auth(context.Background())
 	if err != nil {
 		return err
 	}
@@ -71,10 +77,10 @@ func (self *Showta) Mount() error {
 	return nil
 }
 
-func (self *Showta) Get(rpath string) (info msg.Finfo, err error) {
+func (self *Showta) Get(ctx context.Context, rpath string) (info msg.Finfo, err error) {
 	apath := self.getApath(rpath)
 	var result msg.GenericResp[msg.GetFileResp]
-	err = self.remote("/file/get", func(req *resty.Request) {
+	err = self.remote(ctx, "/file/get", func(req *resty.Request) {
 		req.SetResult(&result).SetBody(map[string]string{
 			"rpath":    apath,
 			"password": self.FolderPwd,
@@ -95,13 +101,13 @@ func (self *Showta) Get(rpath string) (info msg.Finfo, err error) {
 	return
 }
 
-func (self *Showta) List(info msg.Finfo) (list []msg.Finfo, err error) {
+func (self *Showta) List(ctx context.Context, info msg.Finfo) (list []msg.Finfo, err error) {
 	rpath := info.GetPath()
 	mountPath := self.GetData().MountPath
 	apath := self.getApath(rpath)
 
 	var result msg.GenericResp[msg.ListFileResp]
-	err = self.remote("/file/list", func(req *resty.Request) {
+	err = self.remote(ctx, "/file/list", func(req *resty.Request) {
 		req.SetResult(&result).SetBody(map[string]string{
 			"rpath":    apath,
 			"password": self.FolderPwd,
@@ -133,11 +139,11 @@ func (self *Showta) List(info msg.Finfo) (list []msg.Finfo, err error) {
 	return
 }
 
-func (self *Showta) Link(info msg.Finfo) (*msg.LinkInfo, error) {
+func (self *Showta) Link(ctx context.Context, info msg.Finfo) (*msg.LinkInfo, error) {
 	rpath := info.GetPath()
 	apath := self.getApath(rpath)
 	var result msg.GenericResp[msg.GetFileResp]
-	err := self.remote("/file/get", func(req *resty.Request) {
+	err := self.remote(ctx, "/file/get", func(req *resty.Request) {
 		req.SetResult(&result).SetBody(map[string]string{
 			"rpath":    apath,
 			"password": self.FolderPwd,
@@ -156,41 +162,60 @@ func (self *Showta) Link(info msg.Finfo) (*msg.LinkInfo, error) {
 	return &msg.LinkInfo{Url: result.Data.RawUrl}, nil
 }
 
-func (self *Showta) remote(api string, callback func(req *resty.Request), refresh bool) error {
-	req := resty.New().R()
+func (self *Showta) remote(ctx context.Context, api string, callback func(req *resty.Request), refresh bool) (err error) {
+	ctx, span := tracing.Start(ctx, "showta.remote", attribute.String("api", api), attribute.String("mount", self.GetData().MountPath))
+	defer func() { tracing.End(span, err) }()
+
+	req := resty.New().R().SetContext(ctx)
 	req.SetHeader("Authorization", self.Token)
+	// Another ShowTa instance continues the same trace and request id
+	tracing.Inject(ctx, req.Header)
+	if id := log.RequestId(ctx); id != "" {
+		req.SetHeader("X-Request-ID", id)
+	}
+
 	callback(req)
+	start := time.Now()
 	resp, err := req.Execute(http.MethodPost, self.Url+api)
+	metrics.ObserveEngine(config.Name, api, start, err)
 	if err != nil {
+		storage.Log(ctx, self).Errorf("remote execute err:%+v", err)
 		return err
 	}
 
 	var simpleResp SimpleResp
 	err = json.Unmarshal(resp.Body(), &simpleResp)
 	if err != nil {
+		storage.Log(ctx, self).Errorf("remote [%s] decode err:%+v", api, err)
 		return errors.New("remote decode error")
 	}
 
 	if refresh && simpleResp.Code == http.StatusUnauthorized {
-		err = self.auth()
+		err = self.auth(ctx)
 		if err != nil {
 			return err
 		}
 
-		return self.remote(api, callback, false)
+		return self.remote(ctx, api, callback, false)
 	}
 
 	return nil
 }
 
-func (self *Showta) auth() error {
+func (self *Showta) auth(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "showta.auth", attribute.String("mount", self.GetData().MountPath))
+	defer func() { tracing.End(span, err) }()
+
 	var result msg.GenericResp[msg.LoginResp]
-	req := resty.New().R()
+	req := resty.New().R().SetContext(ctx)
+	tracing.Inject(ctx, req.Header)
 	req.SetResult(&result).SetBody(map[string]string{
 		"username": self.Username,
 		"password": self.Password,
 	})
-	_, err := req.Execute(http.MethodPost, self.Url+"/admin/login")
+	start := time.Now()
+	_, err = req.Execute(http.MethodPost, self.Url+"/admin/login")
+	metrics.ObserveEngine(config.Name, "/admin/login", start, err)
 	if err != nil {
 		return err
 	}
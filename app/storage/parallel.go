@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// Bytes fetched per ranged GET, at most one part per connection is held
+	// in memory
+	partSize    = 2 << 20
+	partRetries = 3
+)
+
+var errRangeIgnored = errors.New("server ignored the range request")
+
+// connections is how many ranged GETs a download of store may run at once,
+// the mount setting bounded by what the engine tolerates.
+func connections(store Storage) int {
+	return min(store.GetData().Connections, store.GetConfig().MaxConnections)
+}
+
+type partResult struct {
+	data []byte
+	err  error
+}
+
+// parallelRange writes [offset, offset+length) of url fetched as parts over n
+// connections. Parts are written in order as soon as those before them are,
+// a connection takes the next part once its own has been written.
+func parallelRange(ctx context.Context, store Storage, url string, offset, length int64, n int, writer io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	count := int((length + partSize - 1) / partSize)
+	results := make([]chan partResult, count)
+	for i := range results {
+		results[i] = make(chan partResult, 1)
+	}
+
+	slots := make(chan struct{}, n)
+	go func() {
+		for i := 0; i < count; i++ {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			start := offset + int64(i)*partSize
+			size := min(partSize, offset+length-start)
+			go func(i int) {
+				data, err := fetchPart(ctx, store, url, start, size)
+				results[i] <- partResult{data, err}
+			}(i)
+		}
+	}()
+
+	for i := 0; i < count; i++ {
+		var res partResult
+		select {
+		case res = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if res.err != nil {
+			return res.err
+		}
+
+		_, err := writer.Write(res.data)
+		if err != nil {
+			return err
+		}
+
+		<-slots
+	}
+
+	return nil
+}
+
+// fetchPart downloads one part, a failed attempt resumes from the bytes it
+// already got.
+func fetchPart(ctx context.Context, store Storage, url string, start, size int64) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(int(size))
+	var err error
+	for attempt := 0; attempt <= partRetries; attempt++ {
+		if attempt > 0 {
+			Log(ctx, store).Warnf("part %d-%d retry %d err:%+v", start, start+size-1, attempt, err)
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		done := int64(buf.Len())
+		err = getRange(ctx, url, start+done, size-done, &buf)
+		if err == nil && int64(buf.Len()) == size {
+			return buf.Bytes(), nil
+		}
+
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+
+		// An expired link fails every part, the stream resolves it again
+		var se *statusError
+		if ctx.Err() != nil || errors.Is(err, errRangeIgnored) || errors.As(err, &se) {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+func getRange(ctx context.Context, url string, offset, length int64, writer io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// A whole file from the start still holds the range
+	if resp.StatusCode == http.StatusOK && offset > 0 {
+		return errRangeIgnored
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return newStatusError(resp)
+	}
+
+	_, err = io.Copy(writer, io.LimitReader(resp.Body, length))
+	return err
+}
@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "storage-test")
+	if err != nil {
+		panic(err)
+	}
+
+	log.InitCore(conf.Log{Filename: filepath.Join(dir, "test.log")})
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// fakeStore only carries what the download path reads of a storage.
+type fakeStore struct {
+	model.Storage
+	config Config
+}
+
+func newFakeStore(connections int) *fakeStore {
+	store := &fakeStore{config: Config{Name: "fake", MaxConnections: 8}}
+	store.MountPath = "/fake"
+	store.Connections = connections
+	return store
+}
+
+func (self *fakeStore) GetConfig() Config   { return self.config }
+func (self *fakeStore) GetExtra() ExtraItem { return nil }
+func (self *fakeStore) Mount() error        { return nil }
+func (self *fakeStore) AllowCache() bool    { return false }
+func (self *fakeStore) IsDirect() bool      { return false }
+func (self *fakeStore) List(ctx context.Context, info msg.Finfo) ([]msg.Finfo, error) {
+	return nil, nil
+}
+
+func (self *fakeStore) Link(ctx context.Context, info msg.Finfo) (*msg.LinkInfo, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (self *fakeStore) StreamFile(ctx context.Context, path string, writer io.Writer) error {
+	return errors.New("not implemented")
+}
+
+func (self *fakeStore) StreamRange(ctx context.Context, path string, offset, length int64, writer io.Writer) error {
+	return errors.New("not implemented")
+}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	return data
+}
+
+// rangeStart is where the Range header of r starts, 0 without one.
+func rangeStart(r *http.Request) int64 {
+	var start, end int64
+	fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+	return start
+}
+
+func TestParallelRangeOrder(t *testing.T) {
+	data := testData(4*partSize + partSize/2)
+	var active, peak atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+
+		// Earlier parts answer later, they still have to be written first
+		part := rangeStart(r) / partSize
+		time.Sleep(time.Duration(5-part) * 20 * time.Millisecond)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	offset, length := int64(100), int64(len(data)-200)
+	err := parallelRange(context.Background(), newFakeStore(3), srv.URL, offset, length, 3, &buf)
+	if err != nil {
+		t.Fatalf("parallelRange: %v", err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), data[offset:offset+length]) {
+		t.Fatalf("got %d bytes out of order or wrong, want %d", buf.Len(), length)
+	}
+
+	if got := peak.Load(); got > 3 {
+		t.Errorf("%d requests at once over 3 connections", got)
+	}
+}
+
+func TestParallelRangeIgnored(t *testing.T) {
+	data := testData(3 * partSize)
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	err := parallelRange(context.Background(), newFakeStore(2), srv.URL, 0, int64(len(data)), 2, io.Discard)
+	if !errors.Is(err, errRangeIgnored) {
+		t.Fatalf("err = %v, want errRangeIgnored", err)
+	}
+
+	// Retrying cannot help, every part past the first fails once
+	if got := requests.Load(); got > 3 {
+		t.Errorf("%d requests for 3 parts, a refused range was retried", got)
+	}
+}
+
+func TestParallelRangeResumeDroppedPart(t *testing.T) {
+	data := testData(3 * partSize)
+	var lock sync.Mutex
+	var starts []int64
+	var dropped atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := rangeStart(r)
+		lock.Lock()
+		starts = append(starts, start)
+		lock.Unlock()
+
+		// The second part breaks off halfway once
+		if start == partSize && dropped.CompareAndSwap(false, true) {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, 2*partSize-1, len(data)))
+			w.Header().Set("Content-Length", fmt.Sprint(partSize))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[start : start+partSize/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	err := parallelRange(context.Background(), newFakeStore(2), srv.URL, 0, int64(len(data)), 2, &buf)
+	if err != nil {
+		t.Fatalf("parallelRange: %v", err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("got %d bytes, not the file", buf.Len())
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	resumed := false
+	for _, v := range starts {
+		if v == partSize+partSize/2 {
+			resumed = true
+		}
+	}
+
+	if !resumed {
+		t.Errorf("dropped part was not resumed past the bytes it got, requests at %v", starts)
+	}
+}
+
+func TestParallelRangeCancel(t *testing.T) {
+	data := testData(4 * partSize)
+	var requests, cancelled atomic.Int64
+	started := make(chan struct{}, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		started <- struct{}{}
+		<-r.Context().Done()
+		cancelled.Add(1)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- parallelRange(ctx, newFakeStore(2), srv.URL, 0, int64(len(data)), 2, io.Discard)
+	}()
+
+	<-started
+	<-started
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("parallelRange still running after cancel")
+	}
+
+	// Every request the server saw has its context cancelled by the client
+	deadline := time.Now().Add(2 * time.Second)
+	for cancelled.Load() < requests.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d in-flight requests not cancelled", requests.Load()-cancelled.Load(), requests.Load())
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -2,7 +2,10 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"go.uber.org/zap"
 	"io"
+	"overlink.top/app/system/log"
 	"overlink.top/app/system/model"
 	"overlink.top/app/system/msg"
 )
@@ -11,8 +14,15 @@ type Config struct {
 	Name    string `json:"name"`
 	Direct  bool   `json:"direct"`
 	NoCache bool
+	// Ranged GETs a single download may run at once, 0 keeps downloads on
+	// one connection whatever the mount asks for
+	MaxConnections int
 }
 
+// ErrRateLimited is returned by engines refusing a call over their own rate
+// limit or told so by the remote API.
+var ErrRateLimited = errors.New("too many requests")
+
 type ExtraItem interface{}
 
 type Storage interface {
@@ -21,8 +31,8 @@ type Storage interface {
 	GetData() *model.Storage
 	GetExtra() ExtraItem
 	Mount() error
-	List(info msg.Finfo) (list []msg.Finfo, err error)
-	Link(info msg.Finfo) (*msg.LinkInfo, error)
+	List(ctx context.Context, info msg.Finfo) (list []msg.Finfo, err error)
+	Link(ctx context.Context, info msg.Finfo) (*msg.LinkInfo, error)
 	AllowCache() bool
 	IsDirect() bool
 
@@ -38,12 +48,25 @@ type FormItem struct {
 	Options  string `json:"options"`
 	Required bool   `json:"required"`
 	Tip      bool   `json:"tip"`
+	Secret   bool   `json:"secret"`
 }
 
 type Form struct {
 	Extra []FormItem `json:"extra"`
 }
 
+// Log returns the request logger of ctx tagged with the mount and engine of
+// store, engines use it so their lines can be traced back to a request.
+func Log(ctx context.Context, store Storage) *zap.SugaredLogger {
+	return log.Ctx(ctx).With("mount", store.GetData().MountPath, "engine", store.GetConfig().Name)
+}
+
 type Getter interface {
-	Get(rpath string) (info msg.Finfo, err error)
+	Get(ctx context.Context, rpath string) (info msg.Finfo, err error)
+}
+
+// Budgeted is implemented by engines whose List calls are rate limited,
+// background jobs back off while the budget runs low.
+type Budgeted interface {
+	ListBudget() (left int, max int)
 }
@@ -6,107 +6,198 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"overlink.top/app/system/msg"
+	"strings"
+	"time"
 )
 
+// Times a download resumes after an expired link or a dropped connection
+const linkRetries = 3
+
+// LinkFunc resolves the download link of info for the stream layer, refresh
+// asks for a new link in place of any cached one.
+type LinkFunc func(ctx context.Context, store Storage, info msg.Finfo, refresh bool) (*msg.LinkInfo, error)
+
+var linkFunc LinkFunc = func(ctx context.Context, store Storage, info msg.Finfo, refresh bool) (*msg.LinkInfo, error) {
+	return store.Link(ctx, info)
+}
+
+// OnLink replaces how streams resolve links, logic shares its link cache
+// through it.
+func OnLink(fn LinkFunc) {
+	linkFunc = fn
+}
+
 // DefaultStreamFile provides a default implementation for StreamFile
 // that uses the Link method and streams from the URL
 func DefaultStreamFile(ctx context.Context, store Storage, rpath string, writer io.Writer) error {
-	// Get file info
-	getter, ok := store.(Getter)
-	if !ok {
-		return errors.New("storage does not implement Getter interface")
-	}
-
-	info, err := getter.Get(rpath)
+	info, err := streamInfo(ctx, store, rpath)
 	if err != nil {
 		return err
 	}
 
-	if info.IsDir() {
-		return errors.New("cannot stream a directory")
-	}
+	return streamRemote(ctx, store, info, 0, info.GetSize(), true, writer)
+}
 
-	// Get link to file
-	linkInfo, err := store.Link(info)
+// DefaultStreamRange provides a default implementation for StreamRange
+// that uses the Link method and streams a range from the URL
+func DefaultStreamRange(ctx context.Context, store Storage, rpath string, offset, length int64, writer io.Writer) error {
+	info, err := streamInfo(ctx, store, rpath)
 	if err != nil {
 		return err
 	}
 
-	// If it's a direct file (local storage), stream directly
-	if store.IsDirect() {
-		// For direct files, we can open and stream directly
-		// This is a simplified version - in practice, this would depend on the specific implementation
-		return errors.New("direct file streaming not implemented in default handler")
+	return streamRemote(ctx, store, info, offset, length, false, writer)
+}
+
+// streamInfo looks rpath up for a download, engines key links on the path
+// Get may leave empty.
+func streamInfo(ctx context.Context, store Storage, rpath string) (*msg.FileInfo, error) {
+	getter, ok := store.(Getter)
+	if !ok {
+		return nil, errors.New("storage does not implement Getter interface")
 	}
 
-	// For remote files, download and stream with context awareness
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, linkInfo.Url, nil)
+	info, err := getter.Get(ctx, rpath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
+	if info.IsDir() {
+		return nil, errors.New("cannot stream a directory")
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return errors.New("failed to download file")
+	// Local storages open files themselves, they never come through here
+	if store.IsDirect() {
+		return nil, errors.New("direct file streaming not implemented in default handler")
 	}
 
-	return copyWithContext(ctx, writer, resp.Body)
+	return &msg.FileInfo{FileId: info.GetFileId(), Path: rpath, Name: info.GetName(), Size: info.GetSize(), Modified: info.ModTime(), RawUrl: info.GetRaw()}, nil
 }
 
-// DefaultStreamRange provides a default implementation for StreamRange
-// that uses the Link method and streams a range from the URL
-func DefaultStreamRange(ctx context.Context, store Storage, rpath string, offset, length int64, writer io.Writer) error {
-	// Get file info
-	getter, ok := store.(Getter)
-	if !ok {
-		return errors.New("storage does not implement Getter interface")
+// streamRemote writes [offset, offset+length) of info from its download link.
+// When the storage stops honouring the link it is resolved again, after that
+// or a dropped connection the download resumes past the last byte written.
+func streamRemote(ctx context.Context, store Storage, info msg.Finfo, offset, length int64, whole bool, writer io.Writer) error {
+	cw := &offsetWriter{w: writer}
+	var refresh bool
+	var err error
+	for attempt := 0; attempt <= linkRetries; attempt++ {
+		if attempt > 0 {
+			Log(ctx, store).Warnf("stream %s resume at %d retry %d err:%+v", info.GetPath(), offset+cw.n, attempt, err)
+			if !refresh {
+				select {
+				case <-time.After(time.Duration(attempt) * time.Second):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+
+		var linkInfo *msg.LinkInfo
+		linkInfo, err = linkFunc(ctx, store, info, refresh)
+		if err != nil {
+			return err
+		}
+
+		done := cw.n
+		if n := connections(store); n > 1 && length-done > partSize {
+			err = parallelRange(ctx, store, linkInfo.Url, offset+done, length-done, n, cw)
+		} else if whole && done == 0 {
+			err = getWhole(ctx, linkInfo.Url, cw)
+		} else {
+			err = getRange(ctx, linkInfo.Url, offset+done, length-done, cw)
+		}
+
+		if err == nil && length > 0 && cw.n < length {
+			err = io.ErrUnexpectedEOF
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		// The client went away, or there is no size to resume against
+		if cw.err != nil || ctx.Err() != nil || length <= 0 {
+			return err
+		}
+
+		// Other refusals of the storage are final
+		refresh = linkExpired(err)
+		var se *statusError
+		if !refresh && (errors.As(err, &se) || errors.Is(err, errRangeIgnored)) {
+			return err
+		}
 	}
 
-	info, err := getter.Get(rpath)
+	return err
+}
+
+// offsetWriter counts what reached the writer, a failed write is kept apart
+// from download errors.
+type offsetWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (self *offsetWriter) Write(p []byte) (int, error) {
+	n, err := self.w.Write(p)
+	self.n += int64(n)
 	if err != nil {
-		return err
+		self.err = err
 	}
 
-	if info.IsDir() {
-		return errors.New("cannot stream a directory")
-	}
+	return n, err
+}
 
-	// Get link to file
-	linkInfo, err := store.Link(info)
-	if err != nil {
-		return err
+// statusError is a download answered with an unexpected status, the start of
+// the body tells some expired links apart.
+type statusError struct {
+	status string
+	code   int
+	body   string
+}
+
+func (self *statusError) Error() string {
+	return fmt.Sprintf("failed to download file: %s", self.status)
+}
+
+func newStatusError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &statusError{status: resp.Status, code: resp.StatusCode, body: string(body)}
+}
+
+// linkExpired tells if a download failed because its link is no longer
+// valid, signed CDN links answer 403 or 410 once past their expiry.
+func linkExpired(err error) bool {
+	var se *statusError
+	if !errors.As(err, &se) {
+		return false
 	}
 
-	// If it's a direct file (local storage), stream directly
-	if store.IsDirect() {
-		// For direct files, we can open and stream directly
-		// This is a simplified version - in practice, this would depend on the specific implementation
-		return errors.New("direct file range streaming not implemented in default handler")
+	switch se.code {
+	case http.StatusForbidden, http.StatusGone:
+		return true
 	}
 
-	// For remote files, download range and stream
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, linkInfo.Url, nil)
+	return se.code >= 400 && se.code < 500 && strings.Contains(strings.ToLower(se.body), "expired")
+}
+
+func getWhole(ctx context.Context, url string, writer io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return err
 	}
 
-	// Set range header
-	rangeHeader := "bytes=" + fmt.Sprintf("%d-%d", offset, offset+length-1)
-	req.Header.Set("Range", rangeHeader)
-
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
-		return errors.New("failed to download file range")
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError(resp)
 	}
 
 	return copyWithContext(ctx, writer, resp.Body)
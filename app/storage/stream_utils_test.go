@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"overlink.top/app/system/msg"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubLink answers links from fn for the length of the test.
+func stubLink(t *testing.T, fn LinkFunc) {
+	old := linkFunc
+	linkFunc = fn
+	t.Cleanup(func() { linkFunc = old })
+}
+
+func TestStreamRemoteRefreshExpiredLink(t *testing.T) {
+	data := testData(1000)
+	tests := []struct {
+		name string
+		code int
+		body string
+	}{
+		{"forbidden", http.StatusForbidden, ""},
+		{"gone", http.StatusGone, ""},
+		{"expired in body", http.StatusBadRequest, "Request has Expired"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/stale" {
+					http.Error(w, tt.body, tt.code)
+					return
+				}
+
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+			}))
+			defer srv.Close()
+
+			var calls, refreshes atomic.Int64
+			stubLink(t, func(ctx context.Context, store Storage, info msg.Finfo, refresh bool) (*msg.LinkInfo, error) {
+				calls.Add(1)
+				if refresh {
+					refreshes.Add(1)
+					return &msg.LinkInfo{Url: srv.URL + "/fresh"}, nil
+				}
+
+				return &msg.LinkInfo{Url: srv.URL + "/stale"}, nil
+			})
+
+			var buf bytes.Buffer
+			info := &msg.FileInfo{Path: "/a", Size: int64(len(data))}
+			err := streamRemote(context.Background(), newFakeStore(0), info, 0, info.Size, true, &buf)
+			if err != nil {
+				t.Fatalf("streamRemote: %v", err)
+			}
+
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Errorf("got %d bytes, not the file", buf.Len())
+			}
+
+			if calls.Load() != 2 || refreshes.Load() != 1 {
+				t.Errorf("%d link calls with %d refreshes, want 2 with 1", calls.Load(), refreshes.Load())
+			}
+		})
+	}
+}
+
+func TestStreamRemoteResumeDroppedBody(t *testing.T) {
+	data := testData(3000)
+	var lock sync.Mutex
+	var starts []int64
+	var dropped atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		starts = append(starts, rangeStart(r))
+		lock.Unlock()
+
+		// The first answer breaks off partway through the body
+		if dropped.CompareAndSwap(false, true) {
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			w.Write(data[:1000])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	stubLink(t, func(ctx context.Context, store Storage, info msg.Finfo, refresh bool) (*msg.LinkInfo, error) {
+		return &msg.LinkInfo{Url: srv.URL}, nil
+	})
+
+	var buf bytes.Buffer
+	info := &msg.FileInfo{Path: "/a", Size: int64(len(data))}
+	err := streamRemote(context.Background(), newFakeStore(0), info, 0, info.Size, true, &buf)
+	if err != nil {
+		t.Fatalf("streamRemote: %v", err)
+	}
+
+	// Nothing written twice, nothing skipped
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("got %d bytes, not the file", buf.Len())
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(starts) != 2 || starts[1] <= 0 || starts[1] > 1000 {
+		t.Errorf("requests at %v, want a resume within the first 1000 bytes", starts)
+	}
+}
+
+func TestStreamRemoteGiveUp(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	var calls atomic.Int64
+	stubLink(t, func(ctx context.Context, store Storage, info msg.Finfo, refresh bool) (*msg.LinkInfo, error) {
+		calls.Add(1)
+		return &msg.LinkInfo{Url: srv.URL}, nil
+	})
+
+	info := &msg.FileInfo{Path: "/a", Size: 1000}
+	err := streamRemote(context.Background(), newFakeStore(0), info, 0, info.Size, true, &bytes.Buffer{})
+	var se *statusError
+	if !errors.As(err, &se) || se.code != http.StatusForbidden {
+		t.Fatalf("err = %v, want the 403", err)
+	}
+
+	if calls.Load() != linkRetries+1 || requests.Load() != linkRetries+1 {
+		t.Errorf("%d links and %d requests, want %d of each", calls.Load(), requests.Load(), linkRetries+1)
+	}
+}
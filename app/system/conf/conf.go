@@ -30,6 +30,8 @@ type Log struct {
 	Compress   bool   `ini:"compress"`
 	Level      int    `ini:"Level"`
 	Stdout     bool   `ini:"stdout"`
+	// One JSON object per line for log collectors instead of the console layout
+	Json bool `ini:"json"`
 }
 
 type Database struct {
@@ -48,9 +50,11 @@ type Database struct {
 }
 
 type Secure struct {
-	TokenExpire int    `ini:"token_expire"`
-	JwtSecret   string `ini:"jwt_secret"`
-	SignKey     string `ini:"sign_key"`
+	TokenExpire   int      `ini:"token_expire"`
+	JwtSecret     string   `ini:"jwt_secret"`
+	SignKey       string   `ini:"sign_key"`
+	SecretKey     string   `ini:"secret_key"`
+	OldSecretKeys []string `ini:"old_secret_keys"`
 }
 
 type WebDAV struct {
@@ -59,12 +63,118 @@ type WebDAV struct {
 	BufferSize      int `ini:"buffer_size"`
 }
 
+type Ldap struct {
+	Enable       bool     `ini:"enable"`
+	Url          string   `ini:"url"`
+	StartTLS     bool     `ini:"start_tls"`
+	SkipVerify   bool     `ini:"skip_verify"`
+	BindDN       string   `ini:"bind_dn"`
+	BindPassword string   `ini:"bind_password"`
+	BaseDN       string   `ini:"base_dn"`
+	UserFilter   string   `ini:"user_filter"`
+	GroupAttr    string   `ini:"group_attr"`
+	AdminGroups  []string `ini:"admin_groups"`
+	ViewerGroups []string `ini:"viewer_groups"`
+}
+
+type Cache struct {
+	Type          string `ini:"type"`
+	Path          string `ini:"path"`
+	RedisAddr     string `ini:"redis_addr"`
+	RedisPassword string `ini:"redis_password"`
+	RedisDb       int    `ini:"redis_db"`
+	Prefix        string `ini:"prefix"`
+	ListTTL       int    `ini:"list_ttl"`
+	LinkTTL       int    `ini:"link_ttl"`
+}
+
+type Audit struct {
+	Disable       bool `ini:"disable"`
+	RetentionDays int  `ini:"retention_days"`
+}
+
+type Metrics struct {
+	Enable   bool     `ini:"enable"`
+	Token    string   `ini:"token"`
+	AllowIps []string `ini:"allow_ips"`
+}
+
+type Trace struct {
+	Enable      bool    `ini:"enable"`
+	Endpoint    string  `ini:"endpoint"`
+	Insecure    bool    `ini:"insecure"`
+	ServiceName string  `ini:"service_name"`
+	SampleRatio float64 `ini:"sample_ratio"`
+}
+
+type Search struct {
+	Enable bool   `ini:"enable"`
+	Path   string `ini:"path"`
+	// Minutes between incremental crawls, folders with an unchanged mtime are not listed again
+	Interval int `ini:"interval"`
+	// Hours between crawls that list every folder
+	FullInterval int `ini:"full_interval"`
+	// Milliseconds between two List calls on the same mount
+	Pace int `ini:"pace"`
+}
+
+type Thumb struct {
+	Disable bool   `ini:"disable"`
+	Path    string `ini:"path"`
+	Workers int    `ini:"workers"`
+	// Empty to look ffmpeg up in PATH, video posters and WebP need it
+	Ffmpeg string `ini:"ffmpeg"`
+	// Megabytes, larger pictures get no thumbnail
+	MaxSource     int `ini:"max_source"`
+	Quality       int `ini:"quality"`
+	RetentionDays int `ini:"retention_days"`
+}
+
+type Hls struct {
+	Enable  bool   `ini:"enable"`
+	Path    string `ini:"path"`
+	Ffmpeg  string `ini:"ffmpeg"`
+	Ffprobe string `ini:"ffprobe"`
+	// Heights of the transcoded renditions, H.264 sources are also remuxed as is
+	Renditions []int `ini:"renditions"`
+	Segment    int   `ini:"segment"`
+	// Seconds without requests before a transcode is stopped
+	IdleTimeout int `ini:"idle_timeout"`
+	// Hours finished segments stay cached after the last request
+	CacheHours  int `ini:"cache_hours"`
+	MaxSessions int `ini:"max_sessions"`
+}
+
+type ChunkCache struct {
+	Path string `ini:"path"`
+	// Megabytes per chunk, remote files are fetched and stored in chunks
+	ChunkSize int `ini:"chunk_size"`
+	// Megabytes, least recently read chunks are dropped past it
+	MaxSize int `ini:"max_size"`
+}
+
+type Zip struct {
+	// Megabytes, selections whose files add up to more are refused
+	MaxSize    int `ini:"max_size"`
+	MaxEntries int `ini:"max_entries"`
+}
+
 type Config struct {
-	Server   `ini:"server"`
-	Database `ini:"database"`
-	Log      `ini:"log"`
-	Secure   `ini:"secure"`
-	WebDAV   `ini:"webdav"`
+	Server     `ini:"server"`
+	Database   `ini:"database"`
+	Log        `ini:"log"`
+	Secure     `ini:"secure"`
+	WebDAV     `ini:"webdav"`
+	Ldap       `ini:"ldap"`
+	Audit      `ini:"audit"`
+	Cache      `ini:"cache"`
+	Metrics    `ini:"metrics"`
+	Trace      `ini:"trace"`
+	Search     `ini:"search"`
+	Thumb      `ini:"thumb"`
+	Hls        `ini:"hls"`
+	Zip        `ini:"zip"`
+	ChunkCache `ini:"chunk_cache"`
 }
 
 var (
@@ -129,12 +239,67 @@ func genLocalConf() {
 		TokenExpire: 72,
 		JwtSecret:   util.GenRandStr(16),
 		SignKey:     util.GenRandStr(16),
+		SecretKey:   util.GenSecureStr(32),
 	}
 	AppConf.WebDAV = WebDAV{
 		CacheSize:       1024,
 		MetadataCacheTTL: 60,
 		BufferSize:      512 * 1024,
 	}
+	AppConf.Ldap = Ldap{
+		Url:        "ldap://127.0.0.1:389",
+		UserFilter: "(uid=%s)",
+		GroupAttr:  "memberOf",
+	}
+	AppConf.Cache = Cache{
+		Type:    "memory",
+		Path:    "runtime/data/cache.db",
+		Prefix:  "showta:",
+		ListTTL: 300,
+		LinkTTL: 300,
+	}
+	AppConf.Audit = Audit{
+		RetentionDays: 180,
+	}
+	AppConf.Metrics = Metrics{
+		AllowIps: []string{"127.0.0.1", "::1"},
+	}
+	AppConf.Trace = Trace{
+		Endpoint:    "127.0.0.1:4318",
+		Insecure:    true,
+		ServiceName: "showta",
+		SampleRatio: 1,
+	}
+	AppConf.Search = Search{
+		Path:         "runtime/data/search.db",
+		Interval:     30,
+		FullInterval: 24,
+		Pace:         200,
+	}
+	AppConf.Thumb = Thumb{
+		Path:          "runtime/thumbs",
+		Workers:       2,
+		MaxSource:     50,
+		Quality:       80,
+		RetentionDays: 30,
+	}
+	AppConf.Hls = Hls{
+		Path:        "runtime/hls",
+		Renditions:  []int{720, 480},
+		Segment:     6,
+		IdleTimeout: 120,
+		CacheHours:  24,
+		MaxSessions: 2,
+	}
+	AppConf.ChunkCache = ChunkCache{
+		Path:      "runtime/chunks",
+		ChunkSize: 4,
+		MaxSize:   10240,
+	}
+	AppConf.Zip = Zip{
+		MaxSize:    4096,
+		MaxEntries: 10000,
+	}
 	createIniFile()
 }
 
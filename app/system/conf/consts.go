@@ -6,6 +6,48 @@ const (
 	Guest      = 9
 )
 
+const (
+	SourceLocal = "local"
+	SourceLdap  = "ldap"
+)
+
+const (
+	ActionRead   = "read"
+	ActionWrite  = "write"
+	ActionShare  = "share"
+	EffectAllow  = "allow"
+	EffectDeny   = "deny"
+	SubjectUser  = "user"
+	SubjectGroup = "group"
+)
+
+// Permissions a custom role can delegate to a non-super user
+const (
+	PermStorage    = "storage"
+	PermFolder     = "folder"
+	PermPreference = "preference"
+)
+
+const (
+	ApiTokenPrefix = "sta_"
+	ScopeRead      = "read"
+	ScopeWebdav    = "webdav"
+	ScopeAdmin     = "admin"
+)
+
+// Audience of the short-lived token /admin/log/tail accepts in its query
+const AudienceLogTail = "log.tail"
+
+// Download modes of a storage
+const (
+	DownloadProxy    = "proxy"
+	DownloadRedirect = "redirect"
+	DownloadCache    = "cache"
+)
+
+// SecretMask replaces secret storage fields in admin responses
+const SecretMask = "******"
+
 const (
 	Folder = iota
 	Video
@@ -78,6 +78,14 @@ var (
 	}
 )
 
+// MenuPermMap lists the admin menus a delegated role can be granted
+var MenuPermMap = map[string]string{
+	"storage": PermStorage,
+	"folder":  PermFolder,
+	"site":    PermPreference,
+	"display": PermPreference,
+}
+
 func init() {
 	for _, v := range AdminMenuList {
 		if !v.IsAdmin {
@@ -0,0 +1,47 @@
+package log
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+type requestIdKey struct{}
+
+// WithRequestId binds the request id to ctx, every logger taken from it with
+// Ctx carries the id.
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+func RequestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// Ctx returns the logger for a request, lines carry the request id and the
+// trace id so they can be matched with the spans of the same request.
+func Ctx(ctx context.Context) *zap.SugaredLogger {
+	if ctx == nil {
+		return sugar
+	}
+
+	var fields []interface{}
+	if id := RequestId(ctx); id != "" {
+		fields = append(fields, "request_id", id)
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields, "trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+	}
+
+	if len(fields) == 0 {
+		return sugar
+	}
+
+	return sugar.With(fields...)
+}
@@ -19,7 +19,7 @@ func GinLogger() gin.HandlerFunc {
 		c.Next()
 
 		cost := time.Since(start)
-		sugar.Infof("[GIN] | %3d | %13v | %15s | %-7s  %#v",
+		Ctx(c.Request.Context()).Infof("[GIN] | %3d | %13v | %15s | %-7s  %#v",
 			c.Writer.Status(),
 			cost,
 			c.ClientIP(),
@@ -14,6 +14,9 @@ var lg *zap.Logger
 var sugar *zap.SugaredLogger
 var stdLogger *zap.SugaredLogger
 
+// atomLevel is shared by both cores so SetLevel takes effect without a restart
+var atomLevel = zap.NewAtomicLevel()
+
 func InitCore(cfg conf.Log) {
 	// Custom time output format
 	customTimeEncoder := func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
@@ -38,6 +41,11 @@ func InitCore(cfg conf.Log) {
 	encoderConfig.EncodeCaller = customCallerEncoder
 	//NewConsoleEncoder outputs plain text format
 	encoder := zapcore.NewConsoleEncoder(encoderConfig)
+	if cfg.Json {
+		jsonConfig := zap.NewProductionEncoderConfig()
+		jsonConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoder = zapcore.NewJSONEncoder(jsonConfig)
+	}
 
 	//File writeSyncer
 	fullFilename := conf.AbsPath(cfg.Filename)
@@ -49,9 +57,10 @@ func InitCore(cfg conf.Log) {
 		Compress:   cfg.Compress,
 	})
 
-	opts := []zapcore.WriteSyncer{}
+	opts := []zapcore.WriteSyncer{tail}
 	multiOpts := []zapcore.WriteSyncer{
 		zapcore.AddSync(os.Stdout),
+		tail,
 	}
 	if cfg.Stdout {
 		opts = append(opts, zapcore.AddSync(os.Stdout))
@@ -62,7 +71,8 @@ func InitCore(cfg conf.Log) {
 		multiOpts = append(multiOpts, fileWriteSyncer)
 	}
 
-	level := adapteLevel(cfg.Level)
+	atomLevel.SetLevel(adapteLevel(cfg.Level))
+	level := atomLevel
 	syncWriter := zapcore.NewMultiWriteSyncer(opts...)
 	//The third and subsequent parameters are the log level for writing files, while the ErrorLevel mode only records logs at the error level
 	fileCore := zapcore.NewCore(encoder, syncWriter, level)
@@ -77,6 +87,25 @@ func InitCore(cfg conf.Log) {
 	lg = log
 }
 
+// SetLevel changes the level of every logger at runtime, level uses the
+// values of conf.Log.Level.
+func SetLevel(level int) {
+	atomLevel.SetLevel(adapteLevel(level))
+}
+
+func GetLevel() int {
+	switch atomLevel.Level() {
+	case zapcore.DebugLevel:
+		return -1
+	case zapcore.WarnLevel:
+		return 1
+	case zapcore.ErrorLevel:
+		return 2
+	default:
+		return 0
+	}
+}
+
 func adapteLevel(level int) zapcore.Level {
 	switch level {
 	case -1:
@@ -0,0 +1,56 @@
+package log
+
+import (
+	"sync"
+)
+
+// tailHub receives every encoded line and hands a copy to the live
+// subscribers, a slow subscriber loses lines instead of blocking logging.
+type tailHub struct {
+	lock sync.RWMutex
+	subs map[chan []byte]struct{}
+}
+
+var tail = &tailHub{subs: map[chan []byte]struct{}{}}
+
+func (self *tailHub) Write(p []byte) (int, error) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	if len(self.subs) == 0 {
+		return len(p), nil
+	}
+
+	line := append([]byte(nil), p...)
+	for ch := range self.subs {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+
+	return len(p), nil
+}
+
+func (self *tailHub) Sync() error {
+	return nil
+}
+
+// Subscribe streams log lines written from now on, call cancel once done.
+func Subscribe() (<-chan []byte, func()) {
+	ch := make(chan []byte, 256)
+	tail.lock.Lock()
+	tail.subs[ch] = struct{}{}
+	tail.lock.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			tail.lock.Lock()
+			delete(tail.subs, ch)
+			tail.lock.Unlock()
+		})
+	}
+
+	return ch, cancel
+}
@@ -0,0 +1,419 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"overlink.top/app/lib/util"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+	"strings"
+	"sync"
+)
+
+type identityKey struct{}
+
+var (
+	aclMap       sync.Map
+	userGroupMap sync.Map
+	roleMap      sync.Map
+)
+
+// WithIdentity attaches the authenticated user to a plain request context,
+// gin handlers keep using c.Set("identity", user).
+func WithIdentity(ctx context.Context, user *model.User) context.Context {
+	return context.WithValue(ctx, identityKey{}, user)
+}
+
+func Identity(ctx context.Context) *model.User {
+	if ctx == nil {
+		return nil
+	}
+
+	if user, ok := ctx.Value(identityKey{}).(*model.User); ok {
+		return user
+	}
+
+	if user, ok := ctx.Value("identity").(*model.User); ok {
+		return user
+	}
+
+	return nil
+}
+
+func loadAllAcl() {
+	aclList, err := model.GetAllAcl()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	aclMap.Range(func(key, value interface{}) bool {
+		aclMap.Delete(key)
+		return true
+	})
+
+	group := map[string][]model.Acl{}
+	for _, v := range aclList {
+		group[v.MountPath] = append(group[v.MountPath], v)
+	}
+
+	for k, v := range group {
+		aclMap.Store(k, v)
+	}
+
+	ugList, err := model.GetAllUserGroup()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	userGroupMap.Range(func(key, value interface{}) bool {
+		userGroupMap.Delete(key)
+		return true
+	})
+
+	members := map[uint][]uint{}
+	for _, v := range ugList {
+		members[v.UserID] = append(members[v.UserID], v.GroupID)
+	}
+
+	for k, v := range members {
+		userGroupMap.Store(k, v)
+	}
+
+	roleList, err := model.GetAllRole()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	roleMap.Range(func(key, value interface{}) bool {
+		roleMap.Delete(key)
+		return true
+	})
+
+	for _, v := range roleList {
+		roleMap.Store(v.ID, v)
+	}
+}
+
+// CheckAcl evaluates the mount ACL for the user bound to ctx. Requests without
+// identity (signed /fd links) and super users are not restricted here.
+func CheckAcl(ctx context.Context, rpath string, action string) bool {
+	user := Identity(ctx)
+	if user == nil || user.IsSuper() {
+		return true
+	}
+
+	store := findStorage(rpath)
+	if store == nil {
+		return true
+	}
+
+	return aclAllow(user, store.GetData().MountPath, action)
+}
+
+// aclAllow resolves the entries of one mount: user entries win over group
+// entries and deny wins within the same level. Once a mount has any allow
+// entry for an action, subjects not listed are refused.
+func aclAllow(user *model.User, mountPath string, action string) bool {
+	data, ok := aclMap.Load(mountPath)
+	if !ok {
+		return true
+	}
+
+	var groups []uint
+	if v, ok := userGroupMap.Load(user.ID); ok {
+		groups = v.([]uint)
+	}
+
+	var userEffect, groupEffect string
+	hasAllow := false
+	for _, v := range data.([]model.Acl) {
+		if v.Action != action {
+			continue
+		}
+
+		if v.Effect == conf.EffectAllow {
+			hasAllow = true
+		}
+
+		if v.Subject == conf.SubjectUser && v.SubjectID == user.ID {
+			userEffect = mergeEffect(userEffect, v.Effect)
+		} else if v.Subject == conf.SubjectGroup && containsId(groups, v.SubjectID) {
+			groupEffect = mergeEffect(groupEffect, v.Effect)
+		}
+	}
+
+	if userEffect != "" {
+		return userEffect == conf.EffectAllow
+	}
+
+	if groupEffect != "" {
+		return groupEffect == conf.EffectAllow
+	}
+
+	return !hasAllow
+}
+
+func mergeEffect(prev string, effect string) string {
+	if prev == conf.EffectDeny {
+		return prev
+	}
+
+	return effect
+}
+
+func containsId(list []uint, id uint) bool {
+	for _, v := range list {
+		if v == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getRole(user *model.User) (role model.Role, ok bool) {
+	if user.RoleID == 0 {
+		return
+	}
+
+	data, ok := roleMap.Load(user.RoleID)
+	if !ok {
+		return
+	}
+
+	return data.(model.Role), true
+}
+
+// HasPerm reports whether the user may use the admin APIs guarded by perm.
+func HasPerm(user *model.User, perm string) bool {
+	if user.IsSuper() {
+		return true
+	}
+
+	role, ok := getRole(user)
+	if !ok || perm == "" {
+		return false
+	}
+
+	for _, v := range strings.Split(role.Perms, ",") {
+		if strings.TrimSpace(v) == perm {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasAnyPerm reports whether the user holds any delegated permission, such
+// users reach part of the admin APIs.
+func hasAnyPerm(user *model.User) bool {
+	for _, v := range conf.MenuPermMap {
+		if HasPerm(user, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanManageMount limits delegated admins to the mounts listed on their role,
+// an empty list means every mount.
+func CanManageMount(ctx context.Context, mountPath string) bool {
+	user := Identity(ctx)
+	if user == nil {
+		return false
+	}
+
+	if user.IsSuper() {
+		return true
+	}
+
+	role, ok := getRole(user)
+	if !ok {
+		return false
+	}
+
+	if strings.TrimSpace(role.Mounts) == "" {
+		return true
+	}
+
+	for _, v := range strings.Split(role.Mounts, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" && isSubPath(v, mountPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkManageMount(ctx context.Context, mountPath string) error {
+	if !CanManageMount(ctx, mountPath) {
+		return msg.ErrNoPermission
+	}
+
+	return nil
+}
+
+func AddAcl(ctx context.Context, data model.Acl) error {
+	data.MountPath = util.StandardPath(data.MountPath)
+	if data.Action != conf.ActionRead && data.Action != conf.ActionWrite && data.Action != conf.ActionShare {
+		return errors.New("invalid acl action")
+	}
+
+	if data.Effect != conf.EffectAllow && data.Effect != conf.EffectDeny {
+		return errors.New("invalid acl effect")
+	}
+
+	if data.Subject != conf.SubjectUser && data.Subject != conf.SubjectGroup {
+		return errors.New("invalid acl subject")
+	}
+
+	err := model.CreateAcl(&data)
+	if err != nil {
+		return err
+	}
+
+	loadAllAcl()
+	Audit(ctx, "acl.add", data.MountPath, nil, data)
+	return nil
+}
+
+func DeleteAcl(ctx context.Context, id uint) error {
+	data, err := model.GetAcl(id)
+	if err != nil {
+		return err
+	}
+
+	err = model.DeleteAcl(id)
+	if err != nil {
+		return err
+	}
+
+	loadAllAcl()
+	Audit(ctx, "acl.delete", data.MountPath, data, nil)
+	return nil
+}
+
+func AddRole(ctx context.Context, data model.Role) error {
+	if data.Name == "" {
+		return errors.New("role name required")
+	}
+
+	err := model.CreateRole(&data)
+	if err != nil {
+		return err
+	}
+
+	loadAllAcl()
+	Audit(ctx, "role.add", data.Name, nil, data)
+	return nil
+}
+
+func UpdateRole(ctx context.Context, data model.Role) error {
+	oldData, err := model.GetRole(data.ID)
+	if err != nil {
+		return err
+	}
+
+	err = model.UpdateRole(&data)
+	if err != nil {
+		return err
+	}
+
+	loadAllAcl()
+	Audit(ctx, "role.update", data.Name, oldData, data)
+	return nil
+}
+
+func DeleteRole(ctx context.Context, id uint) error {
+	data, err := model.GetRole(id)
+	if err != nil {
+		return err
+	}
+
+	err = model.DeleteRole(id)
+	if err != nil {
+		return err
+	}
+
+	loadAllAcl()
+	Audit(ctx, "role.delete", data.Name, data, nil)
+	return nil
+}
+
+func AddGroup(ctx context.Context, data model.Group) error {
+	if data.Name == "" {
+		return errors.New("group name required")
+	}
+
+	err := model.CreateGroup(&data)
+	if err != nil {
+		return err
+	}
+
+	Audit(ctx, "group.add", data.Name, nil, data)
+	return nil
+}
+
+func UpdateGroup(ctx context.Context, data model.Group) error {
+	oldData, err := model.GetGroup(data.ID)
+	if err != nil {
+		return err
+	}
+
+	err = model.UpdateGroup(&data)
+	if err != nil {
+		return err
+	}
+
+	Audit(ctx, "group.update", data.Name, oldData, data)
+	return nil
+}
+
+func DeleteGroup(ctx context.Context, id uint) error {
+	data, err := model.GetGroup(id)
+	if err != nil {
+		return err
+	}
+
+	err = model.DeleteGroup(id)
+	if err != nil {
+		return err
+	}
+
+	err = model.DeleteAclBySubject(conf.SubjectGroup, id)
+	if err != nil {
+		return err
+	}
+
+	loadAllAcl()
+	Audit(ctx, "group.delete", data.Name, data, nil)
+	return nil
+}
+
+func SetGroupMember(ctx context.Context, req msg.GroupMemberReq) error {
+	data, err := model.GetGroup(req.GroupID)
+	if err != nil {
+		return err
+	}
+
+	before, err := model.GetGroupMember(req.GroupID)
+	if err != nil {
+		return err
+	}
+
+	err = model.SetGroupMember(req.GroupID, req.UserIds)
+	if err != nil {
+		return err
+	}
+
+	loadAllAcl()
+	Audit(ctx, "group.members", data.Name, map[string][]uint{"members": before}, map[string][]uint{"members": req.UserIds})
+	return nil
+}
@@ -0,0 +1,126 @@
+package logic
+
+import (
+	"context"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/model"
+	"sync"
+	"testing"
+)
+
+// resetMap empties a cache map and clears it again when the test ends.
+func resetMap(t *testing.T, m *sync.Map) {
+	empty := func() {
+		m.Range(func(key, value interface{}) bool {
+			m.Delete(key)
+			return true
+		})
+	}
+
+	empty()
+	t.Cleanup(empty)
+}
+
+func acl(subject string, id uint, action string, effect string) model.Acl {
+	return model.Acl{MountPath: "/m", Subject: subject, SubjectID: id, Action: action, Effect: effect}
+}
+
+func TestAclAllow(t *testing.T) {
+	const (
+		user  = 1
+		other = 2
+		group = 10
+	)
+
+	read, write := conf.ActionRead, conf.ActionWrite
+	allow, deny := conf.EffectAllow, conf.EffectDeny
+	userSubject, groupSubject := conf.SubjectUser, conf.SubjectGroup
+	tests := []struct {
+		name   string
+		list   []model.Acl
+		action string
+		want   bool
+	}{
+		{"no entries", nil, read, true},
+		{"user allow", []model.Acl{acl(userSubject, user, read, allow)}, read, true},
+		{"user deny", []model.Acl{acl(userSubject, user, read, deny)}, read, false},
+		{"group deny", []model.Acl{acl(groupSubject, group, read, deny)}, read, false},
+		{"user allow wins over group deny", []model.Acl{
+			acl(groupSubject, group, read, deny),
+			acl(userSubject, user, read, allow),
+		}, read, true},
+		{"user deny wins over group allow", []model.Acl{
+			acl(groupSubject, group, read, allow),
+			acl(userSubject, user, read, deny),
+		}, read, false},
+		{"deny wins among user entries", []model.Acl{
+			acl(userSubject, user, read, deny),
+			acl(userSubject, user, read, allow),
+		}, read, false},
+		{"deny wins among group entries", []model.Acl{
+			acl(groupSubject, group, read, allow),
+			acl(groupSubject, group+1, read, deny),
+		}, read, false},
+		{"unlisted refused once allow exists", []model.Acl{acl(userSubject, other, read, allow)}, read, false},
+		{"unlisted passes a mount with only denies", []model.Acl{acl(userSubject, other, read, deny)}, read, true},
+		{"entries of other actions ignored", []model.Acl{acl(userSubject, user, write, deny)}, read, true},
+		{"allow on another action does not restrict", []model.Acl{acl(userSubject, other, write, allow)}, read, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetMap(t, &aclMap)
+			resetMap(t, &userGroupMap)
+			userGroupMap.Store(uint(user), []uint{group, group + 1})
+			if tt.list != nil {
+				aclMap.Store("/m", tt.list)
+			}
+
+			got := aclAllow(&model.User{ID: user, Username: "u"}, "/m", tt.action)
+			if got != tt.want {
+				t.Errorf("aclAllow = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanManageMount(t *testing.T) {
+	resetMap(t, &roleMap)
+	roleMap.Store(uint(1), model.Role{ID: 1, Perms: conf.PermStorage})
+	roleMap.Store(uint(2), model.Role{ID: 2, Perms: conf.PermStorage, Mounts: "/a, /b/c"})
+
+	tests := []struct {
+		name  string
+		user  *model.User
+		mount string
+		want  bool
+	}{
+		{"no identity", nil, "/a", false},
+		{"super", &model.User{Username: "admin"}, "/x", true},
+		{"no role", &model.User{Username: "u"}, "/a", false},
+		{"unknown role", &model.User{Username: "u", RoleID: 9}, "/a", false},
+		{"role without mounts", &model.User{Username: "u", RoleID: 1}, "/x", true},
+		{"listed mount", &model.User{Username: "u", RoleID: 2}, "/a", true},
+		{"under listed mount", &model.User{Username: "u", RoleID: 2}, "/b/c/d", true},
+		{"parent of listed mount", &model.User{Username: "u", RoleID: 2}, "/b", false},
+		{"sibling prefix", &model.User{Username: "u", RoleID: 2}, "/ab", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.user != nil {
+				ctx = WithIdentity(ctx, tt.user)
+			}
+
+			if got := CanManageMount(ctx, tt.mount); got != tt.want {
+				t.Errorf("CanManageMount(%s) = %v, want %v", tt.mount, got, tt.want)
+			}
+
+			err := checkManageMount(ctx, tt.mount)
+			if (err == nil) != tt.want {
+				t.Errorf("checkManageMount(%s) = %v", tt.mount, err)
+			}
+		})
+	}
+}
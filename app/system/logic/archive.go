@@ -0,0 +1,151 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"overlink.top/app/internal/archive"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/msg"
+)
+
+// errNotArchive is returned for folders whose name looks like an archive,
+// callers then treat the path as a plain one.
+var errNotArchive = errors.New("not an archive")
+
+func loadArchive(ctx context.Context, apath string) (*archive.Index, error) {
+	info, err := getFile(ctx, apath)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return nil, errNotArchive
+	}
+
+	store := findStorage(apath)
+	if store == nil {
+		return nil, errors.New("dir not exist")
+	}
+
+	return archive.Load(ctx, &archive.Source{
+		Key:      apath,
+		Size:     info.GetSize(),
+		Modified: info.ModTime(),
+		Range: func(ctx context.Context, offset int64, length int64, w io.Writer) error {
+			return store.StreamRange(ctx, apath, offset, length, w)
+		},
+	})
+}
+
+func archiveFileInfo(apath string, e *archive.Entry) *msg.FileInfo {
+	rpath := apath
+	if e.Path != "" {
+		rpath += "/" + e.Path
+	}
+
+	return &msg.FileInfo{
+		Path:     rpath,
+		Name:     e.Name,
+		Size:     e.Size,
+		Modified: e.Modified,
+		IsFolder: e.IsFolder,
+	}
+}
+
+// listArchive lists a folder inside the archive apath, inner is empty for
+// the archive root.
+func listArchive(ctx context.Context, apath string, inner string) (list []msg.Finfo, err error) {
+	index, err := loadArchive(ctx, apath)
+	if err != nil {
+		return
+	}
+
+	entries, err := index.List(inner)
+	if err != nil {
+		return
+	}
+
+	for _, v := range entries {
+		list = append(list, archiveFileInfo(apath, v))
+	}
+
+	return
+}
+
+func getArchiveFile(ctx context.Context, apath string, inner string) (info msg.Finfo, err error) {
+	index, err := loadArchive(ctx, apath)
+	if err != nil {
+		return
+	}
+
+	e, err := index.Get(inner)
+	if err != nil {
+		return
+	}
+
+	return archiveFileInfo(apath, e), nil
+}
+
+// proxyArchiveFile streams one entry of the archive apath, it reports false
+// when apath turns out to be a folder.
+func proxyArchiveFile(r *http.Request, w http.ResponseWriter, apath string, inner string) bool {
+	ctx := r.Context()
+	index, err := loadArchive(ctx, apath)
+	if err == errNotArchive {
+		return false
+	}
+
+	var e *archive.Entry
+	if err == nil {
+		e, err = index.Get(inner)
+	}
+
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, archive.ErrNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, archive.ErrUnsupported), errors.Is(err, archive.ErrTooMany):
+			status = http.StatusUnsupportedMediaType
+		}
+
+		w.WriteHeader(status)
+		fmt.Fprint(w, "archive error:", err)
+		return true
+	}
+
+	if e.IsFolder {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "cannot stream a directory")
+		return true
+	}
+
+	if !e.Extractable() {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		fmt.Fprint(w, "archive error:", archive.ErrUnsupported)
+		return true
+	}
+
+	var readRange readRangeFunc
+	if e.Seekable() {
+		readRange = func(ctx context.Context, offset int64, length int64, w io.Writer) error {
+			return index.ExtractRange(ctx, e, offset, length, w)
+		}
+	}
+
+	err = serveContent(w, r, archiveFileInfo(apath, e), readRange, func(ctx context.Context, w io.Writer) error {
+		return index.Extract(ctx, e, w)
+	})
+	if err != nil {
+		if isClientDisconnectError(err) {
+			log.Ctx(ctx).Debugf("client disconnected during archive streaming: %s/%s", apath, inner)
+		} else {
+			log.Ctx(ctx).Errorf("archive streaming %s/%s error: %v", apath, inner, err)
+		}
+	}
+
+	return true
+}
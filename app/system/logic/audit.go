@@ -0,0 +1,253 @@
+package logic
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"overlink.top/app/lib/util"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	auditQueueSize = 1024
+	auditBatchSize = 100
+	auditMask      = "******"
+)
+
+type clientIpKey struct{}
+
+var (
+	auditCh   = make(chan *model.AuditLog, auditQueueSize)
+	auditStop = make(chan struct{})
+	auditDone = make(chan struct{})
+	// Values of these fields never reach the audit table, only the fact they changed
+	auditSecretKeys = map[string]bool{"password": true, "extra": true, "token": true}
+	auditIgnoreKeys = map[string]bool{"UpdatedAt": true, "modified": true, "login_ip": true, "login_time": true}
+)
+
+// WithClientIp keeps the remote address on plain request contexts so the
+// audit trail can tell where WebDAV and /fd requests came from.
+func WithClientIp(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIpKey{}, ip)
+}
+
+func clientIp(ctx context.Context) string {
+	if c, ok := ctx.(*gin.Context); ok {
+		return util.ClientIPSimple(c.Request)
+	}
+
+	if ip, ok := ctx.Value(clientIpKey{}).(string); ok {
+		return ip
+	}
+
+	return ""
+}
+
+func startAudit() {
+	if conf.AppConf.Audit.Disable {
+		return
+	}
+
+	go auditWorker()
+	go auditCleaner()
+}
+
+// Audit queues an entry for the user bound to ctx. Only the fields that differ
+// between before and after are kept, either side may be nil.
+func Audit(ctx context.Context, action string, target string, before interface{}, after interface{}) {
+	if conf.AppConf.Audit.Disable {
+		return
+	}
+
+	data := &model.AuditLog{
+		CreatedAt: time.Now(),
+		Ip:        clientIp(ctx),
+		Action:    action,
+		Target:    target,
+	}
+
+	if user := Identity(ctx); user != nil {
+		data.ActorID = user.ID
+		data.Actor = user.Username
+	}
+
+	data.Before, data.After = auditDiff(before, after)
+	select {
+	case auditCh <- data:
+	default:
+		log.Warnf("audit queue full, drop: %s %s", action, target)
+	}
+}
+
+func AuditDownload(r *http.Request, rpath string) {
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// Players fetch media in many ranges, only the first one counts as a download
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader != "" && !strings.HasPrefix(rangeHeader, "bytes=0-") {
+		return
+	}
+
+	Audit(r.Context(), "file.download", rpath, nil, nil)
+}
+
+func auditWorker() {
+	defer close(auditDone)
+	for {
+		select {
+		case data := <-auditCh:
+			saveAudit(data)
+		case <-auditStop:
+			for len(auditCh) > 0 {
+				saveAudit(<-auditCh)
+			}
+
+			return
+		}
+	}
+}
+
+// saveAudit writes data along with what queued up behind it.
+func saveAudit(data *model.AuditLog) {
+	batch := []*model.AuditLog{data}
+	for len(batch) < auditBatchSize && len(auditCh) > 0 {
+		batch = append(batch, <-auditCh)
+	}
+
+	err := model.BatchCreateAudit(batch)
+	if err != nil {
+		log.Errorf("save audit log err: %+v", err)
+	}
+}
+
+// flushAudit writes the entries still queued and stops the worker, entries
+// audited after it are not saved.
+func flushAudit(ctx context.Context) {
+	if conf.AppConf.Audit.Disable {
+		return
+	}
+
+	close(auditStop)
+	select {
+	case <-auditDone:
+	case <-ctx.Done():
+		log.StdErrorf("flush audit log err: %+v", ctx.Err())
+	}
+}
+
+func auditCleaner() {
+	for {
+		days := conf.AppConf.Audit.RetentionDays
+		if days > 0 {
+			count, err := model.DeleteAuditBefore(time.Now().AddDate(0, 0, -days))
+			if err != nil {
+				log.Errorf("purge audit log err: %+v", err)
+			} else if count > 0 {
+				log.Infof("purge audit log: %d", count)
+			}
+		}
+
+		time.Sleep(24 * time.Hour)
+	}
+}
+
+func auditDiff(before interface{}, after interface{}) (string, string) {
+	beforeMap := toAuditMap(before)
+	afterMap := toAuditMap(after)
+	if beforeMap != nil && afterMap != nil {
+		for k, v := range beforeMap {
+			if reflect.DeepEqual(v, afterMap[k]) {
+				delete(beforeMap, k)
+				delete(afterMap, k)
+			}
+		}
+	}
+
+	for _, m := range []map[string]interface{}{beforeMap, afterMap} {
+		for k, v := range m {
+			if auditIgnoreKeys[k] {
+				delete(m, k)
+			} else if auditSecretKeys[k] && v != "" {
+				m[k] = auditMask
+			}
+		}
+	}
+
+	return toAuditJson(beforeMap), toAuditJson(afterMap)
+}
+
+func toAuditMap(data interface{}) map[string]interface{} {
+	if data == nil || reflect.ValueOf(data).Kind() == reflect.Ptr && reflect.ValueOf(data).IsNil() {
+		return nil
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil
+	}
+
+	m := map[string]interface{}{}
+	if json.Unmarshal(jsonData, &m) != nil {
+		return nil
+	}
+
+	return m
+}
+
+func toAuditJson(m map[string]interface{}) string {
+	if len(m) == 0 {
+		return ""
+	}
+
+	jsonData, _ := json.Marshal(m)
+	return string(jsonData)
+}
+
+func ListAudit(ctx context.Context, req msg.ListAuditReq) (resp msg.ListAuditResp, err error) {
+	if req.Pagenum < 1 {
+		req.Pagenum = 1
+	}
+
+	if req.Pagesize < 1 || req.Pagesize > 200 {
+		req.Pagesize = 20
+	}
+
+	filter := model.AuditFilter{
+		Actor:  req.Actor,
+		Action: req.Action,
+		Target: req.Target,
+	}
+
+	if req.Start > 0 {
+		filter.Start = time.Unix(req.Start, 0)
+	}
+
+	if req.End > 0 {
+		filter.End = time.Unix(req.End, 0)
+	}
+
+	total, err := model.CountAuditByFilter(filter)
+	if err != nil {
+		return
+	}
+
+	offset := (req.Pagenum - 1) * req.Pagesize
+	dataList, err := model.GetAuditListByFilter(filter, req.Pagesize, offset)
+	if err != nil {
+		return
+	}
+
+	resp.Total = total
+	resp.Pagenum = req.Pagenum
+	resp.AuditList = dataList
+	return
+}
@@ -0,0 +1,68 @@
+package logic
+
+import (
+	"context"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+	"testing"
+	"time"
+)
+
+func TestFlushAuditSavesQueued(t *testing.T) {
+	go auditWorker()
+
+	const count = 250
+	ctx := WithIdentity(context.Background(), &model.User{ID: 1, Username: "admin"})
+	for i := 0; i < count; i++ {
+		Audit(ctx, "test.flush", "/t", nil, nil)
+	}
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	flushAudit(flushCtx)
+
+	total, err := model.CountAuditByFilter(model.AuditFilter{Action: "test.flush"})
+	if err != nil {
+		t.Fatalf("CountAuditByFilter: %v", err)
+	}
+
+	if total != count {
+		t.Errorf("%d of %d queued entries saved on shutdown", total, count)
+	}
+}
+
+func TestListAuditLiteralWildcards(t *testing.T) {
+	now := time.Now()
+	err := model.BatchCreateAudit([]*model.AuditLog{
+		{CreatedAt: now, Action: "like.a_b", Target: "/x/50%_off"},
+		{CreatedAt: now, Action: "like.axb", Target: "/x/50x-off"},
+		{CreatedAt: now, Action: "like.a!b", Target: "/x/a!b"},
+	})
+	if err != nil {
+		t.Fatalf("BatchCreateAudit: %v", err)
+	}
+
+	tests := []struct {
+		req  msg.ListAuditReq
+		want int
+	}{
+		{msg.ListAuditReq{Action: "like."}, 3},
+		{msg.ListAuditReq{Action: "like.a_"}, 1},
+		{msg.ListAuditReq{Action: "like.a!"}, 1},
+		{msg.ListAuditReq{Target: "50%_"}, 1},
+		{msg.ListAuditReq{Target: "_off"}, 1},
+		{msg.ListAuditReq{Target: "%"}, 1},
+		{msg.ListAuditReq{Target: "a!b"}, 1},
+	}
+
+	for _, tt := range tests {
+		resp, err := ListAudit(context.Background(), tt.req)
+		if err != nil {
+			t.Fatalf("ListAudit(%+v): %v", tt.req, err)
+		}
+
+		if resp.Total != tt.want {
+			t.Errorf("ListAudit(action %q target %q) total = %d, want %d", tt.req.Action, tt.req.Target, resp.Total, tt.want)
+		}
+	}
+}
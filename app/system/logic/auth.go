@@ -0,0 +1,130 @@
+package logic
+
+import (
+	"context"
+	"overlink.top/app/internal/ldap"
+	"overlink.top/app/lib/util"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+	"time"
+)
+
+// Authenticator verifies a username/password pair and returns the matching
+// user row. Every backend must hand back a persisted model.User so folder
+// settings and permissions keep working the same way for all of them.
+type Authenticator interface {
+	Name() string
+	Authenticate(ctx context.Context, username string, password string) (*model.User, error)
+}
+
+type localAuth struct{}
+
+func (self *localAuth) Name() string {
+	return conf.SourceLocal
+}
+
+func (self *localAuth) Authenticate(ctx context.Context, username string, password string) (*model.User, error) {
+	user, err := model.GetUserByName(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.ID == 0 || !user.IsLocal() {
+		return nil, msg.ErrAuthAccount
+	}
+
+	mdStr := util.ToMD5(password + user.Salt)
+	if mdStr != user.EncryptPwd {
+		return nil, msg.ErrAuthAccount
+	}
+
+	return user, nil
+}
+
+type ldapAuth struct {
+	cfg conf.Ldap
+}
+
+func (self *ldapAuth) Name() string {
+	return conf.SourceLdap
+}
+
+func (self *ldapAuth) Authenticate(ctx context.Context, username string, password string) (*model.User, error) {
+	user, err := model.GetUserByName(username)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never let the directory take over an account that lives locally
+	if user.ID > 0 && user.Source != conf.SourceLdap {
+		return nil, msg.ErrAuthAccount
+	}
+
+	entry, err := ldap.Authenticate(self.cfg, username, password)
+	if err != nil {
+		log.Debugf("ldap auth [%s] err: %+v", username, err)
+		return nil, msg.ErrAuthAccount
+	}
+
+	role, ok := self.mapRole(entry)
+	if !ok {
+		return nil, msg.ErrAuthAccount
+	}
+
+	if user.ID == 0 {
+		user = &model.User{
+			Username: username,
+			PwdStamp: time.Now().UnixNano(),
+			Role:     role,
+			Enable:   true,
+			Source:   conf.SourceLdap,
+		}
+		err = model.CreateUser(user)
+		return user, err
+	}
+
+	if user.Role != role {
+		user.Role = role
+		user.PwdStamp = time.Now().UnixNano()
+		err = model.UpdateUser(user)
+	}
+
+	return user, err
+}
+
+// mapRole turns the directory groups into a local role. When viewer groups are
+// configured, members of neither list are refused.
+func (self *ldapAuth) mapRole(entry *ldap.Entry) (int, bool) {
+	if entry.InGroup(self.cfg.AdminGroups) {
+		return conf.SuperAdmin, true
+	}
+
+	if len(self.cfg.ViewerGroups) == 0 || entry.InGroup(self.cfg.ViewerGroups) {
+		return conf.Viewer, true
+	}
+
+	return 0, false
+}
+
+var authenticators []Authenticator
+
+func loadAuthenticator() {
+	authenticators = []Authenticator{&localAuth{}}
+	if conf.AppConf.Ldap.Enable {
+		authenticators = append(authenticators, &ldapAuth{cfg: conf.AppConf.Ldap})
+	}
+}
+
+func authenticate(ctx context.Context, username string, password string) (user *model.User, err error) {
+	err = msg.ErrAuthAccount
+	for _, v := range authenticators {
+		user, err = v.Authenticate(ctx, username, password)
+		if err == nil {
+			return
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,218 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"overlink.top/app/lib/util"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+	"time"
+)
+
+const (
+	BackupVersion = 1
+	BackupMerge   = "merge"
+	BackupReplace = "replace"
+	backupCheck   = "showta-backup"
+)
+
+func ExportBackup(ctx context.Context, req msg.ExportBackupReq) (*msg.Backup, error) {
+	bundle, err := model.ExportBundle()
+	if err != nil {
+		return nil, err
+	}
+
+	data := &msg.Backup{
+		Version:    BackupVersion,
+		AppVersion: conf.AppVersion,
+		CreatedAt:  time.Now(),
+		Folders:    bundle.Folders,
+		Roles:      bundle.Roles,
+		Groups:     bundle.Groups,
+		Members:    bundle.Members,
+		Acls:       bundle.Acls,
+	}
+
+	for _, v := range bundle.Users {
+		data.Users = append(data.Users, msg.BackupUser{User: v, EncryptPwd: v.EncryptPwd, PwdStamp: v.PwdStamp, Salt: v.Salt})
+	}
+
+	for _, v := range bundle.Preferences {
+		data.Preferences = append(data.Preferences, msg.BackupPreference{Preference: v, Term: v.Term})
+	}
+
+	var key []byte
+	if req.Passphrase != "" {
+		data.Salt = util.GenSecureStr(16)
+		key = util.DeriveKey(req.Passphrase, data.Salt)
+		data.Check, err = util.AesEncrypt(key, backupCheck)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, v := range bundle.Storages {
+		item := msg.BackupStorage{Storage: v, Token: v.Token}
+		if key != nil {
+			item.Extra, err = util.AesEncrypt(key, item.Extra)
+			if err != nil {
+				return nil, err
+			}
+
+			item.Token, err = util.AesEncrypt(key, item.Token)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		data.Storages = append(data.Storages, item)
+	}
+
+	Audit(ctx, "backup.export", "", nil, map[string]bool{"encrypted": key != nil})
+	return data, nil
+}
+
+func ImportBackup(ctx context.Context, req msg.ImportBackupReq) error {
+	if req.Mode == "" {
+		req.Mode = BackupMerge
+	}
+
+	if req.Mode != BackupMerge && req.Mode != BackupReplace {
+		return fmt.Errorf("invalid import mode: %s", req.Mode)
+	}
+
+	bundle, err := decodeBackup(req.Backup, req.Passphrase)
+	if err != nil {
+		return err
+	}
+
+	err = validateBundle(bundle, req.Mode == BackupReplace)
+	if err != nil {
+		return err
+	}
+
+	err = model.ImportBundle(bundle, req.Mode == BackupReplace)
+	if err != nil {
+		return err
+	}
+
+	reloadAll()
+	Audit(ctx, "backup.import", req.Mode, nil, map[string]int{
+		"users":    len(bundle.Users),
+		"storages": len(bundle.Storages),
+		"folders":  len(bundle.Folders),
+	})
+	return nil
+}
+
+func decodeBackup(data *msg.Backup, passphrase string) (*model.Bundle, error) {
+	if data.Version < 1 || data.Version > BackupVersion {
+		return nil, fmt.Errorf("unsupported backup version: %d", data.Version)
+	}
+
+	var key []byte
+	if data.Salt != "" {
+		if passphrase == "" {
+			return nil, fmt.Errorf("backup is encrypted, passphrase required")
+		}
+
+		key = util.DeriveKey(passphrase, data.Salt)
+		check, err := util.AesDecrypt(key, data.Check)
+		if err != nil || check != backupCheck {
+			return nil, fmt.Errorf("wrong backup passphrase")
+		}
+	}
+
+	bundle := &model.Bundle{
+		Folders: data.Folders,
+		Roles:   data.Roles,
+		Groups:  data.Groups,
+		Members: data.Members,
+		Acls:    data.Acls,
+	}
+
+	for _, v := range data.Users {
+		user := v.User
+		user.EncryptPwd, user.PwdStamp, user.Salt = v.EncryptPwd, v.PwdStamp, v.Salt
+		bundle.Users = append(bundle.Users, user)
+	}
+
+	for _, v := range data.Preferences {
+		item := v.Preference
+		item.Term = v.Term
+		bundle.Preferences = append(bundle.Preferences, item)
+	}
+
+	for _, v := range data.Storages {
+		item := v.Storage
+		item.Token = v.Token
+		if key != nil {
+			var err error
+			item.Extra, err = util.AesDecrypt(key, item.Extra)
+			if err != nil {
+				return nil, fmt.Errorf("decrypt storage [%s] err: %w", item.MountPath, err)
+			}
+
+			item.Token, err = util.AesDecrypt(key, item.Token)
+			if err != nil {
+				return nil, fmt.Errorf("decrypt storage [%s] err: %w", item.MountPath, err)
+			}
+		}
+
+		bundle.Storages = append(bundle.Storages, item)
+	}
+
+	return bundle, nil
+}
+
+func validateBundle(bundle *model.Bundle, replace bool) error {
+	engines := map[string]bool{}
+	for _, v := range GetAllEngineName() {
+		engines[v] = true
+	}
+
+	mountPaths := map[string]bool{}
+	for i, v := range bundle.Storages {
+		if !engines[v.Engine] {
+			return fmt.Errorf("storage [%s] uses unknown engine: %s", v.MountPath, v.Engine)
+		}
+
+		v.MountPath = util.StandardPath(v.MountPath)
+		if mountPaths[v.MountPath] {
+			return fmt.Errorf("duplicate mount path: %s", v.MountPath)
+		}
+
+		mountPaths[v.MountPath] = true
+		bundle.Storages[i].MountPath = v.MountPath
+	}
+
+	usernames := map[string]bool{}
+	hasSuper := false
+	for _, v := range bundle.Users {
+		if v.Username == "" || usernames[v.Username] {
+			return fmt.Errorf("empty or duplicate username: %s", v.Username)
+		}
+
+		usernames[v.Username] = true
+		if v.IsSuper() && v.IsLocal() && v.Enable && v.EncryptPwd != "" {
+			hasSuper = true
+		}
+	}
+
+	// Replacing without a usable admin would lock everyone out
+	if replace && !hasSuper {
+		return fmt.Errorf("backup has no enabled local super user")
+	}
+
+	return nil
+}
+
+func reloadAll() {
+	reloadAllStorage()
+	loadAllFolderPwd()
+	checkDefaultPreference()
+	loadPreviewConf(true)
+	loadAllAcl()
+	loadAllBasePath()
+}
@@ -0,0 +1,63 @@
+package logic
+
+import (
+	"overlink.top/app/system/model"
+	"testing"
+	"time"
+)
+
+func TestImportReplaceKeepsSnapshots(t *testing.T) {
+	ids := map[string]uint{}
+	for _, v := range []string{"/kept", "/dropped"} {
+		data := model.Storage{MountPath: v, Engine: "native"}
+		if err := model.CreateStorage(&data); err != nil {
+			t.Fatalf("CreateStorage: %v", err)
+		}
+
+		ids[v] = data.ID
+		model.SaveSnapshot(&model.Snapshot{StorageID: data.ID, Version: 1, TakenAt: time.Now()})
+		model.BatchCreateSnapshotEntry([]model.SnapshotEntry{{StorageID: data.ID, Version: 1, Parent: "/", Name: "a"}})
+	}
+
+	// Ids in a bundle are the ones of the exporting instance
+	bundle := &model.Bundle{
+		Users: []model.User{{ID: 50, Username: "admin", Enable: true}},
+		Storages: []model.Storage{
+			{ID: 70, MountPath: "/new", Engine: "native"},
+			{ID: 71, MountPath: "/kept", Engine: "native", Remark: "imported"},
+		},
+	}
+
+	if err := model.ImportBundle(bundle, true); err != nil {
+		t.Fatalf("ImportBundle: %v", err)
+	}
+
+	list, err := model.GetAllStorage()
+	if err != nil {
+		t.Fatalf("GetAllStorage: %v", err)
+	}
+
+	got := map[string]model.Storage{}
+	for _, v := range list {
+		got[v.MountPath] = v
+	}
+
+	if len(got) != 2 || got["/kept"].ID != ids["/kept"] || got["/kept"].Remark != "imported" {
+		t.Fatalf("storages after replace = %+v, want /kept with id %d and /new", got, ids["/kept"])
+	}
+
+	if snap, _ := model.GetSnapshot(ids["/kept"]); snap.Version != 1 {
+		t.Error("snapshot of the storage imported again was lost")
+	}
+
+	newId := got["/new"].ID
+	for _, id := range []uint{ids["/dropped"], newId} {
+		if snap, _ := model.GetSnapshot(id); snap.Version != 0 {
+			t.Errorf("storage %d carries a snapshot it never took", id)
+		}
+
+		if entries, _ := model.GetSnapshotEntries(id, 1, "/"); len(entries) != 0 {
+			t.Errorf("storage %d carries %d orphaned snapshot entries", id, len(entries))
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"overlink.top/app/internal/cache"
+	"overlink.top/app/lib/util"
+	"overlink.top/app/storage"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/msg"
+	"time"
+)
+
+const defaultCacheTTL = 5 * time.Minute
+
+func initCache() {
+	err := cache.Init(conf.AppConf.Cache)
+	if err != nil {
+		log.StdErrorf("init %s cache err, fall back to memory: %+v", conf.AppConf.Cache.Type, err)
+	}
+
+	storage.OnLink(streamLink)
+}
+
+// cacheTTL maps a configured number of seconds to a duration, zero keeps the
+// default and a negative value turns caching off.
+func cacheTTL(seconds int) time.Duration {
+	if seconds == 0 {
+		return defaultCacheTTL
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// useCache combines the engine capability with the mount cache policy.
+func useCache(store storage.Storage) bool {
+	return store.AllowCache() && !store.GetData().CacheDisabled
+}
+
+func listTTL(store storage.Storage) time.Duration {
+	if ttl := store.GetData().ListTTL; ttl != 0 {
+		return cacheTTL(ttl)
+	}
+
+	return cacheTTL(conf.AppConf.Cache.ListTTL)
+}
+
+// linkTTL never keeps a link longer than the engine says it is valid.
+func linkTTL(store storage.Storage, linkInfo *msg.LinkInfo) time.Duration {
+	seconds := store.GetData().LinkTTL
+	if seconds == 0 {
+		seconds = conf.AppConf.Cache.LinkTTL
+	}
+
+	ttl := cacheTTL(seconds)
+	if linkInfo.Expire > 0 && linkInfo.Expire < ttl {
+		return linkInfo.Expire
+	}
+
+	return ttl
+}
+
+// RefreshPath drops the cached listing of a directory the user is about to
+// list again, it needs the right to manage the mount.
+func RefreshPath(ctx context.Context, rpath string) error {
+	rpath = RealPath(ctx, rpath)
+	store := findStorage(rpath)
+	if store == nil {
+		return nil
+	}
+
+	user := Identity(ctx)
+	if user == nil || !HasPerm(user, conf.PermStorage) || !CanManageMount(ctx, store.GetData().MountPath) {
+		return msg.ErrNoPermission
+	}
+
+	cache.InvalidatePath(rpath)
+	return nil
+}
+
+// RefreshTree purges everything cached below rpath on every cache layer.
+func RefreshTree(ctx context.Context, rpath string) error {
+	rpath = util.StandardPath(rpath)
+	if rpath == "/" {
+		user := Identity(ctx)
+		if user == nil || !user.IsSuper() {
+			return msg.ErrNoPermission
+		}
+	} else {
+		store := findStorage(rpath)
+		if store == nil {
+			return fmt.Errorf("no storage for path: %s", rpath)
+		}
+
+		err := checkManageMount(ctx, store.GetData().MountPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	cache.InvalidateTree(rpath)
+	Audit(ctx, "storage.refresh", rpath, nil, nil)
+	return nil
+}
+
+func toFinfoList(list []*msg.FileInfo) []msg.Finfo {
+	res := make([]msg.Finfo, 0, len(list))
+	for _, v := range list {
+		res = append(res, v)
+	}
+
+	return res
+}
+
+// streamLink gives the stream layer the links /fd/ and thumbnails use, one
+// the storage no longer honours is dropped before resolving it again.
+func streamLink(ctx context.Context, store storage.Storage, info msg.Finfo, refresh bool) (*msg.LinkInfo, error) {
+	if !useCache(store) {
+		return store.Link(ctx, info)
+	}
+
+	if refresh {
+		cache.Delete(cache.Link, info.GetPath())
+	}
+
+	return cacheFileLink(ctx, info, store)
+}
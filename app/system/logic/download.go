@@ -0,0 +1,69 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"overlink.top/app/internal/chunk"
+	"overlink.top/app/storage"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/msg"
+)
+
+func initChunkCache() {
+	err := chunk.Init(conf.AppConf.ChunkCache)
+	if err != nil {
+		log.StdErrorf("init chunk cache err: %+v", err)
+	}
+}
+
+func checkDownloadMode(mode string) error {
+	switch mode {
+	case "", conf.DownloadProxy, conf.DownloadRedirect:
+		return nil
+	case conf.DownloadCache:
+		if !chunk.Enabled() {
+			return chunk.ErrDisabled
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unknown download mode: %s", mode)
+}
+
+// downloadMode is how rpath leaves store, local storages always serve files
+// themselves. Files are proxied while the chunk cache failed to start.
+func downloadMode(store storage.Storage) string {
+	mode := store.GetData().DownloadMode
+	if mode == "" || store.IsDirect() || (mode == conf.DownloadCache && !chunk.Enabled()) {
+		return conf.DownloadProxy
+	}
+
+	return mode
+}
+
+// cachedReaders reads info through the local chunk cache, only the chunks
+// not on disk yet are fetched from store.
+func cachedReaders(store storage.Storage, rpath string, info msg.Finfo) (readRangeFunc, readAllFunc) {
+	key := info.GetFileId()
+	if key == "" {
+		key = rpath
+	}
+
+	f := &chunk.File{
+		Key:      fmt.Sprintf("%d:%s", store.GetData().ID, key),
+		Size:     info.GetSize(),
+		Modified: info.ModTime(),
+		Range: func(ctx context.Context, offset int64, length int64, w io.Writer) error {
+			return store.StreamRange(ctx, rpath, offset, length, w)
+		},
+	}
+
+	return func(ctx context.Context, offset int64, length int64, w io.Writer) error {
+			return chunk.ReadRange(ctx, f, offset, length, w)
+		}, func(ctx context.Context, w io.Writer) error {
+			return chunk.ReadRange(ctx, f, 0, f.Size, w)
+		}
+}
@@ -5,13 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 	"mime"
 	"net/http"
 	"net/url"
 	"os"
-	"overlink.top/app/internal/memcache"
+	"overlink.top/app/internal/archive"
+	"overlink.top/app/internal/cache"
+	"overlink.top/app/internal/metrics"
 	"overlink.top/app/internal/sign"
+	"overlink.top/app/internal/tracing"
 	"overlink.top/app/lib/util"
 	"overlink.top/app/storage"
 	"overlink.top/app/system/conf"
@@ -24,13 +28,39 @@ import (
 	"time"
 )
 
+// ListFile lists rpath as seen by the user bound to ctx, paths are rewritten
+// for users jailed in a home directory.
 func ListFile(ctx context.Context, rpath string) (list []msg.Finfo, err error) {
+	list, _, err = listFileAt(ctx, rpath)
+	return
+}
+
+// listFileAt is ListFile also returning when the snapshot served in place of
+// a failing storage was taken, zero for a live listing.
+func listFileAt(ctx context.Context, rpath string) (list []msg.Finfo, snapshotAt time.Time, err error) {
+	ctx, span := tracing.Start(ctx, "logic.ListFile", attribute.String("path", rpath))
+	defer func() { tracing.End(span, err) }()
+
+	list, snapshotAt, err = listFile(ctx, RealPath(ctx, rpath))
+	if err != nil {
+		return
+	}
+
+	return viewFileList(ctx, list), snapshotAt, nil
+}
+
+func listFile(ctx context.Context, rpath string) (list []msg.Finfo, snapshotAt time.Time, err error) {
 	rpath = util.StandardPath(rpath)
 	//Virtual mounting directory
 	if rpath == "/" {
+		user := Identity(ctx)
 		storageMap.Range(func(key, value interface{}) bool {
 			v := value.(storage.Storage)
 			mountPath := v.GetData().MountPath
+			if user != nil && !user.IsSuper() && !aclAllow(user, mountPath, conf.ActionRead) {
+				return true
+			}
+
 			list = append(list, &msg.FileInfo{
 				Path:     mountPath,
 				Name:     util.SimplePath(mountPath),
@@ -42,23 +72,34 @@ func ListFile(ctx context.Context, rpath string) (list []msg.Finfo, err error) {
 			return true
 		})
 	} else {
-		var store storage.Storage
-		storageMap.Range(func(key, value interface{}) bool {
-			v := value.(storage.Storage)
-			mountPath := v.GetData().MountPath
-			if strings.Contains(rpath, mountPath) {
-				store = v
-				return false
-			}
-
-			return true
-		})
+		if !CheckAcl(ctx, rpath, conf.ActionRead) {
+			err = msg.ErrNoPermission
+			return
+		}
 
+		store := findStorage(rpath)
 		if store != nil {
-			if store.AllowCache() {
-				list, err = cacheListFile(rpath, store)
+			if apath, inner, ok := archive.Split(rpath); ok {
+				list, err = listArchive(ctx, apath, inner)
+				if err != errNotArchive {
+					return
+				}
+
+				err = nil
+			}
+
+			if useCache(store) {
+				list, err = cacheListFile(ctx, rpath, store)
 			} else {
-				list, err = store.List(&msg.FileInfo{Path: rpath})
+				list, err = store.List(ctx, &msg.FileInfo{Path: rpath})
+			}
+
+			// Rate limited or down, an older view beats an error
+			if err != nil && storageUnavailable(store, err) {
+				if snapList, takenAt, ok := snapshotListFile(rpath, store); ok {
+					log.Ctx(ctx).Warnf("list %s err, served from snapshot of %s: %+v", rpath, takenAt.Format(time.DateTime), err)
+					list, snapshotAt, err = snapList, takenAt, nil
+				}
 			}
 		}
 	}
@@ -67,12 +108,14 @@ func ListFile(ctx context.Context, rpath string) (list []msg.Finfo, err error) {
 }
 
 func ViewListFile(ctx context.Context, rpath string) (resp msg.ListFileResp, err error) {
-	list, err := ListFile(ctx, rpath)
+	list, snapshotAt, err := listFileAt(ctx, rpath)
 	if err != nil {
 		return
 	}
 
-	setting, err := model.GetFolderSettingByFolder(rpath)
+	_, span := tracing.Start(ctx, "model.GetFolderSettingByFolder")
+	setting, err := model.GetFolderSettingByFolder(RealPath(ctx, rpath))
+	tracing.End(span, err)
 	if err != nil {
 		return
 	}
@@ -88,6 +131,11 @@ func ViewListFile(ctx context.Context, rpath string) (resp msg.ListFileResp, err
 		})
 	}
 	resp.List = dataList
+	if !snapshotAt.IsZero() {
+		resp.SnapshotAt = &snapshotAt
+		resp.SnapshotAge = int64(time.Since(snapshotAt).Seconds())
+	}
+
 	if setting.ID > 0 {
 		resp.Topmd = setting.Topmd
 		resp.Readme = setting.Readme
@@ -108,13 +156,18 @@ func GetStorageFile(c *gin.Context, rpath string) (resp msg.GetFileResp, err err
 	var ptype int
 	if !isDir {
 		ptype = getPreviewType(name)
-		if rawUrl == "" {
+		realPath := RealPath(c, rpath)
+		if !CheckAcl(c, realPath, conf.ActionShare) {
+			rawUrl = ""
+		} else if rawUrl == "" {
+			// Jailed users always get signed links, /fd/ has no other way to
+			// know which home directory an anonymous request belongs to.
 			var param string
-			if conf.GlobalSign {
-				param = "?sig=" + sign.Gen(rpath, "")
+			if conf.GlobalSign || realPath != util.StandardPath(rpath) {
+				param = "?sig=" + sign.Gen(realPath, "")
 			}
 
-			rawUrl = fmt.Sprintf("%s/fd%s%s", getHost(c.Request), rpath, param)
+			rawUrl = fmt.Sprintf("%s/fd%s%s", getHost(c.Request), realPath, param)
 		}
 	}
 
@@ -133,31 +186,48 @@ func GetStorageFile(c *gin.Context, rpath string) (resp msg.GetFileResp, err err
 	return
 }
 
+// ProxyFile serves rpath as seen by the identity bound to the request, if any.
 func ProxyFile(r *http.Request, w http.ResponseWriter, rpath string) {
-	rpath = util.StandardPath(rpath)
-	var store storage.Storage
-	storageMap.Range(func(key, value interface{}) bool {
-		v := value.(storage.Storage)
-		mountPath := v.GetData().MountPath
-		if strings.Contains(rpath, mountPath) {
-			store = v
-			return false
-		}
-
-		return true
-	})
+	ctx, span := tracing.Start(r.Context(), "logic.ProxyFile", attribute.String("path", rpath))
+	defer span.End()
 
+	r = r.WithContext(ctx)
+	rpath = RealPath(r.Context(), rpath)
+	store := findStorage(rpath)
 	if store == nil {
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprint(w, "no such file:", rpath)
 		return
 	}
 
+	if !CheckAcl(r.Context(), rpath, conf.ActionRead) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "no permission:", rpath)
+		return
+	}
+
+	AuditDownload(r, rpath)
+	cw := &metrics.CountWriter{ResponseWriter: w}
+	defer func() {
+		metrics.StreamBytes.WithLabelValues(store.GetData().MountPath).Add(float64(cw.Count()))
+	}()
+	w = cw
+
+	if apath, inner, ok := archive.Split(rpath); ok && inner != "" && proxyArchiveFile(r, w, apath, inner) {
+		return
+	}
+
+	mode := downloadMode(store)
+	if mode == conf.DownloadRedirect {
+		proxyFileOriginal(r, w, rpath, store)
+		return
+	}
+
 	// Try to use streaming if available
 	if streamer, ok := store.(interface {
 		StreamFile(ctx context.Context, path string, writer io.Writer) error
 	}); ok {
-		info, err := GetFile(r.Context(), rpath)
+		info, err := getFile(r.Context(), rpath)
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
 			fmt.Fprint(w, "file not found:", err)
@@ -170,52 +240,30 @@ func ProxyFile(r *http.Request, w http.ResponseWriter, rpath string) {
 			return
 		}
 
-		fileInfo := &fileInfoAdapter{info}
-		totalSize := info.GetSize()
-		rangeHeader := r.Header.Get("Range")
-
-		if rangeHeader != "" {
-			if ranger, ok := store.(interface {
-				StreamRange(ctx context.Context, path string, offset, length int64, writer io.Writer) error
-			}); ok {
-				byteRange, err := parseRangeHeader(rangeHeader, totalSize)
-				if err != nil {
-					w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", totalSize))
-					w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
-					return
-				}
-
-				setAttach(w, fileInfo)
-				length := byteRange.Length()
-				w.Header().Set("Accept-Ranges", "bytes")
-				w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
-				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", byteRange.Start, byteRange.End, totalSize))
-				w.WriteHeader(http.StatusPartialContent)
-
-				err = ranger.StreamRange(r.Context(), rpath, byteRange.Start, length, w)
-				if err != nil {
-					if isClientDisconnectError(err) {
-						log.Debugf("client disconnected during range streaming: %s", rpath)
-					} else {
-						log.Errorf("range streaming file error: %v", err)
-					}
-				}
-				return
+		var readRange readRangeFunc
+		if ranger, ok := store.(interface {
+			StreamRange(ctx context.Context, path string, offset, length int64, writer io.Writer) error
+		}); ok {
+			readRange = func(ctx context.Context, offset int64, length int64, w io.Writer) error {
+				return ranger.StreamRange(ctx, rpath, offset, length, w)
 			}
 		}
 
-		// Normal full-file streaming path
-		setAttach(w, fileInfo)
-		w.Header().Set("Accept-Ranges", "bytes")
+		readAll := func(ctx context.Context, w io.Writer) error {
+			return streamer.StreamFile(ctx, rpath, w)
+		}
 
-		err = streamer.StreamFile(r.Context(), rpath, w)
+		if mode == conf.DownloadCache {
+			readRange, readAll = cachedReaders(store, rpath, info)
+		}
+
+		err = serveContent(w, r, info, readRange, readAll)
 		if err != nil {
 			if isClientDisconnectError(err) {
-				log.Debugf("client disconnected during streaming: %s", rpath)
+				log.Ctx(r.Context()).Debugf("client disconnected during streaming: %s", rpath)
 			} else {
-				log.Errorf("streaming file error: %v", err)
+				log.Ctx(r.Context()).Errorf("streaming file error: %v", err)
 			}
-			return
 		}
 		return
 	}
@@ -228,17 +276,17 @@ func ProxyFile(r *http.Request, w http.ResponseWriter, rpath string) {
 func proxyFileOriginal(r *http.Request, w http.ResponseWriter, rpath string, store storage.Storage) {
 	var linkInfo *msg.LinkInfo
 	var err error
-	if store.AllowCache() {
-		item, err := findCacheFile(rpath, store)
+	if useCache(store) {
+		item, err := findCacheFile(r.Context(), rpath, store)
 		if err != nil || item.IsDir() {
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprint(w, "link file error:", err)
 			return
 		}
 
-		linkInfo, err = cacheFileLink(item, store)
+		linkInfo, err = cacheFileLink(r.Context(), item, store)
 	} else {
-		linkInfo, err = store.Link(&msg.FileInfo{Path: rpath})
+		linkInfo, err = store.Link(r.Context(), &msg.FileInfo{Path: rpath})
 	}
 
 	if err != nil {
@@ -274,10 +322,15 @@ func proxyFileOriginal(r *http.Request, w http.ResponseWriter, rpath string, sto
 		return
 	}
 
-	setAttach(w, fi)
-
-	// Use buffered copy for better memory usage with chunked transfer encoding
-	bufferedCopyWithChunkedEncoding(w, f, fi.Size())
+	info := &msg.FileInfo{Path: rpath, Name: fi.Name(), Size: fi.Size(), Modified: fi.ModTime()}
+	err = serveContent(w, r, info, func(ctx context.Context, offset int64, length int64, w io.Writer) error {
+		return bufferedCopy(w, io.NewSectionReader(f, offset, length))
+	}, func(ctx context.Context, w io.Writer) error {
+		return bufferedCopy(w, f)
+	})
+	if err != nil && !isClientDisconnectError(err) {
+		log.Ctx(r.Context()).Errorf("serving file error: %v", err)
+	}
 }
 
 // bufferedCopy copies data from reader to writer using a fixed-size buffer
@@ -293,22 +346,6 @@ func bufferedCopy(w io.Writer, r io.Reader) error {
 	return err
 }
 
-// bufferedCopyWithChunkedEncoding copies data from reader to writer using a fixed-size buffer
-// and supports chunked transfer encoding for large files
-func bufferedCopyWithChunkedEncoding(w http.ResponseWriter, r io.Reader, fileSize int64) error {
-	// For large files, we rely on Go's automatic chunked transfer encoding
-	// when Content-Length is not explicitly set or when using HTTP/1.1+
-
-	// Use configured buffer size or default to 64KB
-	bufferSize := 64 * 1024
-	if conf.AppConf.WebDAV.BufferSize > 0 {
-		bufferSize = conf.AppConf.WebDAV.BufferSize
-	}
-	buf := make([]byte, bufferSize)
-	_, err := io.CopyBuffer(w, r, buf)
-	return err
-}
-
 // fileInfoAdapter adapts msg.Finfo to os.FileInfo interface
 type fileInfoAdapter struct {
 	info msg.Finfo
@@ -333,7 +370,20 @@ func setAttach(w http.ResponseWriter, fi os.FileInfo) {
 	w.Header().Set("Content-Type", mimeType)
 }
 
+// GetFile stats rpath as seen by the user bound to ctx.
 func GetFile(ctx context.Context, rpath string) (info msg.Finfo, err error) {
+	ctx, span := tracing.Start(ctx, "logic.GetFile", attribute.String("path", rpath))
+	defer func() { tracing.End(span, err) }()
+
+	info, err = getFile(ctx, RealPath(ctx, rpath))
+	if err != nil {
+		return
+	}
+
+	return viewFileInfo(ctx, info), nil
+}
+
+func getFile(ctx context.Context, rpath string) (info msg.Finfo, err error) {
 	rpath = util.StandardPath(rpath)
 	//Virtual mounting directory
 	if rpath == "/" {
@@ -344,31 +394,34 @@ func GetFile(ctx context.Context, rpath string) (info msg.Finfo, err error) {
 		return
 	}
 
-	var store storage.Storage
-	storageMap.Range(func(key, value interface{}) bool {
-		v := value.(storage.Storage)
-		mountPath := v.GetData().MountPath
-		if strings.Contains(rpath, mountPath) {
-			store = v
-			return false
-		}
-
-		return true
-	})
-
+	store := findStorage(rpath)
 	if store == nil {
 		err = errors.New("dir not exist")
 		return
 	}
 
+	if !CheckAcl(ctx, rpath, conf.ActionRead) {
+		err = msg.ErrNoPermission
+		return
+	}
+
+	if apath, inner, ok := archive.Split(rpath); ok && inner != "" {
+		info, err = getArchiveFile(ctx, apath, inner)
+		if err != errNotArchive {
+			return
+		}
+
+		err = nil
+	}
+
 	getter, ok := store.(storage.Getter)
 	if ok {
-		info, err = getter.Get(rpath)
+		info, err = getter.Get(ctx, rpath)
 		return
 	}
 
-	if store.AllowCache() {
-		item, err := findCacheFile(rpath, store)
+	if useCache(store) {
+		item, err := findCacheFile(ctx, rpath, store)
 		if err != nil {
 			return nil, err
 		}
@@ -377,7 +430,7 @@ func GetFile(ctx context.Context, rpath string) (info msg.Finfo, err error) {
 			return item, nil
 		}
 
-		linkInfo, err := cacheFileLink(item, store)
+		linkInfo, err := cacheFileLink(ctx, item, store)
 		if err != nil {
 			return nil, err
 		}
@@ -414,20 +467,24 @@ func Subdir(ctx context.Context, rpath string) (list []msg.Finfo, err error) {
 	return
 }
 
-func cacheListFile(rpath string, store storage.Storage) (list []msg.Finfo, err error) {
-	data, found := memcache.Get(memcache.List, rpath)
-	if found {
+func cacheListFile(ctx context.Context, rpath string, store storage.Storage) (list []msg.Finfo, err error) {
+	ctx, span := tracing.Start(ctx, "logic.cacheListFile", attribute.String("path", rpath))
+	defer func() { tracing.End(span, err) }()
+
+	var cached []*msg.FileInfo
+	hit := cache.Get(cache.List, rpath, &cached)
+	span.SetAttributes(attribute.Bool("cache.hit", hit))
+	if hit {
 		log.Debugf("[CACHE] list path: %+s", rpath)
-		list = data.([]msg.Finfo)
+		list = toFinfoList(cached)
 		return
 	}
 
 	info := msg.FileInfo{Path: rpath}
 	parentPath := util.GetParentDir(rpath)
 	if parentPath != "/" {
-		pdata, pfound := memcache.Get(memcache.List, parentPath)
-		if pfound {
-			plist := pdata.([]msg.Finfo)
+		var plist []*msg.FileInfo
+		if cache.Get(cache.List, parentPath, &plist) {
 			for _, item := range plist {
 				if item.GetPath() == rpath {
 					info.FileId = item.GetFileId()
@@ -437,23 +494,23 @@ func cacheListFile(rpath string, store storage.Storage) (list []msg.Finfo, err e
 		}
 	}
 
-	list, err = store.List(&info)
+	list, err = store.List(ctx, &info)
 	if err != nil {
 		return
 	}
 
-	memcache.Set(memcache.List, rpath, list)
+	cache.Set(cache.List, rpath, list, listTTL(store))
 	return
 }
 
-func findCacheFile(rpath string, store storage.Storage) (info msg.Finfo, err error) {
+func findCacheFile(ctx context.Context, rpath string, store storage.Storage) (info msg.Finfo, err error) {
 	dpath, fname := util.SplitPath(rpath)
 	if dpath == "/" {
 		err = errors.New("dir err")
 		return
 	}
 
-	list, err := cacheListFile(dpath, store)
+	list, err := cacheListFile(ctx, dpath, store)
 	if err != nil {
 		return nil, err
 	}
@@ -467,21 +524,24 @@ func findCacheFile(rpath string, store storage.Storage) (info msg.Finfo, err err
 	return
 }
 
-func cacheFileLink(info msg.Finfo, store storage.Storage) (linkInfo *msg.LinkInfo, err error) {
+func cacheFileLink(ctx context.Context, info msg.Finfo, store storage.Storage) (linkInfo *msg.LinkInfo, err error) {
 	rpath := info.GetPath()
-	data, found := memcache.Get(memcache.Link, rpath)
-	if found {
+	ctx, span := tracing.Start(ctx, "logic.cacheFileLink", attribute.String("path", rpath))
+	defer func() { tracing.End(span, err) }()
+
+	hit := cache.Get(cache.Link, rpath, &linkInfo)
+	span.SetAttributes(attribute.Bool("cache.hit", hit))
+	if hit {
 		log.Debugf("[CACHE] link path: %+s", rpath)
-		linkInfo = data.(*msg.LinkInfo)
 		return
 	}
 
-	linkInfo, err = store.Link(info)
+	linkInfo, err = store.Link(ctx, info)
 	if err != nil {
 		return nil, err
 	}
 
-	memcache.Expire(memcache.Link, rpath, linkInfo, linkInfo.Expire)
+	cache.Set(cache.Link, rpath, linkInfo, linkTTL(store, linkInfo))
 	return
 }
 
@@ -520,27 +580,52 @@ func (br byteRange) Length() int64 {
 	return br.End - br.Start + 1
 }
 
-func parseRangeHeader(header string, size int64) (byteRange, error) {
+// parseRangeHeader returns the satisfiable ranges of header in order, an
+// error means none is and the answer is 416.
+func parseRangeHeader(header string, size int64) ([]byteRange, error) {
 	const prefix = "bytes="
 	if !strings.HasPrefix(header, prefix) {
-		return byteRange{}, errors.New("invalid range unit")
+		return nil, errors.New("invalid range unit")
 	}
 
-	spec := strings.TrimSpace(header[len(prefix):])
-	if spec == "" {
-		return byteRange{}, errors.New("empty range")
+	var ranges []byteRange
+	for _, spec := range strings.Split(header[len(prefix):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		ra, err := parseRangeSpec(spec, size)
+		if err == errRangeBeyondSize {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		ranges = append(ranges, ra)
 	}
 
-	if idx := strings.Index(spec, ","); idx >= 0 {
-		spec = spec[:idx]
+	if len(ranges) == 0 {
+		return nil, errors.New("no satisfiable range")
 	}
 
+	return ranges, nil
+}
+
+var errRangeBeyondSize = errors.New("range start beyond size")
+
+func parseRangeSpec(spec string, size int64) (byteRange, error) {
 	var start, end int64
 	if strings.HasPrefix(spec, "-") {
 		length, err := strconv.ParseInt(strings.TrimPrefix(spec, "-"), 10, 64)
-		if err != nil || length <= 0 {
+		if err != nil || length < 0 {
 			return byteRange{}, errors.New("invalid suffix range")
 		}
+		if length == 0 || size == 0 {
+			return byteRange{}, errRangeBeyondSize
+		}
 		if length > size {
 			length = size
 		}
@@ -553,7 +638,7 @@ func parseRangeHeader(header string, size int64) (byteRange, error) {
 			return byteRange{}, errors.New("invalid range start")
 		}
 		if parsed >= size {
-			return byteRange{}, errors.New("range start beyond size")
+			return byteRange{}, errRangeBeyondSize
 		}
 		start = parsed
 		end = size - 1
@@ -572,7 +657,7 @@ func parseRangeHeader(header string, size int64) (byteRange, error) {
 			return byteRange{}, errors.New("invalid range end")
 		}
 		if start >= size {
-			return byteRange{}, errors.New("range start beyond size")
+			return byteRange{}, errRangeBeyondSize
 		}
 		if end >= size {
 			end = size - 1
@@ -4,7 +4,6 @@ import (
 	"context"
 	"github.com/gin-gonic/gin"
 	"path"
-	"overlink.top/app/lib/util"
 	"overlink.top/app/system/log"
 	"overlink.top/app/system/model"
 	"overlink.top/app/system/msg"
@@ -22,6 +21,11 @@ func loadAllFolderPwd() {
 		return
 	}
 
+	pwdSettingMap.Range(func(key, value interface{}) bool {
+		pwdSettingMap.Delete(key)
+		return true
+	})
+
 	for _, data := range dataList {
 		if data.Password != "" {
 			pwdSettingMap.Store(data.Folder, data)
@@ -29,12 +33,48 @@ func loadAllFolderPwd() {
 	}
 }
 
+func ListFolderSetting(ctx context.Context) ([]model.FolderSetting, error) {
+	dataList, err := model.GetAllFolderSetting()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]model.FolderSetting, 0, len(dataList))
+	for _, v := range dataList {
+		if CanManageMount(ctx, v.Folder) {
+			list = append(list, v)
+		}
+	}
+
+	return list, nil
+}
+
+func GetFolderSetting(ctx context.Context, id uint) (*model.FolderSetting, error) {
+	data, err := model.GetFolderSetting(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkManageMount(ctx, data.Folder)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
 func AddFolderSetting(ctx context.Context, data model.FolderSetting) error {
-	err := model.CreateFolderSetting(&data)
+	err := checkManageMount(ctx, data.Folder)
+	if err != nil {
+		return err
+	}
+
+	err = model.CreateFolderSetting(&data)
 	if err != nil {
 		return err
 	}
 
+	Audit(ctx, "folder.add", data.Folder, nil, data)
 	if data.Password != "" {
 		pwdSettingMap.Store(data.Folder, data)
 	}
@@ -43,7 +83,12 @@ func AddFolderSetting(ctx context.Context, data model.FolderSetting) error {
 }
 
 func UpdateFolderSetting(ctx context.Context, data model.FolderSetting) error {
-	_, err := model.GetFolderSetting(data.ID)
+	oldData, err := GetFolderSetting(ctx, data.ID)
+	if err != nil {
+		return err
+	}
+
+	err = checkManageMount(ctx, data.Folder)
 	if err != nil {
 		return err
 	}
@@ -53,6 +98,7 @@ func UpdateFolderSetting(ctx context.Context, data model.FolderSetting) error {
 		return err
 	}
 
+	Audit(ctx, "folder.update", data.Folder, oldData, data)
 	if data.Password != "" {
 		pwdSettingMap.Store(data.Folder, data)
 	}
@@ -61,7 +107,7 @@ func UpdateFolderSetting(ctx context.Context, data model.FolderSetting) error {
 }
 
 func DeleteFolderSetting(ctx context.Context, id uint) error {
-	data, err := model.GetFolderSetting(id)
+	data, err := GetFolderSetting(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -72,7 +118,7 @@ func DeleteFolderSetting(ctx context.Context, id uint) error {
 	}
 
 	pwdSettingMap.Delete(data.Folder)
-
+	Audit(ctx, "folder.delete", data.Folder, data, nil)
 	return nil
 }
 
@@ -82,7 +128,7 @@ func IsFolderForbidden(c *gin.Context, rpath string, password string) (res bool,
 		return
 	}
 
-	rpath = util.StandardPath(rpath)
+	rpath = RealPath(c, rpath)
 	setting := findMatchSetting(rpath)
 	if setting.Folder == "" || (setting.Folder != rpath && !setting.ApplySub) {
 		return
@@ -0,0 +1,115 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"overlink.top/app/internal/hls"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+	"path"
+)
+
+func initHls() {
+	err := hls.Init(conf.AppConf.Hls)
+	if err == hls.ErrNoFfmpeg {
+		log.StdInfof("ffmpeg not found, HLS transcoding is off")
+		return
+	}
+
+	if err != nil {
+		log.StdErrorf("init hls err: %+v", err)
+	}
+}
+
+// Hls serves the HLS playlists, segments and subtitle tracks of the video
+// rpath, the query f picks which one.
+func Hls(r *http.Request, w http.ResponseWriter, rpath string) {
+	ctx := r.Context()
+	rpath = RealPath(ctx, rpath)
+	store := findStorage(rpath)
+	if store == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "no such file:", rpath)
+		return
+	}
+
+	if !CheckAcl(ctx, rpath, conf.ActionRead) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "no permission:", rpath)
+		return
+	}
+
+	info, err := getFile(ctx, rpath)
+	if err != nil || info.IsDir() {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "file not found:", rpath)
+		return
+	}
+
+	if getPreviewType(info.GetName()) != conf.Video {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		fmt.Fprint(w, "not a video:", rpath)
+		return
+	}
+
+	f := r.URL.Query().Get("f")
+	if f == "" || f == "master.m3u8" {
+		AuditDownload(r, rpath)
+	}
+
+	src := &hls.Source{
+		Key:      rpath,
+		Name:     info.GetName(),
+		Size:     info.GetSize(),
+		Modified: info.ModTime(),
+		Range: func(ctx context.Context, offset int64, length int64, w io.Writer) error {
+			return store.StreamRange(ctx, rpath, offset, length, w)
+		},
+	}
+
+	query := ""
+	if sig := r.URL.Query().Get("sig"); sig != "" {
+		query = "&sig=" + url.QueryEscape(sig)
+	}
+
+	hls.Serve(w, r, src, hlsSubtitles(ctx, rpath, info.GetName()), f, query)
+}
+
+// hlsSubtitles finds the subtitle files next to a video, the ones the user
+// can't read are left out.
+func hlsSubtitles(ctx context.Context, rpath string, name string) []hls.Subtitle {
+	dir := path.Dir(rpath)
+	list, _, err := listFile(ctx, dir)
+	if err != nil {
+		return nil
+	}
+
+	store := findStorage(rpath)
+	var subs []hls.Subtitle
+	for _, v := range list {
+		label, ok := hls.MatchSubtitle(name, v.GetName())
+		if v.IsDir() || !ok {
+			continue
+		}
+
+		subPath := path.Join(dir, v.GetName())
+		if !CheckAcl(ctx, subPath, conf.ActionRead) {
+			continue
+		}
+
+		subs = append(subs, hls.Subtitle{
+			Name:     v.GetName(),
+			Label:    label,
+			Modified: v.ModTime(),
+			Read: func(w io.Writer) error {
+				return store.StreamFile(ctx, subPath, w)
+			},
+		})
+	}
+
+	hls.SortSubtitle(subs)
+	return subs
+}
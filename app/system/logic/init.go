@@ -1,9 +1,31 @@
 package logic
 
+import "context"
+
 func Init() {
 
+	initTracing()
 	checkDefaultUser()
+	initCache()
+	initThumb()
+	initHls()
+	initChunkCache()
+	loadAuthenticator()
 	checkDefaultPreference()
+	rotateStorageSecret()
 	loadAllStorage()
 	loadAllFolderPwd()
+	loadAllAcl()
+	loadAllBasePath()
+	startAudit()
+	startSearch()
+	startSnapshot()
+	registerMetrics()
+}
+
+// Shutdown flushes what is still held in memory, called once the server
+// stopped taking requests.
+func Shutdown(ctx context.Context) {
+	flushAudit(ctx)
+	flushTracing(ctx)
 }
@@ -0,0 +1,24 @@
+package logic
+
+import (
+	"os"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/model"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain runs the package against a throwaway sqlite database.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "logic-test")
+	if err != nil {
+		panic(err)
+	}
+
+	log.InitCore(conf.Log{Filename: filepath.Join(dir, "test.log")})
+	model.InitDb(conf.Database{Dbname: filepath.Join(dir, "test.db")})
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
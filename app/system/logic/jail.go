@@ -0,0 +1,137 @@
+package logic
+
+import (
+	"context"
+	"overlink.top/app/lib/util"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Home directories of all users, anonymous requests are checked against them
+// on every /fd download
+var (
+	basePathLock   sync.RWMutex
+	basePathList   []string
+	basePathLoaded bool
+)
+
+// basePath returns the home directory the user is jailed in, an empty string
+// means the whole virtual tree is visible.
+func basePath(user *model.User) string {
+	if user == nil || user.IsSuper() {
+		return ""
+	}
+
+	base := util.StandardPath(user.BasePath)
+	if base == "/" {
+		return ""
+	}
+
+	return base
+}
+
+// RealPath maps a path as seen by the user onto the virtual tree. The view
+// path is cleaned as an absolute path first, so ".." can never climb above
+// the base path.
+func RealPath(ctx context.Context, rpath string) string {
+	rpath = util.StandardPath(rpath)
+	base := basePath(Identity(ctx))
+	if base == "" {
+		return rpath
+	}
+
+	return path.Join(base, rpath)
+}
+
+// ViewPath is the inverse of RealPath.
+func ViewPath(ctx context.Context, rpath string) string {
+	base := basePath(Identity(ctx))
+	if base == "" {
+		return rpath
+	}
+
+	return util.StandardPath(strings.TrimPrefix(rpath, base))
+}
+
+func viewFileList(ctx context.Context, list []msg.Finfo) []msg.Finfo {
+	if basePath(Identity(ctx)) == "" {
+		return list
+	}
+
+	viewList := make([]msg.Finfo, 0, len(list))
+	for _, v := range list {
+		viewList = append(viewList, viewFileInfo(ctx, v))
+	}
+
+	return viewList
+}
+
+// viewFileInfo copies the item instead of rewriting it in place, infos may be
+// shared with the list cache.
+func viewFileInfo(ctx context.Context, info msg.Finfo) msg.Finfo {
+	if basePath(Identity(ctx)) == "" || info.GetPath() == "" {
+		return info
+	}
+
+	return &msg.FileInfo{
+		FileId:   info.GetFileId(),
+		Path:     ViewPath(ctx, info.GetPath()),
+		Name:     info.GetName(),
+		Size:     info.GetSize(),
+		Modified: info.ModTime(),
+		IsFolder: info.IsDir(),
+		RawUrl:   info.GetRaw(),
+	}
+}
+
+// loadAllBasePath refreshes the home directories IsPublicPath checks, called
+// whenever users are added, changed or removed.
+func loadAllBasePath() {
+	userList, err := model.GetAllUser()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	var list []string
+	for _, v := range userList {
+		if base := basePath(&v); base != "" {
+			list = append(list, base)
+		}
+	}
+
+	basePathLock.Lock()
+	basePathList, basePathLoaded = list, true
+	basePathLock.Unlock()
+}
+
+// IsPublicPath reports whether callers without an identity may read rpath,
+// paths under a mount with ACL entries or inside a home directory are not.
+func IsPublicPath(rpath string) bool {
+	rpath = util.StandardPath(rpath)
+	if store := findStorage(rpath); store != nil {
+		if _, ok := aclMap.Load(store.GetData().MountPath); ok {
+			return false
+		}
+	}
+
+	basePathLock.RLock()
+	defer basePathLock.RUnlock()
+
+	// Until the users could be read every path may be a home directory
+	if !basePathLoaded {
+		return false
+	}
+
+	for _, base := range basePathList {
+		if isSubPath(base, rpath) {
+			return false
+		}
+	}
+
+	return true
+}
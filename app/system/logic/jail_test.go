@@ -0,0 +1,92 @@
+package logic
+
+import (
+	"context"
+	"overlink.top/app/system/model"
+	"testing"
+)
+
+func TestRealPath(t *testing.T) {
+	jailed := WithIdentity(context.Background(), &model.User{Username: "u", BasePath: "/home/u"})
+	super := WithIdentity(context.Background(), &model.User{Username: "admin", BasePath: "/home/u"})
+	free := WithIdentity(context.Background(), &model.User{Username: "v", BasePath: "/"})
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		rpath string
+		want  string
+	}{
+		{"root of the jail", jailed, "/", "/home/u"},
+		{"empty path", jailed, "", "/home/u"},
+		{"inside", jailed, "/docs/a.txt", "/home/u/docs/a.txt"},
+		{"no leading slash", jailed, "docs", "/home/u/docs"},
+		{"dot dot at the root", jailed, "/..", "/home/u"},
+		{"dot dot climbing out", jailed, "/../../etc/passwd", "/home/u/etc/passwd"},
+		{"dot dot in the middle", jailed, "/docs/../../v/x", "/home/u/v/x"},
+		{"relative dot dot", jailed, "../v", "/home/u/v"},
+		{"super ignores the base path", super, "/../a", "/a"},
+		{"base path / is no jail", free, "/a/../b", "/b"},
+		{"no identity", context.Background(), "/a", "/a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RealPath(tt.ctx, tt.rpath); got != tt.want {
+				t.Errorf("RealPath(%q) = %q, want %q", tt.rpath, got, tt.want)
+			}
+		})
+	}
+
+	if got := ViewPath(jailed, "/home/u/docs/a.txt"); got != "/docs/a.txt" {
+		t.Errorf("ViewPath = %q, want /docs/a.txt", got)
+	}
+
+	if got := ViewPath(jailed, "/home/u"); got != "/" {
+		t.Errorf("ViewPath of the jail = %q, want /", got)
+	}
+}
+
+func TestIsPublicPath(t *testing.T) {
+	user := &model.User{Username: "public-test", BasePath: "/home/p"}
+	if err := model.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	t.Cleanup(func() {
+		model.DeleteUser(user.ID)
+		loadAllBasePath()
+	})
+
+	basePathLoaded = false
+	if IsPublicPath("/anything") {
+		t.Error("paths are public before the home directories are loaded")
+	}
+
+	loadAllBasePath()
+	tests := []struct {
+		rpath string
+		want  bool
+	}{
+		{"/home/p", false},
+		{"/home/p/a.txt", false},
+		{"/home/p/../p/a.txt", false},
+		{"/home/pp/a.txt", true},
+		{"/home", true},
+		{"/other/a.txt", true},
+	}
+
+	for _, tt := range tests {
+		if got := IsPublicPath(tt.rpath); got != tt.want {
+			t.Errorf("IsPublicPath(%s) = %v, want %v", tt.rpath, got, tt.want)
+		}
+	}
+
+	// Moving the home directory is picked up without a restart
+	err := UpdateUser(context.Background(), model.User{ID: user.ID, Username: user.Username, BasePath: "/home/q"})
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	if !IsPublicPath("/home/p/a.txt") || IsPublicPath("/home/q/a.txt") {
+		t.Error("home directory change not reflected in IsPublicPath")
+	}
+}
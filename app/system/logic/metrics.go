@@ -0,0 +1,38 @@
+package logic
+
+import (
+	"overlink.top/app/internal/cache"
+	"overlink.top/app/internal/metrics"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/model"
+)
+
+func registerMetrics() {
+	metrics.RegisterCache("meta", func() metrics.CacheStats {
+		hits, misses := cache.Stats()
+		return metrics.CacheStats{Hits: hits, Misses: misses, Size: -1}
+	})
+	metrics.RegisterStorage(storageStates)
+}
+
+// storageStates reads the mount status saved by the last mount attempt,
+// disabled storages are not kept in storageMap so the table is the source.
+func storageStates() []metrics.StorageState {
+	dataList, err := model.GetAllStorage()
+	if err != nil {
+		log.Errorf("metrics list storage err: %+v", err)
+		return nil
+	}
+
+	list := make([]metrics.StorageState, 0, len(dataList))
+	for _, v := range dataList {
+		list = append(list, metrics.StorageState{
+			Mount:    v.MountPath,
+			Engine:   v.Engine,
+			Disabled: v.Disabled,
+			Err:      v.Status != WORK,
+		})
+	}
+
+	return list
+}
@@ -181,7 +181,11 @@ func loadPreviewConf(isUpdate bool) {
 }
 
 func GetPreference() (resp map[string]string, err error) {
-	dataList, err := model.GetPreferenceByTerm(TremSite)
+	return getPreferenceMap(TremSite)
+}
+
+func getPreferenceMap(term int) (resp map[string]string, err error) {
+	dataList, err := model.GetPreferenceByTerm(term)
 	if err != nil {
 		return
 	}
@@ -213,6 +217,7 @@ func GetDisplay() (resp msg.GetDisplayResp, err error) {
 }
 
 func UpdateDisplay(ctx context.Context, data msg.UpdateDisplayReq) (err error) {
+	before, _ := getPreferenceMap(TremDisplay)
 	video := data.Video
 	if len(video) > 0 {
 		video = findIntersection(conf.PreviewVideo, video)
@@ -268,6 +273,9 @@ func UpdateDisplay(ctx context.Context, data msg.UpdateDisplayReq) (err error) {
 	previewAudio = audio
 	loadPreviewConf(true)
 
+	after, _ := getPreferenceMap(TremDisplay)
+	Audit(ctx, "preference.display", "display", before, after)
+
 	return
 }
 
@@ -284,6 +292,7 @@ func GetSite() (resp msg.GetSiteResp, err error) {
 }
 
 func UpdateSite(ctx context.Context, data msg.UpdateSiteReq) (err error) {
+	before, _ := getPreferenceMap(TremSite)
 	err = model.UpdatePreference(SiteTitleKey, data.Title)
 	if err != nil {
 		return
@@ -327,6 +336,9 @@ func UpdateSite(ctx context.Context, data msg.UpdateSiteReq) (err error) {
 	conf.GlobalSign = data.GlobalSign
 	conf.SignExpiration = data.SignExpiration
 
+	after, _ := getPreferenceMap(TremSite)
+	Audit(ctx, "preference.site", "site", before, after)
+
 	return
 }
 
@@ -0,0 +1,359 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"overlink.top/app/internal/search"
+	"overlink.top/app/storage"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSearchPath = "runtime/data/search.db"
+)
+
+var searchIndex *search.Index
+
+func startSearch() {
+	cfg := conf.AppConf.Search
+	if !cfg.Enable {
+		return
+	}
+
+	fullPath := cfg.Path
+	if fullPath == "" {
+		fullPath = defaultSearchPath
+	}
+
+	idx, err := search.Open(conf.AbsPath(fullPath))
+	if err != nil {
+		log.StdErrorf("open search index err: %+v", err)
+		return
+	}
+
+	searchIndex = idx
+	go searchWorker()
+}
+
+func searchWorker() {
+	cfg := conf.AppConf.Search
+	interval := time.Duration(cfg.Interval) * time.Minute
+	if interval <= 0 {
+		interval = 30 * time.Minute
+	}
+
+	fullInterval := time.Duration(cfg.FullInterval) * time.Hour
+	if fullInterval <= 0 {
+		fullInterval = 24 * time.Hour
+	}
+
+	var lastFull time.Time
+	for {
+		full := time.Since(lastFull) >= fullInterval
+		if full {
+			lastFull = time.Now()
+		}
+
+		crawlAllStorage(full)
+		time.Sleep(interval)
+	}
+}
+
+// crawlAllStorage runs one crawler per mount and waits for all of them, a
+// full crawl lists every folder instead of trusting unchanged mtimes.
+func crawlAllStorage(full bool) {
+	var stores []storage.Storage
+	var mounts []string
+	storageMap.Range(func(key, value interface{}) bool {
+		stores = append(stores, value.(storage.Storage))
+		mounts = append(mounts, key.(string))
+		return true
+	})
+
+	err := searchIndex.Retain(mounts)
+	if err != nil {
+		log.Errorf("purge search index err: %+v", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, store := range stores {
+		wg.Add(1)
+		go func(store storage.Storage) {
+			defer wg.Done()
+			crawlStorage(store, full)
+		}(store)
+	}
+
+	wg.Wait()
+}
+
+func crawlStorage(store storage.Storage, full bool) {
+	start := time.Now()
+	mountPath := store.GetData().MountPath
+	count := crawlFolder(context.Background(), store, &msg.FileInfo{Path: mountPath, IsFolder: true}, full)
+	log.Infof("search crawl %s done, full: %v, folders listed: %d, cost: %s", mountPath, full, count, time.Since(start))
+}
+
+// crawlFolder syncs the children of dir into the index and descends into
+// the subfolders whose mtime changed since the last crawl. It returns the
+// number of folders listed.
+func crawlFolder(ctx context.Context, store storage.Storage, dir msg.Finfo, full bool) int {
+	// The mount was removed or replaced while crawling
+	if inst, ok := storageMap.Load(store.GetData().MountPath); !ok || inst != store {
+		return 0
+	}
+
+	waitListBudget(store)
+	rpath := dir.GetPath()
+	list, err := store.List(ctx, dir)
+	if pace := conf.AppConf.Search.Pace; pace > 0 {
+		time.Sleep(time.Duration(pace) * time.Millisecond)
+	}
+
+	if err != nil {
+		log.Warnf("search crawl %s err: %+v", rpath, err)
+		// Ancestors too, or an unchanged parent would keep the crawler away
+		var paths []string
+		for p := rpath; p != store.GetData().MountPath && p != "/"; p = path.Dir(p) {
+			paths = append(paths, p)
+		}
+
+		if len(paths) > 0 {
+			searchIndex.Expire(paths...)
+		}
+
+		return 1
+	}
+
+	old, err := searchIndex.Children(rpath)
+	if err != nil {
+		log.Errorf("read search index err: %+v", err)
+		return 1
+	}
+
+	oldMap := make(map[string]search.Entry, len(old))
+	for _, v := range old {
+		oldMap[v.Path] = v
+	}
+
+	entries := make([]search.Entry, 0, len(list))
+	for _, v := range list {
+		entries = append(entries, search.Entry{
+			Mount:    store.GetData().MountPath,
+			Path:     v.GetPath(),
+			Parent:   rpath,
+			FileId:   v.GetFileId(),
+			Name:     v.GetName(),
+			Ext:      strings.ToLower(strings.TrimPrefix(path.Ext(v.GetName()), ".")),
+			Size:     v.GetSize(),
+			Modified: v.ModTime().Unix(),
+			IsFolder: v.IsDir(),
+		})
+	}
+
+	err = searchIndex.Sync(rpath, entries)
+	if err != nil {
+		log.Errorf("update search index err: %+v", err)
+		return 1
+	}
+
+	count := 1
+	for _, v := range list {
+		if !v.IsDir() {
+			continue
+		}
+
+		prev, ok := oldMap[v.GetPath()]
+		if !full && ok && prev.IsFolder && prev.Modified == v.ModTime().Unix() {
+			continue
+		}
+
+		count += crawlFolder(ctx, store, v, full)
+	}
+
+	return count
+}
+
+// waitListBudget holds the crawler while less than half of the list budget
+// of the engine is left, users browsing the mount come first.
+func waitListBudget(store storage.Storage) {
+	budgeted, ok := store.(storage.Budgeted)
+	if !ok {
+		return
+	}
+
+	for {
+		left, max := budgeted.ListBudget()
+		if max == 0 || left*2 > max {
+			return
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+// SearchFile looks up the index and drops what the user bound to ctx could
+// not browse to: other home directories, mounts denied by ACL and folders
+// whose password is missing from req.
+func SearchFile(ctx context.Context, req msg.SearchFileReq) (resp msg.SearchFileResp, err error) {
+	if searchIndex == nil {
+		err = errors.New("search is not enabled")
+		return
+	}
+
+	if req.Pagenum < 1 {
+		req.Pagenum = 1
+	}
+
+	if req.Pagesize < 1 || req.Pagesize > 200 {
+		req.Pagesize = 20
+	}
+
+	query := search.Query{
+		Name:    req.Name,
+		MinSize: req.MinSize,
+		MaxSize: req.MaxSize,
+		Type:    req.Type,
+	}
+
+	for _, v := range strings.Split(req.Ext, ",") {
+		v = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(v), "."))
+		if v != "" {
+			query.Exts = append(query.Exts, v)
+		}
+	}
+
+	if req.Start > 0 {
+		query.Start = time.Unix(req.Start, 0)
+	}
+
+	if req.End > 0 {
+		query.End = time.Unix(req.End, 0)
+	}
+
+	if scope := RealPath(ctx, req.Mount); scope != "/" {
+		query.Prefix = scope
+	}
+
+	// Mounts the ACL denies and locked folders never leave the index, paging
+	// and the total stay in SQL
+	query.ExcludeMounts = deniedMounts(ctx)
+	query.ExcludeFolders = lockedFolders(ctx, req.Passwords)
+	total, err := searchIndex.CountMatch(query)
+	if err != nil {
+		return
+	}
+
+	offset := (req.Pagenum - 1) * req.Pagesize
+	query.Offset, query.Limit = offset, req.Pagesize
+	list, err := searchIndex.Search(query)
+	if err != nil {
+		return
+	}
+
+	resp.List = []msg.FileInfo{}
+	for _, v := range list {
+		resp.List = append(resp.List, searchItem(ctx, v))
+	}
+
+	resp.Total = int(total)
+	resp.Pagenum = req.Pagenum
+	resp.More = int64(offset+len(list)) < total
+	return
+}
+
+func searchItem(ctx context.Context, v search.Entry) msg.FileInfo {
+	item := msg.FileInfo{
+		Path:     ViewPath(ctx, v.Path),
+		Name:     v.Name,
+		Size:     v.Size,
+		Modified: time.Unix(v.Modified, 0),
+		IsFolder: v.IsFolder,
+	}
+
+	if !v.IsFolder {
+		item.Ptype = getPreviewType(v.Name)
+	}
+
+	return item
+}
+
+// deniedMounts lists the mounts the user bound to ctx may not read.
+func deniedMounts(ctx context.Context) []string {
+	user := Identity(ctx)
+	if user == nil || user.IsSuper() {
+		return nil
+	}
+
+	var mounts []string
+	storageMap.Range(func(key, value interface{}) bool {
+		mountPath := key.(string)
+		if !aclAllow(user, mountPath, conf.ActionRead) {
+			mounts = append(mounts, mountPath)
+		}
+
+		return true
+	})
+
+	return mounts
+}
+
+// lockedFolders lists the password folders that stay locked for the user
+// bound to ctx with passwords, as folderUnlocked would rule on each entry.
+// A folder applying to its subfolders leaves those with a setting of their
+// own to it, only a setting at the root never reaches below.
+func lockedFolders(ctx context.Context, passwords map[string]string) []search.FolderExclusion {
+	user := Identity(ctx)
+	if user != nil && user.IsSuper() {
+		return nil
+	}
+
+	var settings []model.FolderSetting
+	pwdSettingMap.Range(func(key, value interface{}) bool {
+		settings = append(settings, value.(model.FolderSetting))
+		return true
+	})
+
+	var list []search.FolderExclusion
+	for _, v := range settings {
+		if passwords[ViewPath(ctx, v.Folder)] == v.Password {
+			continue
+		}
+
+		exclusion := search.FolderExclusion{Folder: v.Folder, Sub: v.ApplySub && v.Folder != "/"}
+		if exclusion.Sub {
+			for _, sub := range settings {
+				if sub.Folder != v.Folder && isSubPath(v.Folder, sub.Folder) {
+					exclusion.Keep = append(exclusion.Keep, sub.Folder)
+				}
+			}
+		}
+
+		list = append(list, exclusion)
+	}
+
+	return list
+}
+
+// folderUnlocked applies the same rule as IsFolderForbidden to rpath,
+// passwords are keyed by the folder path as the user sees it.
+func folderUnlocked(ctx context.Context, rpath string, passwords map[string]string) bool {
+	user := Identity(ctx)
+	if user != nil && user.IsSuper() {
+		return true
+	}
+
+	setting := findMatchSetting(rpath)
+	if setting.Folder == "" || (setting.Folder != rpath && !setting.ApplySub) {
+		return true
+	}
+
+	return passwords[ViewPath(ctx, setting.Folder)] == setting.Password
+}
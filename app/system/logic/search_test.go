@@ -0,0 +1,96 @@
+package logic
+
+import (
+	"context"
+	"overlink.top/app/internal/search"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+	"path"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestSearchFileExcludesLockedFolders(t *testing.T) {
+	idx, err := search.Open(filepath.Join(t.TempDir(), "search.db"))
+	if err != nil {
+		t.Fatalf("search.Open: %v", err)
+	}
+
+	searchIndex = idx
+	t.Cleanup(func() {
+		searchIndex = nil
+		idx.Close()
+	})
+
+	folders := []string{"/", "/m", "/m/a", "/m/a/b", "/m/a/b/c", "/m/a/x", "/m/d", "/m/d/e", "/m/a_"}
+	var all []search.Entry
+	for _, parent := range folders {
+		list := []search.Entry{{Mount: "/m", Path: path.Join(parent, "f.txt"), Parent: parent, Name: "f.txt", Ext: "txt", Modified: 1}}
+		if err := idx.Sync(parent, list); err != nil {
+			t.Fatalf("Sync: %v", err)
+		}
+
+		all = append(all, list...)
+	}
+
+	resetMap(t, &pwdSettingMap)
+	for _, v := range []model.FolderSetting{
+		{Folder: "/", Password: "r", ApplySub: true},
+		{Folder: "/m/a", Password: "a", ApplySub: true},
+		{Folder: "/m/a/b", Password: "b"},
+		{Folder: "/m/d", Password: "d"},
+	} {
+		pwdSettingMap.Store(v.Folder, v)
+	}
+
+	ctx := WithIdentity(context.Background(), &model.User{ID: 9, Username: "viewer"})
+	for _, passwords := range []map[string]string{
+		nil,
+		{"/m/a/b": "b"},
+		{"/m/a": "a"},
+		{"/m/a": "a", "/m/a/b": "wrong", "/m/d": "d", "/": "r"},
+	} {
+		var want []string
+		for _, v := range all {
+			if folderUnlocked(ctx, v.Parent, passwords) {
+				want = append(want, v.Path)
+			}
+		}
+
+		// Small pages, totals and more have to add up over all of them
+		var got []string
+		for page := 1; ; page++ {
+			resp, err := SearchFile(ctx, msg.SearchFileReq{Passwords: passwords, Pagenum: page, Pagesize: 2})
+			if err != nil {
+				t.Fatalf("SearchFile: %v", err)
+			}
+
+			if resp.Total != len(want) {
+				t.Errorf("passwords %v: total = %d, want %d", passwords, resp.Total, len(want))
+			}
+
+			for _, v := range resp.List {
+				got = append(got, v.Path)
+			}
+
+			if !resp.More {
+				break
+			}
+		}
+
+		sort.Strings(got)
+		sort.Strings(want)
+		if len(got) != len(want) {
+			t.Errorf("passwords %v: got %v, want %v", passwords, got, want)
+			continue
+		}
+
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("passwords %v: got %v, want %v", passwords, got, want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"overlink.top/app/system/msg"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ranges left after merging beyond which the whole file is served, many
+// small parts cost a storage request each
+const maxRanges = 16
+
+type readRangeFunc func(ctx context.Context, offset int64, length int64, w io.Writer) error
+
+type readAllFunc func(ctx context.Context, w io.Writer) error
+
+// serveContent answers a GET or HEAD for info: validators and 304 (RFC 7232),
+// then single or multipart ranges honouring If-Range (RFC 7233). readRange is
+// nil when only whole files can be streamed, ranges are then ignored. The
+// returned error comes from streaming, the status is already sent.
+func serveContent(w http.ResponseWriter, r *http.Request, info msg.Finfo, readRange readRangeFunc, readAll readAllFunc) error {
+	size := info.GetSize()
+	setAttach(w, &fileInfoAdapter{info})
+	// WebDAV sets the ETag it reports in PROPFIND before calling in
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		etag = fileETag(info)
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+	}
+
+	if modified := info.ModTime(); !isZeroTime(modified) {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(r, info, etag) {
+		h := w.Header()
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		h.Del("Content-Disposition")
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	var ranges []byteRange
+	if readRange != nil {
+		w.Header().Set("Accept-Ranges", "bytes")
+		rangeHeader := r.Header.Get("Range")
+		if rangeHeader != "" && ifRangeMatch(r, info, etag) {
+			var err error
+			ranges, err = parseRangeHeader(rangeHeader, size)
+			if err != nil {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return nil
+			}
+
+			ranges = mergeRanges(ranges)
+			if len(ranges) > maxRanges {
+				ranges = nil
+			}
+		}
+	}
+
+	ctx := r.Context()
+	head := r.Method == http.MethodHead
+	switch len(ranges) {
+	case 0:
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		if head {
+			return nil
+		}
+
+		return readAll(ctx, w)
+	case 1:
+		ra := ranges[0]
+		w.Header().Set("Content-Length", strconv.FormatInt(ra.Length(), 10))
+		w.Header().Set("Content-Range", ra.contentRange(size))
+		w.WriteHeader(http.StatusPartialContent)
+		if head {
+			return nil
+		}
+
+		return readRange(ctx, ra.Start, ra.Length(), w)
+	}
+
+	contentType := w.Header().Get("Content-Type")
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Set("Content-Length", strconv.FormatInt(multipartSize(ranges, contentType, size), 10))
+	w.WriteHeader(http.StatusPartialContent)
+	if head {
+		return nil
+	}
+
+	for _, v := range ranges {
+		part, err := mw.CreatePart(v.mimeHeader(contentType, size))
+		if err != nil {
+			return err
+		}
+
+		err = readRange(ctx, v.Start, v.Length(), part)
+		if err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
+
+// fileETag derives a strong validator from the modified time and size,
+// engines without a modified time get none.
+func fileETag(info msg.Finfo) string {
+	modified := info.ModTime()
+	if isZeroTime(modified) {
+		return ""
+	}
+
+	return fmt.Sprintf(`"%x-%x"`, modified.Unix(), info.GetSize())
+}
+
+func isZeroTime(t time.Time) bool {
+	return t.IsZero() || t.Unix() <= 0
+}
+
+func notModified(r *http.Request, info msg.Finfo, etag string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etag != "" && etagListMatch(inm, etag)
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	modified := info.ModTime()
+	if ims == "" || isZeroTime(modified) {
+		return false
+	}
+
+	t, err := http.ParseTime(ims)
+	return err == nil && !modified.Truncate(time.Second).After(t)
+}
+
+// etagListMatch is the weak comparison If-None-Match asks for.
+func etagListMatch(list string, etag string) bool {
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ifRangeMatch tells if the Range header still applies, a stale If-Range
+// turns the answer into the full file.
+func ifRangeMatch(r *http.Request, info msg.Finfo, etag string) bool {
+	ir := strings.TrimSpace(r.Header.Get("If-Range"))
+	if ir == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return etag != "" && ir == etag
+	}
+
+	modified := info.ModTime()
+	t, err := http.ParseTime(ir)
+	return err == nil && !isZeroTime(modified) && modified.Truncate(time.Second).Equal(t)
+}
+
+// mergeRanges sorts ranges and coalesces those overlapping or adjacent, so
+// no byte is sent twice.
+func mergeRanges(ranges []byteRange) []byteRange {
+	if len(ranges) < 2 {
+		return ranges
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+
+	merged := ranges[:1]
+	for _, v := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if v.Start > last.End+1 {
+			merged = append(merged, v)
+			continue
+		}
+
+		last.End = max(last.End, v.End)
+	}
+
+	return merged
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.Start, br.End, size)
+}
+
+func (br byteRange) mimeHeader(contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {br.contentRange(size)},
+		"Content-Type":  {contentType},
+	}
+}
+
+// multipartSize renders the part headers once to know the Content-Length,
+// the boundary length is the same for every writer.
+func multipartSize(ranges []byteRange, contentType string, size int64) int64 {
+	var w countWriter
+	mw := multipart.NewWriter(&w)
+	var total int64
+	for _, v := range ranges {
+		mw.CreatePart(v.mimeHeader(contentType, size))
+		total += v.Length()
+	}
+
+	mw.Close()
+	return total + int64(w)
+}
+
+type countWriter int64
+
+func (self *countWriter) Write(p []byte) (int, error) {
+	*self += countWriter(len(p))
+	return len(p), nil
+}
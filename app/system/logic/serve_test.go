@@ -0,0 +1,294 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"overlink.top/app/system/msg"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var serveModified = time.Unix(1700000000, 0)
+
+func serveData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	return data
+}
+
+func readRangeOf(data []byte) readRangeFunc {
+	return func(ctx context.Context, offset int64, length int64, w io.Writer) error {
+		_, err := w.Write(data[offset : offset+length])
+		return err
+	}
+}
+
+func readAllOf(data []byte) readAllFunc {
+	return func(ctx context.Context, w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}
+}
+
+func TestParseRangeHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []byteRange
+		fail   bool
+	}{
+		{"bytes=0-9", []byteRange{{0, 9}}, false},
+		{"bytes=90-", []byteRange{{90, 99}}, false},
+		{"bytes=-10", []byteRange{{90, 99}}, false},
+		{"bytes=-200", []byteRange{{0, 99}}, false},
+		{"bytes=95-200", []byteRange{{95, 99}}, false},
+		{"bytes=0-1, 5-6", []byteRange{{0, 1}, {5, 6}}, false},
+		{"bytes=200-300, 0-1", []byteRange{{0, 1}}, false},
+		{"bytes=100-", nil, true},
+		{"bytes=-0", nil, true},
+		{"bytes=5-1", nil, true},
+		{"bytes=a-b", nil, true},
+		{"items=0-1", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRangeHeader(tt.header, 100)
+		if (err != nil) != tt.fail {
+			t.Errorf("parseRangeHeader(%q) err = %v, want failure %v", tt.header, err, tt.fail)
+			continue
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseRangeHeader(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestMergeRanges(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byteRange
+		want []byteRange
+	}{
+		{"single", []byteRange{{5, 9}}, []byteRange{{5, 9}}},
+		{"apart", []byteRange{{0, 1}, {5, 6}}, []byteRange{{0, 1}, {5, 6}}},
+		{"unsorted", []byteRange{{5, 6}, {0, 1}}, []byteRange{{0, 1}, {5, 6}}},
+		{"adjacent", []byteRange{{0, 4}, {5, 9}}, []byteRange{{0, 9}}},
+		{"overlapping", []byteRange{{0, 6}, {5, 9}}, []byteRange{{0, 9}}},
+		{"contained", []byteRange{{0, 9}, {2, 3}}, []byteRange{{0, 9}}},
+		{"chain", []byteRange{{8, 12}, {0, 3}, {4, 8}, {20, 21}}, []byteRange{{0, 12}, {20, 21}}},
+	}
+
+	for _, tt := range tests {
+		if got := mergeRanges(tt.in); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: mergeRanges = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEtagListMatch(t *testing.T) {
+	tests := []struct {
+		list string
+		want bool
+	}{
+		{`"a"`, true},
+		{`W/"a"`, true},
+		{`"b", "a"`, true},
+		{`*`, true},
+		{`"b"`, false},
+		{`a`, false},
+	}
+
+	for _, tt := range tests {
+		if got := etagListMatch(tt.list, `"a"`); got != tt.want {
+			t.Errorf("etagListMatch(%s) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestConditionalHeaders(t *testing.T) {
+	info := &msg.FileInfo{Name: "a.txt", Size: 100, Modified: serveModified}
+	etag := fileETag(info)
+	stale := serveModified.Add(-time.Hour).UTC().Format(http.TimeFormat)
+	exact := serveModified.UTC().Format(http.TimeFormat)
+	later := serveModified.Add(time.Hour).UTC().Format(http.TimeFormat)
+	tests := []struct {
+		name     string
+		method   string
+		header   map[string]string
+		modified bool
+		ranged   bool
+	}{
+		{"no validators", http.MethodGet, nil, true, true},
+		{"etag matches", http.MethodGet, map[string]string{"If-None-Match": etag}, false, true},
+		{"etag differs", http.MethodGet, map[string]string{"If-None-Match": `"x"`}, true, true},
+		{"etag wins over date", http.MethodGet, map[string]string{"If-None-Match": `"x"`, "If-Modified-Since": later}, true, true},
+		{"not modified since", http.MethodGet, map[string]string{"If-Modified-Since": exact}, false, true},
+		{"modified since", http.MethodGet, map[string]string{"If-Modified-Since": stale}, true, true},
+		{"post ignores validators", http.MethodPost, map[string]string{"If-None-Match": etag}, true, true},
+		{"if-range etag", http.MethodGet, map[string]string{"If-Range": etag}, true, true},
+		{"if-range stale etag", http.MethodGet, map[string]string{"If-Range": `"x"`}, true, false},
+		{"if-range weak etag", http.MethodGet, map[string]string{"If-Range": "W/" + etag}, true, false},
+		{"if-range date", http.MethodGet, map[string]string{"If-Range": exact}, true, true},
+		{"if-range stale date", http.MethodGet, map[string]string{"If-Range": stale}, true, false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/a.txt", nil)
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+
+		if got := notModified(r, info, etag); got == tt.modified {
+			t.Errorf("%s: notModified = %v", tt.name, got)
+		}
+
+		if got := ifRangeMatch(r, info, etag); got != tt.ranged {
+			t.Errorf("%s: ifRangeMatch = %v, want %v", tt.name, got, tt.ranged)
+		}
+	}
+}
+
+func TestServeContent(t *testing.T) {
+	data := serveData(100)
+	info := &msg.FileInfo{Name: "a.txt", Size: int64(len(data)), Modified: serveModified}
+	etag := fileETag(info)
+	var many []string
+	for i := 0; i <= maxRanges; i++ {
+		many = append(many, fmt.Sprintf("%d-%d", i*4, i*4+1))
+	}
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		status int
+		body   []byte
+		rangeH string
+	}{
+		{"whole", nil, http.StatusOK, data, ""},
+		{"single range", map[string]string{"Range": "bytes=10-19"}, http.StatusPartialContent, data[10:20], "bytes 10-19/100"},
+		{"merged into one", map[string]string{"Range": "bytes=10-14,15-19,12-13"}, http.StatusPartialContent, data[10:20], "bytes 10-19/100"},
+		{"too many ranges", map[string]string{"Range": "bytes=" + strings.Join(many, ",")}, http.StatusOK, data, ""},
+		{"unsatisfiable", map[string]string{"Range": "bytes=100-"}, http.StatusRequestedRangeNotSatisfiable, nil, "bytes */100"},
+		{"stale if-range", map[string]string{"Range": "bytes=10-19", "If-Range": `"x"`}, http.StatusOK, data, ""},
+		{"fresh if-range", map[string]string{"Range": "bytes=10-19", "If-Range": etag}, http.StatusPartialContent, data[10:20], "bytes 10-19/100"},
+		{"if-none-match", map[string]string{"If-None-Match": etag, "Range": "bytes=10-19"}, http.StatusNotModified, nil, ""},
+		{"if-modified-since", map[string]string{"If-Modified-Since": serveModified.UTC().Format(http.TimeFormat)}, http.StatusNotModified, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			err := serveContent(w, r, info, readRangeOf(data), readAllOf(data))
+			if err != nil {
+				t.Fatalf("serveContent: %v", err)
+			}
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+
+			if !bytes.Equal(w.Body.Bytes(), tt.body) {
+				t.Errorf("body = %d bytes, want %d", w.Body.Len(), len(tt.body))
+			}
+
+			if got := w.Header().Get("Content-Range"); got != tt.rangeH {
+				t.Errorf("Content-Range = %q, want %q", got, tt.rangeH)
+			}
+
+			if tt.body != nil && w.Header().Get("Content-Length") != strconv.Itoa(len(tt.body)) {
+				t.Errorf("Content-Length = %s, want %d", w.Header().Get("Content-Length"), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestServeContentMultipart(t *testing.T) {
+	data := serveData(100)
+	info := &msg.FileInfo{Name: "a.txt", Size: int64(len(data)), Modified: serveModified}
+	r := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+	r.Header.Set("Range", "bytes=90-,0-4,3-9,50-59")
+	w := httptest.NewRecorder()
+	err := serveContent(w, r, info, readRangeOf(data), readAllOf(data))
+	if err != nil {
+		t.Fatalf("serveContent: %v", err)
+	}
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", w.Code)
+	}
+
+	// The length announced up front is what went out
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(w.Body.Len()) {
+		t.Errorf("Content-Length = %s, body is %d bytes", got, w.Body.Len())
+	}
+
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("Content-Type = %s", w.Header().Get("Content-Type"))
+	}
+
+	want := []byteRange{{0, 9}, {50, 59}, {90, 99}}
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	for i := 0; ; i++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			if i != len(want) {
+				t.Errorf("%d parts, want %d", i, len(want))
+			}
+
+			break
+		}
+
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+
+		if i >= len(want) {
+			t.Fatalf("more than %d parts", len(want))
+		}
+
+		if got := part.Header.Get("Content-Range"); got != want[i].contentRange(100) {
+			t.Errorf("part %d Content-Range = %s, want %s", i, got, want[i].contentRange(100))
+		}
+
+		body, _ := io.ReadAll(part)
+		if !bytes.Equal(body, data[want[i].Start:want[i].End+1]) {
+			t.Errorf("part %d = %v", i, body)
+		}
+	}
+}
+
+func TestServeContentHeadMultipart(t *testing.T) {
+	data := serveData(100)
+	info := &msg.FileInfo{Name: "a.txt", Size: int64(len(data)), Modified: serveModified}
+	ranges := []byteRange{{0, 9}, {50, 59}}
+
+	r := httptest.NewRequest(http.MethodHead, "/a.txt", nil)
+	r.Header.Set("Range", "bytes=0-9,50-59")
+	w := httptest.NewRecorder()
+	serveContent(w, r, info, readRangeOf(data), readAllOf(data))
+	if w.Body.Len() != 0 {
+		t.Errorf("HEAD wrote %d bytes", w.Body.Len())
+	}
+
+	want := multipartSize(ranges, "text/plain; charset=utf-8", 100)
+	if got := w.Header().Get("Content-Length"); got != strconv.FormatInt(want, 10) {
+		t.Errorf("HEAD Content-Length = %s, want %d", got, want)
+	}
+}
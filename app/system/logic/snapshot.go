@@ -0,0 +1,309 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"github.com/robfig/cron/v3"
+	"net"
+	"overlink.top/app/lib/util"
+	"overlink.top/app/storage"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	snapshotRetry      = 3
+	snapshotRetryDelay = 5 * time.Second
+)
+
+var snapshotRunning sync.Map
+
+func startSnapshot() {
+	go snapshotWorker()
+}
+
+// snapshotWorker wakes up every minute and starts the snapshots whose
+// schedule fell due since the last tick.
+func snapshotWorker() {
+	last := time.Now()
+	for {
+		time.Sleep(time.Until(last.Truncate(time.Minute).Add(time.Minute)))
+		now := time.Now()
+		storageMap.Range(func(key, value interface{}) bool {
+			store := value.(storage.Storage)
+			spec := store.GetData().SnapshotCron
+			if spec == "" {
+				return true
+			}
+
+			schedule, err := cron.ParseStandard(spec)
+			if err != nil {
+				return true
+			}
+
+			if !schedule.Next(last).After(now) {
+				go func() {
+					err := takeSnapshot(store)
+					if err != nil {
+						log.Errorf("snapshot %s err: %+v", store.GetData().MountPath, err)
+					}
+				}()
+			}
+
+			return true
+		})
+
+		last = now
+	}
+}
+
+func checkSnapshotCron(spec string) error {
+	if spec == "" {
+		return nil
+	}
+
+	_, err := cron.ParseStandard(spec)
+	return err
+}
+
+// takeSnapshot walks the whole tree of store into a new version, the
+// previous version stays in use until the walk completes.
+func takeSnapshot(store storage.Storage) (err error) {
+	data := store.GetData()
+	if _, running := snapshotRunning.LoadOrStore(data.ID, true); running {
+		return errors.New("snapshot is running")
+	}
+	defer snapshotRunning.Delete(data.ID)
+
+	start := time.Now()
+	version := start.UnixNano()
+	folders, entries, err := walkSnapshot(store, version)
+
+	snap, serr := model.GetSnapshot(data.ID)
+	if serr != nil {
+		return serr
+	}
+
+	snap.StorageID = data.ID
+	if err != nil {
+		model.DeleteSnapshotVersion(data.ID, version)
+		snap.LastError = err.Error()
+		model.SaveSnapshot(snap)
+		return err
+	}
+
+	snap.Version = version
+	snap.TakenAt = start
+	snap.Folders = folders
+	snap.Entries = entries
+	snap.LastError = ""
+	err = model.SaveSnapshot(snap)
+	if err != nil {
+		return err
+	}
+
+	err = model.DeleteSnapshotEntries(data.ID, version)
+	log.Infof("snapshot %s done, folders: %d, entries: %d, cost: %s", data.MountPath, folders, entries, time.Since(start))
+	return err
+}
+
+func walkSnapshot(store storage.Storage, version int64) (folders int, entries int, err error) {
+	type folder struct {
+		info msg.Finfo
+		rel  string
+	}
+
+	data := store.GetData()
+	ctx := context.Background()
+	stack := []folder{{info: &msg.FileInfo{Path: data.MountPath, IsFolder: true}, rel: "/"}}
+	for len(stack) > 0 {
+		dir := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if inst, ok := storageMap.Load(data.MountPath); !ok || inst != store {
+			return folders, entries, errors.New("storage was unmounted")
+		}
+
+		var list []msg.Finfo
+		list, err = snapshotList(ctx, store, dir.info)
+		if err != nil {
+			return
+		}
+
+		rows := make([]model.SnapshotEntry, 0, len(list))
+		for _, v := range list {
+			rows = append(rows, model.SnapshotEntry{
+				StorageID: data.ID,
+				Version:   version,
+				Parent:    dir.rel,
+				Name:      v.GetName(),
+				FileId:    v.GetFileId(),
+				Size:      v.GetSize(),
+				Modified:  v.ModTime(),
+				IsFolder:  v.IsDir(),
+			})
+
+			if v.IsDir() {
+				stack = append(stack, folder{info: v, rel: path.Join(dir.rel, v.GetName())})
+			}
+		}
+
+		err = model.BatchCreateSnapshotEntry(rows)
+		if err != nil {
+			return
+		}
+
+		folders++
+		entries += len(rows)
+	}
+
+	return
+}
+
+// snapshotList waits for the list budget like the search crawler and gives
+// a failing folder a few more tries before the snapshot is abandoned.
+func snapshotList(ctx context.Context, store storage.Storage, info msg.Finfo) (list []msg.Finfo, err error) {
+	for i := 0; i < snapshotRetry; i++ {
+		if i > 0 {
+			time.Sleep(snapshotRetryDelay)
+		}
+
+		waitListBudget(store)
+		list, err = store.List(ctx, info)
+		if err == nil {
+			return
+		}
+
+		log.Warnf("snapshot list %s err: %+v", info.GetPath(), err)
+	}
+
+	return
+}
+
+// storageUnavailable tells if err listing store came from the storage being
+// rate limited, unreachable or not mounted, rather than from the folder.
+func storageUnavailable(store storage.Storage, err error) bool {
+	if store.GetData().Status != WORK {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.Is(err, storage.ErrRateLimited) || errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr)
+}
+
+// snapshotListFile serves rpath from the last snapshot of store, ok is false
+// when there is no snapshot or the folder isn't in it.
+func snapshotListFile(rpath string, store storage.Storage) (list []msg.Finfo, takenAt time.Time, ok bool) {
+	data := store.GetData()
+	snap, err := model.GetSnapshot(data.ID)
+	if err != nil || snap.Version == 0 {
+		return
+	}
+
+	rel := util.StandardPath(strings.TrimPrefix(rpath, data.MountPath))
+	if rel != "/" {
+		exist, err := model.HasSnapshotFolder(data.ID, snap.Version, path.Dir(rel), path.Base(rel))
+		if err != nil || !exist {
+			return
+		}
+	}
+
+	rows, err := model.GetSnapshotEntries(data.ID, snap.Version, rel)
+	if err != nil {
+		return
+	}
+
+	list = make([]msg.Finfo, 0, len(rows))
+	for _, v := range rows {
+		list = append(list, &msg.FileInfo{
+			FileId:   v.FileId,
+			Path:     path.Join(rpath, v.Name),
+			Name:     v.Name,
+			Size:     v.Size,
+			Modified: v.Modified,
+			IsFolder: v.IsFolder,
+		})
+	}
+
+	return list, snap.TakenAt, true
+}
+
+// TakeSnapshot starts a snapshot of the storage in the background.
+func TakeSnapshot(ctx context.Context, id uint) error {
+	data, err := getStorage(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	store, err := GetStorageByMountPath(data.MountPath)
+	if err != nil {
+		return err
+	}
+
+	if _, running := snapshotRunning.Load(id); running {
+		return errors.New("snapshot is running")
+	}
+
+	go func() {
+		err := takeSnapshot(store)
+		if err != nil {
+			log.Errorf("snapshot %s err: %+v", data.MountPath, err)
+		}
+	}()
+
+	Audit(ctx, "storage.snapshot", data.MountPath, nil, nil)
+	return nil
+}
+
+func ListSnapshotStatus(ctx context.Context) ([]msg.SnapshotStatus, error) {
+	storages, err := ListStorage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	snapList, err := model.GetAllSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	snapMap := make(map[uint]model.Snapshot, len(snapList))
+	for _, v := range snapList {
+		snapMap[v.StorageID] = v
+	}
+
+	now := time.Now()
+	list := make([]msg.SnapshotStatus, 0, len(storages))
+	for _, v := range storages {
+		status := msg.SnapshotStatus{
+			ID:        v.ID,
+			MountPath: v.MountPath,
+			Cron:      v.SnapshotCron,
+			Age:       -1,
+		}
+
+		if snap, ok := snapMap[v.ID]; ok {
+			status.LastError = snap.LastError
+			if snap.Version > 0 {
+				takenAt := snap.TakenAt
+				status.TakenAt = &takenAt
+				status.Age = int64(now.Sub(takenAt).Seconds())
+				status.Folders = snap.Folders
+				status.Entries = snap.Entries
+			}
+		}
+
+		_, status.Running = snapshotRunning.Load(v.ID)
+		if schedule, err := cron.ParseStandard(v.SnapshotCron); err == nil && v.SnapshotCron != "" && !v.Disabled {
+			next := schedule.Next(now)
+			status.NextRun = &next
+		}
+
+		list = append(list, status)
+	}
+
+	return list, nil
+}
@@ -7,10 +7,14 @@ import (
 	"fmt"
 	"github.com/orcaman/concurrent-map/v2"
 	"reflect"
+	"strings"
+	"overlink.top/app/internal/cache"
 	"overlink.top/app/lib/util"
 	"overlink.top/app/storage"
+	"overlink.top/app/system/conf"
 	"overlink.top/app/system/log"
 	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
 	"sync"
 	"time"
 )
@@ -36,6 +40,15 @@ func RegisterEngine(engine StorageFunc) {
 	engineFormMap.Set(instCfg.Name, storage.Form{
 		Extra: formExtra,
 	})
+
+	var secretList []string
+	for _, v := range formExtra {
+		if v.Secret {
+			secretList = append(secretList, v.Name)
+		}
+	}
+
+	model.RegisterSecretField(instCfg.Name, secretList)
 }
 
 func GetAllEngineForm() map[string]storage.Form {
@@ -65,6 +78,7 @@ func getEngineFormExtra(extra storage.ExtraItem) []storage.FormItem {
 			Options:  tag.Get("options"),
 			Required: tag.Get("required") == "true",
 			Tip:      tag.Get("tip") == "true",
+			Secret:   tag.Get("secret") == "true",
 		}
 		itemList = append(itemList, item)
 	}
@@ -110,8 +124,94 @@ func loadAllStorage() {
 
 }
 
+func reloadAllStorage() {
+	storageMap.Range(func(key, value interface{}) bool {
+		storageMap.Delete(key)
+		return true
+	})
+
+	loadAllStorage()
+}
+
+func ListStorage(ctx context.Context) ([]model.Storage, error) {
+	dataList, err := model.GetAllStorage()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]model.Storage, 0, len(dataList))
+	for _, v := range dataList {
+		if CanManageMount(ctx, v.MountPath) {
+			v.Extra = maskSecret(v.Engine, v.Extra)
+			list = append(list, v)
+		}
+	}
+
+	return list, nil
+}
+
+func GetStorage(ctx context.Context, id uint) (*model.Storage, error) {
+	data, err := getStorage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	data.Extra = maskSecret(data.Engine, data.Extra)
+	return data, nil
+}
+
+func getStorage(ctx context.Context, id uint) (*model.Storage, error) {
+	data, err := model.GetStorage(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkManageMount(ctx, data.MountPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// checkEngine keeps local storages to super admins, their root path would
+// open the host filesystem to delegated admins.
+func checkEngine(ctx context.Context, name string) error {
+	engine, err := GetEngine(name)
+	if err != nil {
+		return err
+	}
+
+	user := Identity(ctx)
+	if engine().GetConfig().Direct && (user == nil || !user.IsSuper()) {
+		return msg.ErrNoPermission
+	}
+
+	return nil
+}
+
 func MountStorage(ctx context.Context, data model.Storage) error {
 	data.MountPath = util.StandardPath(data.MountPath)
+	err := checkManageMount(ctx, data.MountPath)
+	if err != nil {
+		return err
+	}
+
+	err = checkEngine(ctx, data.Engine)
+	if err != nil {
+		return err
+	}
+
+	err = checkSnapshotCron(data.SnapshotCron)
+	if err != nil {
+		return err
+	}
+
+	err = checkDownloadMode(data.DownloadMode)
+	if err != nil {
+		return err
+	}
+
 	engine, err := GetEngine(data.Engine)
 	if err != nil {
 		return err
@@ -137,6 +237,9 @@ func MountStorage(ctx context.Context, data model.Storage) error {
 		return err
 	}
 
+	// The instance was stored before the row existed, snapshots key on the id
+	inst.GetData().ID = data.ID
+	Audit(ctx, "storage.mount", data.MountPath, nil, data)
 	return nil
 }
 
@@ -147,11 +250,42 @@ func UpdateStorage(ctx context.Context, data model.Storage) error {
 	}
 
 	data.MountPath = util.StandardPath(data.MountPath)
+	if !CanManageMount(ctx, oldData.MountPath) || !CanManageMount(ctx, data.MountPath) {
+		return msg.ErrNoPermission
+	}
+
+	err = checkEngine(ctx, oldData.Engine)
+	if err != nil {
+		return err
+	}
+
+	err = checkEngine(ctx, data.Engine)
+	if err != nil {
+		return err
+	}
+
+	err = checkSnapshotCron(data.SnapshotCron)
+	if err != nil {
+		return err
+	}
+
+	err = checkDownloadMode(data.DownloadMode)
+	if err != nil {
+		return err
+	}
+
+	if data.Engine == oldData.Engine {
+		data.Extra = unmaskSecret(data.Engine, data.Extra, oldData.Extra)
+		data.Token = oldData.Token
+	}
+
 	err = model.UpdateStorage(&data)
 	if err != nil {
 		return err
 	}
 
+	Audit(ctx, "storage.update", data.MountPath, oldData, data)
+	cache.InvalidateTree(oldData.MountPath)
 	if data.Disabled {
 		return nil
 	}
@@ -170,7 +304,7 @@ func UpdateStorage(ctx context.Context, data model.Storage) error {
 }
 
 func SwitchStorage(ctx context.Context, id uint) error {
-	data, err := model.GetStorage(id)
+	data, err := getStorage(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -200,11 +334,12 @@ func SwitchStorage(ctx context.Context, id uint) error {
 		storageMap.Delete(data.MountPath)
 	}
 
+	Audit(ctx, "storage.switch", data.MountPath, nil, map[string]bool{"disabled": data.Disabled})
 	return nil
 }
 
 func DeleteStorage(ctx context.Context, id uint) error {
-	data, err := model.GetStorage(id)
+	data, err := getStorage(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -218,9 +353,69 @@ func DeleteStorage(ctx context.Context, id uint) error {
 		return err
 	}
 
+	err = model.DeleteSnapshot(id)
+	if err != nil {
+		log.Errorf("delete snapshot of %s err: %+v", data.MountPath, err)
+	}
+
+	Audit(ctx, "storage.delete", data.MountPath, data, nil)
+	cache.InvalidateTree(data.MountPath)
 	return nil
 }
 
+// maskSecret hides the secret Extra fields of an engine from admin responses.
+func maskSecret(engine string, extra string) string {
+	obj := map[string]interface{}{}
+	if json.Unmarshal([]byte(extra), &obj) != nil {
+		return extra
+	}
+
+	for _, v := range model.GetSecretField(engine) {
+		if str, ok := obj[v].(string); ok && str != "" {
+			obj[v] = conf.SecretMask
+		}
+	}
+
+	jsonData, _ := json.Marshal(obj)
+	return string(jsonData)
+}
+
+// unmaskSecret puts the stored secrets back for fields the admin left masked.
+func unmaskSecret(engine string, extra string, oldExtra string) string {
+	obj := map[string]interface{}{}
+	oldObj := map[string]interface{}{}
+	if json.Unmarshal([]byte(extra), &obj) != nil || json.Unmarshal([]byte(oldExtra), &oldObj) != nil {
+		return extra
+	}
+
+	changed := false
+	for _, v := range model.GetSecretField(engine) {
+		if obj[v] == conf.SecretMask {
+			obj[v] = oldObj[v]
+			changed = true
+		}
+	}
+
+	if !changed {
+		return extra
+	}
+
+	jsonData, _ := json.Marshal(obj)
+	return string(jsonData)
+}
+
+func rotateStorageSecret() {
+	count, err := model.RotateStorageSecret()
+	if err != nil {
+		log.StdErrorf("rotate storage secret err: %+v", err)
+		return
+	}
+
+	if count > 0 {
+		log.StdInfof("storage secrets sealed with current key: [%d]", count)
+	}
+}
+
 func initStorage(ctx context.Context, data model.Storage, inst storage.Storage) (err error) {
 	inst.SetData(data)
 	err = json.Unmarshal([]byte(data.Extra), inst.GetExtra())
@@ -247,6 +442,28 @@ func initStorage(ctx context.Context, data model.Storage, inst storage.Storage)
 	return
 }
 
+// findStorage returns the mounted storage owning rpath, the deepest mount
+// wins when mount paths are nested.
+func findStorage(rpath string) (store storage.Storage) {
+	var matched string
+	storageMap.Range(func(key, value interface{}) bool {
+		v := value.(storage.Storage)
+		mountPath := v.GetData().MountPath
+		if isSubPath(mountPath, rpath) && len(mountPath) > len(matched) {
+			matched = mountPath
+			store = v
+		}
+
+		return true
+	})
+
+	return
+}
+
+func isSubPath(parent string, rpath string) bool {
+	return parent == "/" || rpath == parent || strings.HasPrefix(rpath, parent+"/")
+}
+
 func GetStorageByMountPath(mountPath string) (storage.Storage, error) {
 	data, ok := storageMap.Load(mountPath)
 	if !ok {
@@ -0,0 +1,418 @@
+package logic
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/msg"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Compressed backups are read whole, this bounds what they may expand to
+	// and how far back a plain file is read
+	maxLogRead   = 256 << 20
+	logBlockSize = 64 << 10
+)
+
+var (
+	logLevels    = []string{"debug", "info", "warn", "error"}
+	logLineRegex = regexp.MustCompile(`^\[(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})\]\t\[([A-Z]+)\]\t`)
+)
+
+// logLevelRank maps a level name to the values of conf.Log.Level, panic and
+// fatal rank above error.
+func logLevelRank(level string) int {
+	switch strings.ToLower(level) {
+	case "debug":
+		return -1
+	case "", "info":
+		return 0
+	case "warn":
+		return 1
+	case "error":
+		return 2
+	default:
+		return 3
+	}
+}
+
+func GetLogLevel(ctx context.Context) msg.LogLevelResp {
+	return msg.LogLevelResp{Level: logLevels[log.GetLevel()+1]}
+}
+
+// SetLogLevel switches the level of the running process, the config file
+// is left alone so a restart goes back to the configured level.
+func SetLogLevel(ctx context.Context, req msg.SetLogLevelReq) error {
+	level := strings.ToLower(req.Level)
+	valid := false
+	for _, v := range logLevels {
+		valid = valid || v == level
+	}
+
+	if !valid {
+		return errors.New("level must be one of debug, info, warn, error")
+	}
+
+	before := GetLogLevel(ctx)
+	log.SetLevel(logLevelRank(level))
+	conf.AppConf.Log.Level = logLevelRank(level)
+	Audit(ctx, "log.level", "log", before, msg.LogLevelResp{Level: level})
+	log.Infof("log level changed from %s to %s", before.Level, level)
+	return nil
+}
+
+func logPath() (string, string) {
+	return filepath.Split(conf.AbsPath(conf.AppConf.Log.Filename))
+}
+
+// ListLogFile returns the current log file followed by the backups kept by
+// lumberjack, newest first.
+func ListLogFile(ctx context.Context) ([]msg.LogFile, error) {
+	dir, base := logPath()
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []msg.LogFile{}, nil
+		}
+
+		return nil, err
+	}
+
+	var current []msg.LogFile
+	backups := []msg.LogFile{}
+	for _, v := range entries {
+		name := v.Name()
+		isBackup := strings.HasPrefix(name, prefix) && (strings.HasSuffix(name, ext) || strings.HasSuffix(name, ext+".gz"))
+		if v.IsDir() || name != base && !isBackup {
+			continue
+		}
+
+		info, err := v.Info()
+		if err != nil {
+			continue
+		}
+
+		file := msg.LogFile{Name: name, Size: info.Size(), Modified: info.ModTime()}
+		if name == base {
+			current = append(current, file)
+		} else {
+			backups = append(backups, file)
+		}
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Modified.After(backups[j].Modified)
+	})
+
+	return append(current, backups...), nil
+}
+
+// ListLog pages through one log file, newest entries first. Lines that don't
+// start an entry, such as stack traces, stay with the entry above them.
+func ListLog(ctx context.Context, req msg.ListLogReq) (resp msg.ListLogResp, err error) {
+	if req.Pagenum < 1 {
+		req.Pagenum = 1
+	}
+
+	if req.Pagesize < 1 || req.Pagesize > 500 {
+		req.Pagesize = 50
+	}
+
+	dir, base := logPath()
+	if req.File == "" {
+		req.File = base
+	}
+
+	files, err := ListLogFile(ctx)
+	if err != nil {
+		return
+	}
+
+	found := false
+	for _, v := range files {
+		found = found || v.Name == req.File
+	}
+
+	if !found {
+		err = errors.New("log file not found")
+		return
+	}
+
+	offset := (req.Pagenum - 1) * req.Pagesize
+	page := &logPage{req: req, skip: offset, want: offset + req.Pagesize}
+	fullPath := filepath.Join(dir, req.File)
+	if strings.HasSuffix(fullPath, ".gz") {
+		// Compressed backups can only be read from the start
+		page.forward = true
+		err = scanLogFile(fullPath, page.keep)
+	} else {
+		err = scanLogBackward(fullPath, page.add)
+	}
+
+	if err != nil {
+		return
+	}
+
+	resp.Pagenum = req.Pagenum
+	resp.Total, resp.More, resp.Lines = page.result()
+	return
+}
+
+// logPage collects one page of matching entries. A backward scan hands them
+// newest first to add, which stops it once an entry past the page shows there
+// are more. A forward scan hands them oldest first to keep, which holds the
+// newest want entries and counts them all.
+type logPage struct {
+	req     msg.ListLogReq
+	skip    int
+	want    int
+	forward bool
+	matched int
+	more    bool
+	lines   []msg.LogLine
+}
+
+func (self *logPage) add(line msg.LogLine) bool {
+	if !MatchLogLine(line, self.req) {
+		return true
+	}
+
+	self.matched++
+	if self.matched > self.want {
+		self.more = true
+		return false
+	}
+
+	if self.matched > self.skip {
+		self.lines = append(self.lines, line)
+	}
+
+	return true
+}
+
+func (self *logPage) keep(line msg.LogLine) {
+	if !MatchLogLine(line, self.req) {
+		return
+	}
+
+	self.matched++
+	self.lines = append(self.lines, line)
+	if len(self.lines) > self.want {
+		self.lines = self.lines[1:]
+	}
+}
+
+// result returns the total, whether entries follow the page and the page
+// itself, newest first. After a backward scan the total only counts up to
+// the first entry past the page.
+func (self *logPage) result() (int, bool, []msg.LogLine) {
+	lines := []msg.LogLine{}
+	if !self.forward {
+		return self.matched, self.more, append(lines, self.lines...)
+	}
+
+	for i := len(self.lines) - 1 - self.skip; i >= 0; i-- {
+		lines = append(lines, self.lines[i])
+	}
+
+	return self.matched, self.matched > self.want, lines
+}
+
+// MatchLogLine applies the level, keyword and time filters of req, level is
+// a minimum.
+func MatchLogLine(line msg.LogLine, req msg.ListLogReq) bool {
+	if req.Level != "" && logLevelRank(line.Level) < logLevelRank(req.Level) {
+		return false
+	}
+
+	if req.Keyword != "" && !strings.Contains(strings.ToLower(line.Text), strings.ToLower(req.Keyword)) {
+		return false
+	}
+
+	if req.Start > 0 && !line.Time.IsZero() && line.Time.Before(time.Unix(req.Start, 0)) {
+		return false
+	}
+
+	if req.End > 0 && !line.Time.IsZero() && line.Time.After(time.Unix(req.End, 0)) {
+		return false
+	}
+
+	return true
+}
+
+// scanLogFile passes the entries of a log file to fn oldest first, lines that
+// start no entry are joined to the one above.
+func scanLogFile(fullPath string, fn func(msg.LogLine)) error {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(fullPath, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		reader = gz
+	}
+
+	var line msg.LogLine
+	started := false
+	buf := bufio.NewReader(io.LimitReader(reader, maxLogRead))
+	for {
+		text, err := buf.ReadString('\n')
+		text = strings.TrimRight(text, "\r\n")
+		if text != "" {
+			if next, ok := ParseLogLine(text); ok || !started {
+				if started {
+					fn(line)
+				}
+
+				line, started = next, true
+			} else {
+				line.Text += "\n" + text
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if started {
+		fn(line)
+	}
+
+	return nil
+}
+
+// scanLogBackward passes the entries of a plain log file to fn newest first,
+// reading blocks from the end until fn returns false. The first pages of a
+// large file cost a read of its tail only.
+func scanLogBackward(fullPath string, fn func(msg.LogLine) bool) error {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Lines that start no entry, newest first, waiting for the entry above
+	var follow []string
+	emit := func(text string) bool {
+		text = strings.TrimRight(text, "\r")
+		if text == "" {
+			return true
+		}
+
+		line, ok := ParseLogLine(text)
+		if !ok {
+			follow = append(follow, text)
+			return true
+		}
+
+		for i := len(follow) - 1; i >= 0; i-- {
+			line.Text += "\n" + follow[i]
+		}
+
+		follow = follow[:0]
+		return fn(line)
+	}
+
+	pos := info.Size()
+	limit := max(pos-maxLogRead, 0)
+	var rest []byte
+	for pos > limit {
+		n := min(logBlockSize, pos-limit)
+		pos -= n
+		block := make([]byte, n, n+int64(len(rest)))
+		_, err = file.ReadAt(block, pos)
+		if err != nil {
+			return err
+		}
+
+		// The part before the first newline may continue in the block before
+		block = append(block, rest...)
+		first := bytes.IndexByte(block, '\n')
+		if first < 0 {
+			rest = block
+			continue
+		}
+
+		rest = block[:first]
+		lines := strings.Split(string(block[first+1:]), "\n")
+		for i := len(lines) - 1; i >= 0; i-- {
+			if !emit(lines[i]) {
+				return nil
+			}
+		}
+	}
+
+	if !emit(string(rest)) || len(follow) == 0 {
+		return nil
+	}
+
+	// The file starts mid entry, what is left forms one like a forward read
+	line, _ := ParseLogLine(follow[len(follow)-1])
+	for i := len(follow) - 2; i >= 0; i-- {
+		line.Text += "\n" + follow[i]
+	}
+
+	fn(line)
+	return nil
+}
+
+// ParseLogLine reads the time and level from a line written by either the
+// console or the JSON encoder, ok is false when the line starts no entry.
+func ParseLogLine(text string) (line msg.LogLine, ok bool) {
+	line.Text = strings.TrimRight(text, "\r\n")
+	line.Level = "info"
+	if m := logLineRegex.FindStringSubmatch(line.Text); m != nil {
+		line.Time, _ = time.ParseInLocation("2006-01-02 15:04:05", m[1], time.Local)
+		line.Level = strings.ToLower(m[2])
+		return line, true
+	}
+
+	if strings.HasPrefix(line.Text, "{") {
+		var entry struct {
+			Level string `json:"level"`
+			Ts    string `json:"ts"`
+		}
+
+		first, _, _ := strings.Cut(line.Text, "\n")
+		if json.Unmarshal([]byte(first), &entry) == nil && entry.Level != "" {
+			line.Time, _ = time.Parse("2006-01-02T15:04:05.000Z0700", entry.Ts)
+			line.Level = strings.ToLower(entry.Level)
+			return line, true
+		}
+	}
+
+	return line, false
+}
@@ -0,0 +1,125 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"overlink.top/app/internal/thumb"
+	"overlink.top/app/storage"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/msg"
+	"path"
+	"strconv"
+)
+
+func initThumb() {
+	err := thumb.Init(conf.AppConf.Thumb)
+	if err != nil {
+		log.StdErrorf("init thumbnail err: %+v", err)
+		return
+	}
+
+	if !conf.AppConf.Thumb.Disable && thumb.Ffmpeg() == "" {
+		log.StdInfof("ffmpeg not found, video thumbnails and WebP output are off")
+	}
+}
+
+// Thumb serves a thumbnail of rpath, size and format come from the query.
+// Pictures are decoded in process, videos need ffmpeg.
+func Thumb(r *http.Request, w http.ResponseWriter, rpath string) {
+	ctx := r.Context()
+	rpath = RealPath(ctx, rpath)
+	store := findStorage(rpath)
+	if store == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "no such file:", rpath)
+		return
+	}
+
+	if !CheckAcl(ctx, rpath, conf.ActionRead) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "no permission:", rpath)
+		return
+	}
+
+	info, err := getFile(ctx, rpath)
+	if err != nil || info.IsDir() {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "file not found:", rpath)
+		return
+	}
+
+	size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+	size = thumb.Size(size)
+	format := r.URL.Query().Get("format")
+
+	var file string
+	switch getPreviewType(info.GetName()) {
+	case conf.Picture:
+		file, err = thumb.Image(ctx, rpath, info.ModTime(), size, format, func(w *thumb.LimitWriter) error {
+			return store.StreamFile(ctx, rpath, w)
+		})
+	case conf.Video:
+		file, err = thumb.Video(ctx, rpath, info.ModTime(), size, format, func() (string, error) {
+			return thumbInput(ctx, rpath, info, store)
+		})
+	default:
+		err = thumb.ErrUnsupported
+	}
+
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, thumb.ErrUnsupported):
+			status = http.StatusUnsupportedMediaType
+		case errors.Is(err, thumb.ErrTooLarge):
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(err, thumb.ErrNoFfmpeg), errors.Is(err, thumb.ErrDisabled):
+			status = http.StatusNotImplemented
+		default:
+			log.Ctx(ctx).Errorf("thumbnail %s err: %+v", rpath, err)
+		}
+
+		w.WriteHeader(status)
+		fmt.Fprint(w, "thumbnail error:", err)
+		return
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "open thumbnail error:", err)
+		return
+	}
+	defer f.Close()
+
+	contentType := "image/jpeg"
+	if path.Ext(file) == ".webp" {
+		contentType = "image/webp"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, path.Base(file), info.ModTime(), f)
+}
+
+// thumbInput gives ffmpeg a local path for direct storages and the download
+// link otherwise, ffmpeg then only fetches the ranges it needs.
+func thumbInput(ctx context.Context, rpath string, info msg.Finfo, store storage.Storage) (string, error) {
+	var linkInfo *msg.LinkInfo
+	var err error
+	if useCache(store) {
+		linkInfo, err = cacheFileLink(ctx, info, store)
+	} else {
+		linkInfo, err = store.Link(ctx, &msg.FileInfo{Path: rpath})
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return linkInfo.Url, nil
+}
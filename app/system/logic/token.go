@@ -0,0 +1,130 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"overlink.top/app/lib/util"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+	"strings"
+	"time"
+)
+
+// Avoid a database write on every request, last use is only tracked to the minute
+const touchInterval = time.Minute
+
+var allScopes = []string{conf.ScopeRead, conf.ScopeWebdav, conf.ScopeAdmin}
+
+func IsApiToken(token string) bool {
+	return strings.HasPrefix(token, conf.ApiTokenPrefix)
+}
+
+func ListApiToken(c *gin.Context) ([]model.ApiToken, error) {
+	user := c.MustGet("identity").(*model.User)
+	return model.GetApiTokenListByUser(user.ID)
+}
+
+func CreateApiToken(c *gin.Context, req msg.CreateTokenReq) (resp msg.CreateTokenResp, err error) {
+	user := c.MustGet("identity").(*model.User)
+	if req.Name == "" {
+		err = fmt.Errorf("token name required")
+		return
+	}
+
+	scopes := findIntersection(allScopes, req.Scopes)
+	if len(scopes) == 0 {
+		err = fmt.Errorf("at least one scope required")
+		return
+	}
+
+	// Admin scope is of use to super and delegated admins only
+	if !user.IsSuper() && !hasAnyPerm(user) {
+		scopes = findIntersection([]string{conf.ScopeRead, conf.ScopeWebdav}, scopes)
+	}
+
+	plain := conf.ApiTokenPrefix + util.GenSecureStr(40)
+	data := model.ApiToken{
+		UserID: user.ID,
+		Name:   req.Name,
+		Prefix: plain[:len(conf.ApiTokenPrefix)+6],
+		Hash:   util.ToSHA256(plain),
+		Scopes: toString(scopes),
+	}
+
+	if req.ExpireDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpireDays)
+		data.ExpiresAt = &expiresAt
+	}
+
+	err = model.CreateApiToken(&data)
+	if err != nil {
+		return
+	}
+
+	resp.ApiToken = data
+	resp.Token = plain
+	return
+}
+
+func RevokeApiToken(c *gin.Context, id uint) error {
+	user := c.MustGet("identity").(*model.User)
+	data, err := model.GetApiToken(id)
+	if err != nil {
+		return err
+	}
+
+	if data.UserID != user.ID && !user.IsSuper() {
+		return fmt.Errorf("no permission")
+	}
+
+	return model.DeleteApiToken(id)
+}
+
+// VerifyApiToken resolves a personal token to its owner. The token must carry
+// the requested scope, admin scope implies read.
+func VerifyApiToken(ctx context.Context, ip string, token string, scope string) (*model.User, error) {
+	data, err := model.GetApiTokenByHash(util.ToSHA256(token))
+	if err != nil {
+		return nil, err
+	}
+
+	if data.ID == 0 {
+		return nil, msg.ErrTokenInvalid
+	}
+
+	if data.IsExpired() {
+		return nil, msg.ErrTokenExpired
+	}
+
+	if !hasScope(data.Scopes, scope) {
+		return nil, msg.ErrTokenScope
+	}
+
+	user, err := model.GetUser(data.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Enable {
+		return nil, fmt.Errorf("user disabled")
+	}
+
+	now := time.Now()
+	if data.LastUsedAt == nil || now.Sub(*data.LastUsedAt) > touchInterval || data.LastUsedIp != ip {
+		model.TouchApiToken(data.ID, ip, now)
+	}
+
+	return user, nil
+}
+
+func hasScope(scopes string, scope string) bool {
+	for _, v := range strings.Split(scopes, ",") {
+		if v == scope || (v == conf.ScopeAdmin && scope == conf.ScopeRead) {
+			return true
+		}
+	}
+
+	return false
+}
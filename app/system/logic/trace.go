@@ -0,0 +1,35 @@
+package logic
+
+import (
+	"context"
+	"overlink.top/app/internal/tracing"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+)
+
+var shutdownTracing func(ctx context.Context) error
+
+func initTracing() {
+	var err error
+	shutdownTracing, err = tracing.Init(conf.AppConf.Trace)
+	if err != nil {
+		log.StdErrorf("init tracing err: %+v", err)
+		return
+	}
+
+	if conf.AppConf.Trace.Enable {
+		log.StdInfof("tracing export to %s", conf.AppConf.Trace.Endpoint)
+	}
+}
+
+// flushTracing sends the spans still waiting in the batch before exit.
+func flushTracing(ctx context.Context) {
+	if shutdownTracing == nil {
+		return
+	}
+
+	err := shutdownTracing(ctx)
+	if err != nil {
+		log.StdErrorf("shutdown tracing err: %+v", err)
+	}
+}
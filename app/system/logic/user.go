@@ -48,23 +48,25 @@ func checkDefaultUser() {
 }
 
 func Auth(c *gin.Context, username string, password string) (resp msg.LoginResp, err error) {
-	user, err := model.GetUserByName(username)
+	user, err := AuthUser(c, username, password)
 	if err != nil {
 		return
 	}
 
-	if user.ID == 0 {
-		err = msg.ErrAuthAccount
+	token, err := jwt.GenToken(user.Username, user.PwdStamp)
+	if err != nil {
 		return
 	}
 
-	mdStr := util.ToMD5(password + user.Salt)
-	if mdStr != user.EncryptPwd {
-		err = msg.ErrAuthAccount
-		return
-	}
+	resp.Token = token
+	resp.Username = user.Username
+	return
+}
 
-	token, err := jwt.GenToken(user.Username, user.PwdStamp)
+// AuthUser checks the credentials against every authenticator and records
+// the login, it is what Basic auth uses as no JWT is needed there.
+func AuthUser(c *gin.Context, username string, password string) (user *model.User, err error) {
+	user, err = authenticate(c, username, password)
 	if err != nil {
 		return
 	}
@@ -72,16 +74,22 @@ func Auth(c *gin.Context, username string, password string) (resp msg.LoginResp,
 	user.LoginIp = util.ClientIPSimple(c.Request)
 	user.LoginTime = time.Now()
 	model.UpdateUser(user)
-
-	resp.Token = token
-	resp.Username = user.Username
 	return
 }
 
 func ResetPwd(c *gin.Context, password string) (err error) {
 	user := c.MustGet("identity").(*model.User)
+	if !user.IsLocal() {
+		return fmt.Errorf("password is managed by %s", user.Source)
+	}
+
 	user.Salt, user.EncryptPwd = encryptPassword(password)
 	err = model.UpdateUser(user)
+	if err != nil {
+		return
+	}
+
+	Audit(c, "user.password", user.Username, nil, nil)
 	return
 }
 
@@ -109,7 +117,7 @@ func EnableUser(ctx context.Context, req model.User) (err error) {
 		return
 	}
 
-	if user.Role == conf.SuperAdmin {
+	if user.Role == conf.SuperAdmin && user.IsLocal() {
 		return fmt.Errorf("super user cannot be disabled")
 	}
 
@@ -119,6 +127,11 @@ func EnableUser(ctx context.Context, req model.User) (err error) {
 
 	user.Enable = req.Enable
 	err = model.UpdateUser(user)
+	if err != nil {
+		return
+	}
+
+	Audit(ctx, "user.enable", user.Username, nil, map[string]bool{"enable": user.Enable})
 	return
 }
 
@@ -141,8 +154,17 @@ func AddUser(ctx context.Context, req model.User) (err error) {
 		Role:       conf.Viewer,
 		Enable:     req.Enable,
 		Perm:       req.Perm,
+		RoleID:     req.RoleID,
+		Source:     conf.SourceLocal,
+		BasePath:   util.StandardPath(req.BasePath),
 	}
 	err = model.CreateUser(&data)
+	if err != nil {
+		return
+	}
+
+	loadAllBasePath()
+	Audit(ctx, "user.add", data.Username, nil, data)
 	return
 }
 
@@ -152,7 +174,8 @@ func UpdateUser(ctx context.Context, req model.User) (err error) {
 		return
 	}
 
-	isSame := true
+	before := *user
+	isSame, pwdChanged := true, false
 	if req.Username != user.Username {
 		data, err := model.GetUserByName(req.Username)
 		if err != nil {
@@ -167,9 +190,10 @@ func UpdateUser(ctx context.Context, req model.User) (err error) {
 		isSame = false
 	}
 
-	if req.Password != "" && util.ToMD5(req.Password+user.Salt) != user.EncryptPwd {
+	if req.Password != "" && user.IsLocal() && util.ToMD5(req.Password+user.Salt) != user.EncryptPwd {
 		user.Salt, user.EncryptPwd = encryptPassword(req.Password)
 		user.PwdStamp = time.Now().UnixNano()
+		pwdChanged = true
 		isSame = false
 	}
 
@@ -183,11 +207,32 @@ func UpdateUser(ctx context.Context, req model.User) (err error) {
 		isSame = false
 	}
 
+	// Left out of the request keeps the jail, "/" lifts it
+	if req.BasePath != "" && util.StandardPath(req.BasePath) != user.BasePath {
+		user.BasePath = util.StandardPath(req.BasePath)
+		isSame = false
+	}
+
+	if req.RoleID != user.RoleID {
+		user.RoleID = req.RoleID
+		isSame = false
+	}
+
 	if isSame {
 		return
 	}
 
 	err = model.UpdateUser(user)
+	if err != nil {
+		return
+	}
+
+	loadAllBasePath()
+	Audit(ctx, "user.update", user.Username, before, user)
+	if pwdChanged {
+		Audit(ctx, "user.password", user.Username, nil, nil)
+	}
+
 	return
 }
 
@@ -197,11 +242,29 @@ func DeleteUser(ctx context.Context, req model.User) (err error) {
 		return
 	}
 
-	if user.Role != conf.Viewer {
+	if user.Role != conf.Viewer && user.IsLocal() {
 		return fmt.Errorf("default user cannot be deleted")
 	}
 
 	err = model.DeleteUser(user.ID)
+	if err != nil {
+		return
+	}
+
+	err = model.DeleteApiTokenByUser(user.ID)
+	if err != nil {
+		return
+	}
+
+	err = model.DeleteUserGroupByUser(user.ID)
+	if err != nil {
+		return
+	}
+
+	err = model.DeleteAclBySubject(conf.SubjectUser, user.ID)
+	loadAllAcl()
+	loadAllBasePath()
+	Audit(ctx, "user.delete", user.Username, user, nil)
 	return
 }
 
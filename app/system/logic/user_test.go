@@ -0,0 +1,46 @@
+package logic
+
+import (
+	"context"
+	"overlink.top/app/system/model"
+	"testing"
+)
+
+func TestUpdateUserKeepsBasePath(t *testing.T) {
+	ctx := context.Background()
+	err := AddUser(ctx, model.User{Username: "jailed", Password: "pwd", Enable: true, BasePath: "/home/jailed"})
+	if err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+
+	user, err := model.GetUserByName("jailed")
+	if err != nil {
+		t.Fatalf("GetUserByName: %v", err)
+	}
+	t.Cleanup(func() { model.DeleteUser(user.ID) })
+
+	// A client renaming the user does not send base_path back
+	err = UpdateUser(ctx, model.User{ID: user.ID, Username: "jailed2", Enable: true})
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	user, err = model.GetUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+
+	if user.Username != "jailed2" || user.BasePath != "/home/jailed" {
+		t.Errorf("after partial update user = %s base %q, want jailed2 base /home/jailed", user.Username, user.BasePath)
+	}
+
+	err = UpdateUser(ctx, model.User{ID: user.ID, Username: "jailed2", Enable: true, BasePath: "/"})
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	user, _ = model.GetUser(user.ID)
+	if user.BasePath != "/" {
+		t.Errorf("base path = %q after lifting the jail, want /", user.BasePath)
+	}
+}
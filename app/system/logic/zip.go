@@ -0,0 +1,239 @@
+package logic
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"overlink.top/app/internal/archive"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/msg"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Already compressed formats are stored as is even when deflate is asked
+var storedExts = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".7z": true, ".rar": true, ".xz": true, ".bz2": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true,
+	".mp4": true, ".mkv": true, ".mov": true, ".avi": true, ".webm": true, ".mp3": true, ".flac": true, ".aac": true,
+}
+
+type zipEntry struct {
+	name  string
+	rpath string
+	info  msg.Finfo
+}
+
+type zipPlan struct {
+	ctx        context.Context
+	passwords  map[string]string
+	entries    []zipEntry
+	total      int64
+	maxSize    int64
+	maxEntries int
+}
+
+// ArchiveFile streams req.Paths as one zip. Everything is listed before the
+// first byte is written so permission and size errors can still be reported,
+// errors while streaming abort the response and leave a truncated zip.
+func ArchiveFile(ctx context.Context, w http.ResponseWriter, req msg.ArchiveFileReq) error {
+	if len(req.Paths) == 0 {
+		return errors.New("no path to archive")
+	}
+
+	method := zip.Deflate
+	switch req.Method {
+	case "", "deflate":
+	case "store":
+		method = zip.Store
+	default:
+		return fmt.Errorf("unknown method: %s", req.Method)
+	}
+
+	plan := &zipPlan{
+		ctx:        ctx,
+		passwords:  req.Passwords,
+		maxSize:    int64(conf.AppConf.Zip.MaxSize) << 20,
+		maxEntries: conf.AppConf.Zip.MaxEntries,
+	}
+
+	if plan.maxSize <= 0 {
+		plan.maxSize = 4096 << 20
+	}
+
+	if plan.maxEntries <= 0 {
+		plan.maxEntries = 10000
+	}
+
+	used := map[string]bool{}
+	for _, v := range req.Paths {
+		rpath := RealPath(ctx, v)
+		info, err := getFile(ctx, rpath)
+		if err != nil {
+			return err
+		}
+
+		if !folderUnlocked(ctx, path.Dir(rpath), req.Passwords) {
+			return msg.ErrAccessPwd
+		}
+
+		name := uniqueName(used, info.GetName())
+		if !info.IsDir() {
+			err = plan.add(zipEntry{name: name, rpath: rpath, info: info})
+		} else if !folderUnlocked(ctx, rpath, req.Passwords) {
+			err = msg.ErrAccessPwd
+		} else {
+			err = plan.walk(v, name, info)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	filename := req.Name
+	if filename == "" {
+		filename = path.Base(RealPath(ctx, req.Paths[0]))
+		if len(req.Paths) > 1 || filename == "/" {
+			filename = "download"
+		}
+	}
+
+	filename += ".zip"
+	Audit(ctx, "file.archive", strings.Join(req.Paths, ","), nil, nil)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, filename, url.QueryEscape(filename)))
+	w.Header().Set("Content-Type", "application/zip")
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, flate.BestSpeed)
+	})
+
+	for _, v := range plan.entries {
+		fh := &zip.FileHeader{
+			Name:     v.name,
+			Modified: v.info.ModTime(),
+			Method:   method,
+		}
+
+		if v.info.IsDir() {
+			fh.Name += "/"
+			fh.Method = zip.Store
+		} else if storedExts[strings.ToLower(path.Ext(v.name))] {
+			fh.Method = zip.Store
+		}
+
+		fw, err := zw.CreateHeader(fh)
+		if err == nil && !v.info.IsDir() {
+			err = streamFile(ctx, v.rpath, fw)
+		}
+
+		if err != nil {
+			if isClientDisconnectError(err) {
+				log.Ctx(ctx).Debugf("client disconnected during zip streaming: %s", v.rpath)
+			} else {
+				log.Ctx(ctx).Errorf("zip streaming %s error: %v", v.rpath, err)
+			}
+
+			return nil
+		}
+	}
+
+	err := zw.Close()
+	if err != nil && !isClientDisconnectError(err) {
+		log.Ctx(ctx).Errorf("zip streaming error: %v", err)
+	}
+
+	return nil
+}
+
+func (self *zipPlan) add(e zipEntry) error {
+	self.entries = append(self.entries, e)
+	self.total += e.info.GetSize()
+	if self.total > self.maxSize || len(self.entries) > self.maxEntries {
+		return msg.ErrTooLarge
+	}
+
+	return nil
+}
+
+// walk adds a folder and what it holds, subfolders and files the user may
+// not read or hasn't unlocked are left out.
+func (self *zipPlan) walk(vpath string, name string, info msg.Finfo) error {
+	err := self.add(zipEntry{name: name, info: info})
+	if err != nil {
+		return err
+	}
+
+	list, err := ListFile(self.ctx, vpath)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range list {
+		rpath := RealPath(self.ctx, v.GetPath())
+		if !CheckAcl(self.ctx, rpath, conf.ActionRead) {
+			continue
+		}
+
+		child := name + "/" + v.GetName()
+		if !v.IsDir() {
+			err = self.add(zipEntry{name: child, rpath: rpath, info: v})
+		} else if folderUnlocked(self.ctx, rpath, self.passwords) {
+			err = self.walk(v.GetPath(), child, v)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func uniqueName(used map[string]bool, name string) string {
+	if name == "" || name == "/" {
+		name = "root"
+	}
+
+	result := name
+	ext := path.Ext(name)
+	for i := 1; used[result]; i++ {
+		result = strings.TrimSuffix(name, ext) + " (" + strconv.Itoa(i) + ")" + ext
+	}
+
+	used[result] = true
+	return result
+}
+
+// streamFile writes the content of rpath, entries inside archives included.
+func streamFile(ctx context.Context, rpath string, w io.Writer) error {
+	if apath, inner, ok := archive.Split(rpath); ok && inner != "" {
+		index, err := loadArchive(ctx, apath)
+		if err != errNotArchive {
+			if err != nil {
+				return err
+			}
+
+			e, err := index.Get(inner)
+			if err != nil {
+				return err
+			}
+
+			return index.Extract(ctx, e, w)
+		}
+	}
+
+	store := findStorage(rpath)
+	if store == nil {
+		return errors.New("no such file:" + rpath)
+	}
+
+	return store.StreamFile(ctx, rpath, w)
+}
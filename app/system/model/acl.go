@@ -0,0 +1,41 @@
+package model
+
+type Acl struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	MountPath string `json:"mount_path" gorm:"index"`
+	Subject   string `json:"subject"`
+	SubjectID uint   `json:"subject_id"`
+	Action    string `json:"action"`
+	Effect    string `json:"effect"`
+}
+
+func GetAllAcl() ([]Acl, error) {
+	var dataList []Acl
+	err := db.Find(&dataList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return dataList, nil
+}
+
+func GetAcl(id uint) (*Acl, error) {
+	var data Acl
+	if err := db.First(&data, id).Error; err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func CreateAcl(data *Acl) error {
+	return db.Create(data).Error
+}
+
+func DeleteAcl(id uint) error {
+	return db.Delete(&Acl{}, id).Error
+}
+
+func DeleteAclBySubject(subject string, subjectId uint) error {
+	return db.Where("subject = ? AND subject_id = ?", subject, subjectId).Delete(&Acl{}).Error
+}
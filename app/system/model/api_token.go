@@ -0,0 +1,69 @@
+package model
+
+import (
+	"time"
+)
+
+type ApiToken struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"user_id" gorm:"index"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"-" gorm:"unique"`
+	Scopes     string     `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIp string     `json:"last_used_ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (self *ApiToken) IsExpired() bool {
+	return self.ExpiresAt != nil && time.Now().After(*self.ExpiresAt)
+}
+
+func GetApiTokenListByUser(userId uint) ([]ApiToken, error) {
+	var dataList []ApiToken
+	err := db.Where("user_id = ?", userId).Order("id desc").Find(&dataList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return dataList, nil
+}
+
+func GetApiTokenByHash(hash string) (*ApiToken, error) {
+	var data ApiToken
+	if err := db.Where("hash = ?", hash).Limit(1).Find(&data).Error; err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func GetApiToken(id uint) (*ApiToken, error) {
+	var data ApiToken
+	if err := db.First(&data, id).Error; err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func CreateApiToken(data *ApiToken) error {
+	return db.Create(data).Error
+}
+
+func TouchApiToken(id uint, ip string, usedAt time.Time) error {
+	return db.Model(&ApiToken{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"last_used_at": usedAt,
+		"last_used_ip": ip,
+	}).Error
+}
+
+func DeleteApiToken(id uint) error {
+	return db.Delete(&ApiToken{}, id).Error
+}
+
+func DeleteApiTokenByUser(userId uint) error {
+	return db.Where("user_id = ?", userId).Delete(&ApiToken{}).Error
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// Escapes the LIKE wildcards users type, '!' works the same on sqlite and
+// MySQL where a backslash would need quoting differently
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+type AuditLog struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+	ActorID   uint      `json:"actor_id"`
+	Actor     string    `json:"actor" gorm:"index"`
+	Ip        string    `json:"ip"`
+	Action    string    `json:"action" gorm:"index"`
+	Target    string    `json:"target"`
+	Before    string    `json:"before"`
+	After     string    `json:"after"`
+}
+
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Start  time.Time
+	End    time.Time
+}
+
+func (self AuditFilter) apply() *gorm.DB {
+	tx := db.Model(&AuditLog{})
+	if self.Actor != "" {
+		tx = tx.Where("actor = ?", self.Actor)
+	}
+
+	if self.Action != "" {
+		tx = tx.Where("action LIKE ? ESCAPE '!'", likeEscaper.Replace(self.Action)+"%")
+	}
+
+	if self.Target != "" {
+		tx = tx.Where("target LIKE ? ESCAPE '!'", "%"+likeEscaper.Replace(self.Target)+"%")
+	}
+
+	if !self.Start.IsZero() {
+		tx = tx.Where("created_at >= ?", self.Start)
+	}
+
+	if !self.End.IsZero() {
+		tx = tx.Where("created_at < ?", self.End)
+	}
+
+	return tx
+}
+
+func GetAuditListByFilter(filter AuditFilter, limit int, offset int) ([]AuditLog, error) {
+	var dataList []AuditLog
+	err := filter.apply().Order("id desc").Limit(limit).Offset(offset).Find(&dataList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return dataList, nil
+}
+
+func CountAuditByFilter(filter AuditFilter) (int, error) {
+	var count int64
+	err := filter.apply().Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return int(count), nil
+}
+
+func BatchCreateAudit(data []*AuditLog) error {
+	return db.Create(data).Error
+}
+
+func DeleteAuditBefore(t time.Time) (int64, error) {
+	tx := db.Where("created_at < ?", t).Delete(&AuditLog{})
+	return tx.RowsAffected, tx.Error
+}
@@ -0,0 +1,182 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"overlink.top/app/system/conf"
+)
+
+// Bundle holds every configuration table a backup carries. IDs inside are the
+// ones of the exporting instance and get remapped on import.
+type Bundle struct {
+	Users       []User
+	Storages    []Storage
+	Folders     []FolderSetting
+	Preferences []Preference
+	Roles       []Role
+	Groups      []Group
+	Members     []UserGroup
+	Acls        []Acl
+}
+
+func ExportBundle() (*Bundle, error) {
+	var data Bundle
+	for _, v := range []interface{}{&data.Users, &data.Storages, &data.Folders, &data.Preferences,
+		&data.Roles, &data.Groups, &data.Members, &data.Acls} {
+		if err := db.Find(v).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &data, nil
+}
+
+// ImportBundle writes the bundle in one transaction. Rows are matched by their
+// natural key (username, mount path, folder, name...) and overwritten, replace
+// empties the tables first. Personal tokens are dropped on replace as their
+// owners may end up with different ids. Storages whose mount path is imported
+// again keep their id on replace so their snapshots stay theirs, snapshots of
+// the other storages are deleted.
+func ImportBundle(data *Bundle, replace bool) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		storageIds := map[string]uint{}
+		if replace {
+			var storageList []Storage
+			if err := tx.Find(&storageList).Error; err != nil {
+				return err
+			}
+
+			for _, v := range storageList {
+				storageIds[v.MountPath] = v.ID
+			}
+
+			for _, v := range []interface{}{&User{}, &Storage{}, &FolderSetting{}, &Preference{},
+				&Role{}, &Group{}, &UserGroup{}, &Acl{}, &ApiToken{}} {
+				if err := tx.Where("1 = 1").Delete(v).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		roleIds := map[uint]uint{}
+		for _, v := range data.Roles {
+			oldId := v.ID
+			if err := saveByKey(tx, &v, &v.ID, "name = ?", v.Name); err != nil {
+				return err
+			}
+
+			roleIds[oldId] = v.ID
+		}
+
+		userIds := map[uint]uint{}
+		for _, v := range data.Users {
+			oldId := v.ID
+			v.RoleID = roleIds[v.RoleID]
+			if err := saveByKey(tx, &v, &v.ID, "username = ?", v.Username); err != nil {
+				return err
+			}
+
+			userIds[oldId] = v.ID
+		}
+
+		groupIds := map[uint]uint{}
+		for _, v := range data.Groups {
+			oldId := v.ID
+			if err := saveByKey(tx, &v, &v.ID, "name = ?", v.Name); err != nil {
+				return err
+			}
+
+			groupIds[oldId] = v.ID
+		}
+
+		for _, v := range data.Members {
+			v.UserID, v.GroupID = userIds[v.UserID], groupIds[v.GroupID]
+			if v.UserID == 0 || v.GroupID == 0 {
+				continue
+			}
+
+			if err := tx.Where(&v).FirstOrCreate(&v).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, v := range data.Acls {
+			if v.Subject == conf.SubjectUser {
+				v.SubjectID = userIds[v.SubjectID]
+			} else {
+				v.SubjectID = groupIds[v.SubjectID]
+			}
+
+			if v.SubjectID == 0 {
+				continue
+			}
+
+			err := saveByKey(tx, &v, &v.ID, "mount_path = ? AND subject = ? AND subject_id = ? AND action = ?",
+				v.MountPath, v.Subject, v.SubjectID, v.Action)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Kept ids go in first, fresh rows could otherwise be given one of them
+		for _, v := range data.Storages {
+			if id, ok := storageIds[v.MountPath]; ok {
+				v.ID = id
+				if err := tx.Create(&v).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, v := range data.Storages {
+			if _, ok := storageIds[v.MountPath]; ok {
+				continue
+			}
+
+			if err := saveByKey(tx, &v, &v.ID, "mount_path = ?", v.MountPath); err != nil {
+				return err
+			}
+		}
+
+		if replace {
+			kept := tx.Model(&Storage{}).Select("id")
+			for _, v := range []interface{}{&Snapshot{}, &SnapshotEntry{}} {
+				if err := tx.Where("storage_id NOT IN (?)", kept).Delete(v).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, v := range data.Folders {
+			if err := saveByKey(tx, &v, &v.ID, "folder = ?", v.Folder); err != nil {
+				return err
+			}
+		}
+
+		for _, v := range data.Preferences {
+			if err := tx.Save(&v).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// saveByKey points the row at the existing record matching the query, or at
+// a fresh id, before saving it.
+func saveByKey(tx *gorm.DB, data interface{}, id *uint, query string, args ...interface{}) error {
+	var idList []uint
+	*id = 0
+	err := tx.Model(data).Where(query, args...).Limit(1).Pluck("id", &idList).Error
+	if err != nil {
+		return err
+	}
+
+	if len(idList) == 0 {
+		return tx.Create(data).Error
+	}
+
+	*id = idList[0]
+
+	return tx.Save(data).Error
+}
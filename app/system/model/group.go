@@ -0,0 +1,86 @@
+package model
+
+type Group struct {
+	ID     uint   `json:"id" gorm:"primaryKey"`
+	Name   string `json:"name" gorm:"unique"`
+	Remark string `json:"remark"`
+}
+
+type UserGroup struct {
+	UserID  uint `json:"user_id" gorm:"primaryKey"`
+	GroupID uint `json:"group_id" gorm:"primaryKey"`
+}
+
+func GetAllGroup() ([]Group, error) {
+	var dataList []Group
+	err := db.Find(&dataList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return dataList, nil
+}
+
+func GetGroup(id uint) (*Group, error) {
+	var data Group
+	if err := db.First(&data, id).Error; err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func CreateGroup(data *Group) error {
+	return db.Create(data).Error
+}
+
+func UpdateGroup(data *Group) error {
+	return db.Save(&data).Error
+}
+
+func DeleteGroup(id uint) error {
+	err := db.Where("group_id = ?", id).Delete(&UserGroup{}).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Delete(&Group{}, id).Error
+}
+
+func GetAllUserGroup() ([]UserGroup, error) {
+	var dataList []UserGroup
+	err := db.Find(&dataList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return dataList, nil
+}
+
+func GetGroupMember(groupId uint) ([]uint, error) {
+	var idList []uint
+	err := db.Model(&UserGroup{}).Where("group_id = ?", groupId).Pluck("user_id", &idList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return idList, nil
+}
+
+func SetGroupMember(groupId uint, userIds []uint) error {
+	err := db.Where("group_id = ?", groupId).Delete(&UserGroup{}).Error
+	if err != nil || len(userIds) == 0 {
+		return err
+	}
+
+	dataList := make([]UserGroup, 0, len(userIds))
+	for _, v := range userIds {
+		dataList = append(dataList, UserGroup{UserID: v, GroupID: groupId})
+	}
+
+	return db.Create(&dataList).Error
+}
+
+func DeleteUserGroupByUser(userId uint) error {
+	return db.Where("user_id = ?", userId).Delete(&UserGroup{}).Error
+}
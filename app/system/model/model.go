@@ -90,7 +90,9 @@ func InitDb(cfg conf.Database) {
 	}
 
 	// Migrate the schema
-	db.AutoMigrate(&User{}, &Storage{}, &FolderSetting{}, &Preference{})
+	db.AutoMigrate(&User{}, &Storage{}, &FolderSetting{}, &Preference{}, &ApiToken{},
+		&Role{}, &Group{}, &UserGroup{}, &Acl{}, &AuditLog{},
+		&Snapshot{}, &SnapshotEntry{})
 }
 
 func checkDbDir(pathStr string) {
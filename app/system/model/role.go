@@ -0,0 +1,45 @@
+package model
+
+type Role struct {
+	ID     uint   `json:"id" gorm:"primaryKey"`
+	Name   string `json:"name" gorm:"unique"`
+	Perms  string `json:"perms"`
+	Mounts string `json:"mounts"`
+	Remark string `json:"remark"`
+}
+
+func GetAllRole() ([]Role, error) {
+	var dataList []Role
+	err := db.Find(&dataList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return dataList, nil
+}
+
+func GetRole(id uint) (*Role, error) {
+	var data Role
+	if err := db.First(&data, id).Error; err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func CreateRole(data *Role) error {
+	return db.Create(data).Error
+}
+
+func UpdateRole(data *Role) error {
+	return db.Save(&data).Error
+}
+
+func DeleteRole(id uint) error {
+	err := db.Model(&User{}).Where("role_id = ?", id).Update("role_id", 0).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Delete(&Role{}, id).Error
+}
@@ -0,0 +1,237 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm/schema"
+	"overlink.top/app/lib/util"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const secretPrefix = "enc:"
+
+var secretFieldMap sync.Map
+
+func init() {
+	schema.RegisterSerializer("secret", SecretSerializer{})
+	schema.RegisterSerializer("secretjson", SecretJsonSerializer{})
+}
+
+// RegisterSecretField records which keys of an engine Extra are encrypted.
+func RegisterSecretField(engine string, names []string) {
+	secretFieldMap.Store(engine, names)
+}
+
+func GetSecretField(engine string) []string {
+	if data, ok := secretFieldMap.Load(engine); ok {
+		return data.([]string)
+	}
+
+	return nil
+}
+
+type secretKey struct {
+	id  string
+	key []byte
+}
+
+// secretKeys returns the current key first followed by the retired ones.
+// Without a dedicated secret_key the jwt secret is used.
+func secretKeys() []secretKey {
+	current := conf.AppConf.Secure.SecretKey
+	if current == "" {
+		current = conf.AppConf.Secure.JwtSecret
+	}
+
+	var keys []secretKey
+	for _, v := range append([]string{current}, conf.AppConf.Secure.OldSecretKeys...) {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		hash := sha256.Sum256([]byte(v))
+		keys = append(keys, secretKey{id: hex.EncodeToString(hash[:4]), key: hash[:]})
+	}
+
+	return keys
+}
+
+// IsCurrentSecret reports whether the value is sealed with the current key.
+func IsCurrentSecret(data string) bool {
+	keys := secretKeys()
+	return len(keys) > 0 && strings.HasPrefix(data, secretPrefix+keys[0].id+":")
+}
+
+// isSealed reports whether the value is sealed with a known key, secrets that
+// merely start with the prefix are still plain text.
+func isSealed(data string) bool {
+	for _, v := range secretKeys() {
+		if strings.HasPrefix(data, secretPrefix+v.id+":") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func encryptSecret(data string) (string, error) {
+	if data == "" || isSealed(data) {
+		return data, nil
+	}
+
+	keys := secretKeys()
+	if len(keys) == 0 {
+		return data, nil
+	}
+
+	sealed, err := util.AesEncrypt(keys[0].key, data)
+	if err != nil {
+		return "", err
+	}
+
+	return secretPrefix + keys[0].id + ":" + sealed, nil
+}
+
+// decryptSecret opens a sealed value with whichever key produced it. Values
+// that can't be opened are returned untouched, so saving the row again does
+// not destroy them.
+func decryptSecret(data string) (string, error) {
+	if !strings.HasPrefix(data, secretPrefix) {
+		return data, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(data, secretPrefix), ":", 2)
+	if len(parts) != 2 {
+		return data, util.ErrCipherText
+	}
+
+	for _, v := range secretKeys() {
+		if v.id == parts[0] {
+			plain, err := util.AesDecrypt(v.key, parts[1])
+			if err != nil {
+				return data, err
+			}
+
+			return plain, nil
+		}
+	}
+
+	return data, fmt.Errorf("no secret key for id: %s", parts[0])
+}
+
+func scanString(dbValue interface{}) string {
+	switch v := dbValue.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	}
+
+	return ""
+}
+
+// SecretSerializer encrypts the whole column.
+type SecretSerializer struct{}
+
+func (SecretSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	plain, err := decryptSecret(scanString(dbValue))
+	if err != nil {
+		log.Errorf("decrypt %s err: %+v", field.Name, err)
+	}
+
+	return field.Set(ctx, dst, plain)
+}
+
+func (SecretSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	return encryptSecret(fieldValue.(string))
+}
+
+// SecretJsonSerializer encrypts the registered keys of a JSON object column,
+// the owning row must have an Engine field.
+type SecretJsonSerializer struct{}
+
+func (SecretJsonSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	data := scanString(dbValue)
+	res, err := mapSecretJson(data, nil, decryptSecret)
+	if err != nil {
+		log.Errorf("decrypt %s err: %+v", field.Name, err)
+	}
+
+	return field.Set(ctx, dst, res)
+}
+
+func (SecretJsonSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	engine := reflect.Indirect(dst).FieldByName("Engine")
+	if !engine.IsValid() {
+		return fieldValue, nil
+	}
+
+	names := GetSecretField(engine.String())
+	if len(names) == 0 {
+		return fieldValue, nil
+	}
+
+	return mapSecretJson(fieldValue.(string), names, encryptSecret)
+}
+
+// mapSecretJson applies fn to the string values of the listed keys, every
+// key when names is nil. Anything that is not a JSON object is left as is.
+func mapSecretJson(data string, names []string, fn func(string) (string, error)) (string, error) {
+	obj := map[string]json.RawMessage{}
+	if data == "" || json.Unmarshal([]byte(data), &obj) != nil {
+		return data, nil
+	}
+
+	var lastErr error
+	changed := false
+	for k, v := range obj {
+		if names != nil && !containsStr(names, k) {
+			continue
+		}
+
+		var str string
+		if json.Unmarshal(v, &str) != nil || str == "" {
+			continue
+		}
+
+		res, err := fn(str)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if res != str {
+			obj[k], _ = json.Marshal(res)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return data, lastErr
+	}
+
+	jsonData, err := json.Marshal(obj)
+	if err != nil {
+		return data, err
+	}
+
+	return string(jsonData), lastErr
+}
+
+func containsStr(list []string, str string) bool {
+	for _, v := range list {
+		if v == str {
+			return true
+		}
+	}
+
+	return false
+}
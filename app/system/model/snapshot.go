@@ -0,0 +1,92 @@
+package model
+
+import (
+	"time"
+)
+
+// Snapshot is the last complete snapshot of a storage, entries of older
+// versions are dropped once a new one completes.
+type Snapshot struct {
+	StorageID uint      `json:"storage_id" gorm:"primaryKey;autoIncrement:false"`
+	Version   int64     `json:"version"`
+	TakenAt   time.Time `json:"taken_at"`
+	Folders   int       `json:"folders"`
+	Entries   int       `json:"entries"`
+	LastError string    `json:"last_error"`
+	UpdatedAt time.Time `json:"modified"`
+}
+
+// SnapshotEntry is one item of a snapshotted folder, Parent is relative to
+// the mount path so a renamed mount keeps its snapshot.
+type SnapshotEntry struct {
+	ID        uint   `gorm:"primaryKey"`
+	StorageID uint   `gorm:"index:idx_snapshot_parent,priority:1"`
+	Version   int64  `gorm:"index:idx_snapshot_parent,priority:2"`
+	Parent    string `gorm:"size:512;index:idx_snapshot_parent,priority:3"`
+	Name      string `gorm:"size:512"`
+	FileId    string
+	Size      int64
+	Modified  time.Time
+	IsFolder  bool
+}
+
+func GetSnapshot(storageID uint) (*Snapshot, error) {
+	var data Snapshot
+	err := db.Where("storage_id = ?", storageID).Limit(1).Find(&data).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func GetAllSnapshot() ([]Snapshot, error) {
+	var dataList []Snapshot
+	err := db.Find(&dataList).Error
+	return dataList, err
+}
+
+func SaveSnapshot(data *Snapshot) error {
+	return db.Save(data).Error
+}
+
+func GetSnapshotEntries(storageID uint, version int64, parent string) ([]SnapshotEntry, error) {
+	var dataList []SnapshotEntry
+	err := db.Where("storage_id = ? AND version = ? AND parent = ?", storageID, version, parent).Find(&dataList).Error
+	return dataList, err
+}
+
+func HasSnapshotFolder(storageID uint, version int64, parent string, name string) (bool, error) {
+	var count int64
+	err := db.Model(&SnapshotEntry{}).
+		Where("storage_id = ? AND version = ? AND parent = ? AND name = ? AND is_folder = ?", storageID, version, parent, name, true).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func BatchCreateSnapshotEntry(data []SnapshotEntry) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	return db.CreateInBatches(data, 200).Error
+}
+
+// DeleteSnapshotEntries drops the entries of storageID whose version is not
+// keep, a zero keep drops them all.
+func DeleteSnapshotEntries(storageID uint, keep int64) error {
+	return db.Where("storage_id = ? AND version <> ?", storageID, keep).Delete(&SnapshotEntry{}).Error
+}
+
+func DeleteSnapshotVersion(storageID uint, version int64) error {
+	return db.Where("storage_id = ? AND version = ?", storageID, version).Delete(&SnapshotEntry{}).Error
+}
+
+func DeleteSnapshot(storageID uint) error {
+	err := db.Where("storage_id = ?", storageID).Delete(&SnapshotEntry{}).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Where("storage_id = ?", storageID).Delete(&Snapshot{}).Error
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -9,11 +10,21 @@ type Storage struct {
 	MountPath string `json:"mount_path" gorm:"unique"`
 	Engine    string `json:"engine"`
 	Status    string `json:"status"`
-	Extra     string `json:"extra"`
+	Extra     string `json:"extra" gorm:"serializer:secretjson"`
 	Disabled  bool   `json:"disabled"`
 	Remark    string `json:"remark"`
-	Token     string `json:"-"`
-	UpdatedAt time.Time
+	Token     string `json:"-" gorm:"serializer:secret"`
+	// Cache policy, a zero TTL follows the [cache] section of config.ini
+	CacheDisabled bool `json:"cache_disabled"`
+	ListTTL       int  `json:"list_ttl"`
+	LinkTTL       int  `json:"link_ttl"`
+	// Cron expression of the snapshot schedule, empty for none
+	SnapshotCron string `json:"snapshot_cron"`
+	// How /fd/ and WebDAV GET deliver files, empty for proxy
+	DownloadMode string `json:"download_mode"`
+	// Parallel ranged GETs per download, bounded by the engine
+	Connections int `json:"connections"`
+	UpdatedAt   time.Time
 }
 
 func (self *Storage) SetData(data Storage) {
@@ -68,3 +79,58 @@ func GetAllEnableStorage() ([]Storage, error) {
 
 	return dataList, nil
 }
+
+// RotateStorageSecret re-seals secrets that are still in plain text or were
+// sealed with a retired key, it returns the number of rows rewritten.
+func RotateStorageSecret() (int, error) {
+	var rawList []struct {
+		ID     uint
+		Engine string
+		Extra  string
+		Token  string
+	}
+	err := db.Table("storages").Select("id, engine, extra, token").Scan(&rawList).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	for _, v := range rawList {
+		if !needRotate(v.Engine, v.Extra, v.Token) {
+			continue
+		}
+
+		data, err := GetStorage(v.ID)
+		if err != nil {
+			return count, err
+		}
+
+		err = db.Model(data).Select("extra", "token").Updates(data).Error
+		if err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+func needRotate(engine string, extra string, token string) bool {
+	if token != "" && !IsCurrentSecret(token) {
+		return true
+	}
+
+	obj := map[string]interface{}{}
+	if json.Unmarshal([]byte(extra), &obj) != nil {
+		return false
+	}
+
+	for _, v := range GetSecretField(engine) {
+		if str, ok := obj[v].(string); ok && str != "" && !IsCurrentSecret(str) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"overlink.top/app/system/conf"
 	"time"
 )
 
@@ -12,15 +13,22 @@ type User struct {
 	PwdStamp   int64     `json:"-"`
 	Salt       string    `json:"-"`
 	Role       int       `json:"role"`
+	RoleID     uint      `json:"role_id"`
 	Enable     bool      `json:"enable"`
 	Perm       int       `json:"perm"`
+	Source     string    `json:"source"`
+	BasePath   string    `json:"base_path"`
 	LoginIp    string    `json:"login_ip"`
 	LoginTime  time.Time `json:"login_time"`
 	UpdatedAt  time.Time `json:"modified"`
 }
 
 func (self *User) IsSuper() bool {
-	return self.Username == "admin"
+	return self.Username == "admin" || self.Role == conf.SuperAdmin
+}
+
+func (self *User) IsLocal() bool {
+	return self.Source == "" || self.Source == conf.SourceLocal
 }
 
 func GetAllUser() ([]User, error) {
@@ -10,4 +10,7 @@ var (
 	ErrTokenInvalid = errors.New("errTokenInvalid")
 	ErrAuthAccount  = errors.New("errAuthAccount")
 	ErrAccessPwd    = errors.New("errAccessPwd")
+	ErrTokenScope   = errors.New("errTokenScope")
+	ErrNoPermission = errors.New("errNoPermission")
+	ErrTooLarge     = errors.New("errTooLarge")
 )
@@ -53,6 +53,22 @@ type ResetPwdReq struct {
 	Password string `json:"password"`
 }
 
+type CreateTokenReq struct {
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	ExpireDays int      `json:"expire_days"`
+}
+
+type CreateTokenResp struct {
+	model.ApiToken
+	Token string `json:"token"`
+}
+
+type GroupMemberReq struct {
+	GroupID uint   `json:"group_id" binding:"required"`
+	UserIds []uint `json:"user_ids"`
+}
+
 type ListUserReq struct {
 	Query    string `form:"query"`
 	Pagenum  int    `form:"pagenum"`
@@ -65,6 +81,164 @@ type ListUserResp struct {
 	UserList []model.User `json:"users"`
 }
 
+type ListAuditReq struct {
+	Actor    string `form:"actor"`
+	Action   string `form:"action"`
+	Target   string `form:"target"`
+	Start    int64  `form:"start"`
+	End      int64  `form:"end"`
+	Pagenum  int    `form:"pagenum"`
+	Pagesize int    `form:"pagesize"`
+}
+
+type ListAuditResp struct {
+	Total     int              `json:"total"`
+	Pagenum   int              `json:"pagenum"`
+	AuditList []model.AuditLog `json:"audits"`
+}
+
+type SnapshotStatus struct {
+	ID        uint       `json:"id"`
+	MountPath string     `json:"mount_path"`
+	Cron      string     `json:"cron"`
+	TakenAt   *time.Time `json:"taken_at"`
+	// Seconds since the snapshot was taken, -1 when there is none
+	Age       int64      `json:"age"`
+	Folders   int        `json:"folders"`
+	Entries   int        `json:"entries"`
+	Running   bool       `json:"running"`
+	LastError string     `json:"last_error"`
+	NextRun   *time.Time `json:"next_run"`
+}
+
+type ArchiveFileReq struct {
+	// A folder, or files and folders, to put in the zip
+	Paths []string `json:"paths" binding:"required"`
+	// File name without .zip, defaults to the first path
+	Name string `json:"name"`
+	// "store" or "deflate", defaults to deflate
+	Method string `json:"method"`
+	// Passwords of protected folders, keyed by folder path
+	Passwords map[string]string `json:"passwords"`
+}
+
+type SearchFileReq struct {
+	Name string `json:"name"`
+	// Comma separated extensions without the dot
+	Ext     string `json:"ext"`
+	MinSize int64  `json:"min_size"`
+	MaxSize int64  `json:"max_size"`
+	Start   int64  `json:"start"`
+	End     int64  `json:"end"`
+	// Mount or folder to search in, empty for everything visible
+	Mount string `json:"mount"`
+	// "file", "folder" or empty for both
+	Type string `json:"type"`
+	// Passwords of protected folders, keyed by folder path
+	Passwords map[string]string `json:"passwords"`
+	Pagenum   int               `json:"pagenum"`
+	Pagesize  int               `json:"pagesize"`
+}
+
+// SearchFileResp holds one page of matches, More tells there are further
+// pages.
+type SearchFileResp struct {
+	Total   int        `json:"total"`
+	More    bool       `json:"more"`
+	Pagenum int        `json:"pagenum"`
+	List    []FileInfo `json:"list"`
+}
+
+type LogFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+type ListLogReq struct {
+	File     string `form:"file"`
+	Level    string `form:"level"`
+	Keyword  string `form:"keyword"`
+	Start    int64  `form:"start"`
+	End      int64  `form:"end"`
+	Pagenum  int    `form:"pagenum"`
+	Pagesize int    `form:"pagesize"`
+}
+
+type LogLine struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+	Text  string    `json:"text"`
+}
+
+// ListLogResp counts the entries of the current file only up to the one
+// after the page, More tells there are further pages.
+type ListLogResp struct {
+	Total   int       `json:"total"`
+	More    bool      `json:"more"`
+	Pagenum int       `json:"pagenum"`
+	Lines   []LogLine `json:"lines"`
+}
+
+type TailTokenResp struct {
+	Token  string `json:"token"`
+	Expire int64  `json:"expire"`
+}
+
+type LogLevelResp struct {
+	Level string `json:"level"`
+}
+
+type SetLogLevelReq struct {
+	Level string `json:"level" binding:"required"`
+}
+
+type BackupUser struct {
+	model.User
+	EncryptPwd string `json:"encrypt_pwd"`
+	PwdStamp   int64  `json:"pwd_stamp"`
+	Salt       string `json:"salt"`
+}
+
+type BackupStorage struct {
+	model.Storage
+	Token string `json:"token"`
+}
+
+type BackupPreference struct {
+	model.Preference
+	Term int `json:"term"`
+}
+
+// Backup is the exported configuration bundle. When Salt is set, storage
+// Extra and Token are encrypted with a key derived from the passphrase and
+// Check holds a known value to verify it on import.
+type Backup struct {
+	Version     int                   `json:"version"`
+	AppVersion  string                `json:"app_version"`
+	CreatedAt   time.Time             `json:"created_at"`
+	Salt        string                `json:"salt,omitempty"`
+	Check       string                `json:"check,omitempty"`
+	Users       []BackupUser          `json:"users"`
+	Storages    []BackupStorage       `json:"storages"`
+	Folders     []model.FolderSetting `json:"folders"`
+	Preferences []BackupPreference    `json:"preferences"`
+	Roles       []model.Role          `json:"roles"`
+	Groups      []model.Group         `json:"groups"`
+	Members     []model.UserGroup     `json:"members"`
+	Acls        []model.Acl           `json:"acls"`
+}
+
+type ExportBackupReq struct {
+	Passphrase string `json:"passphrase"`
+}
+
+type ImportBackupReq struct {
+	Mode       string  `json:"mode"`
+	Passphrase string  `json:"passphrase"`
+	Backup     *Backup `json:"backup" binding:"required"`
+}
+
 type Finfo interface {
 	GetFileId() string
 	GetPath() string
@@ -88,12 +262,20 @@ type GetFileResp struct {
 type ListFileReq struct {
 	Rpath    string  `json:"rpath" binding:"required"`
 	Password *string `json:"password" binding:"required"`
+	Refresh  bool    `json:"refresh"`
+}
+
+type RefreshReq struct {
+	Path string `json:"path" binding:"required"`
 }
 
 type ListFileResp struct {
 	List   []FileInfo `json:"list"`
 	Topmd  string     `json:"topmd"`
 	Readme string     `json:"readme"`
+	// Set when the storage failed and the list comes from a snapshot
+	SnapshotAt  *time.Time `json:"snapshot_at,omitempty"`
+	SnapshotAge int64      `json:"snapshot_age,omitempty"`
 }
 
 type FileInfo struct {
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"overlink.top/app/system/logic"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+)
+
+func AddRouterAcl(g *gin.RouterGroup) {
+	group := g.Group("/acl")
+	group.GET("/list", listAcl)
+	group.POST("/add", addAcl)
+	group.POST("/delete", deleteAcl)
+}
+
+func listAcl(c *gin.Context) {
+	list, err := model.GetAllAcl()
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, list)
+}
+
+func addAcl(c *gin.Context) {
+	var req model.Acl
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = logic.AddAcl(c, req)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, nil)
+}
+
+func deleteAcl(c *gin.Context) {
+	var req model.Acl
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = logic.DeleteAcl(c, req.ID)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, nil)
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"overlink.top/app/system/logic"
+	"overlink.top/app/system/msg"
+)
+
+func AddRouterAudit(g *gin.RouterGroup) {
+	group := g.Group("/audit")
+	group.POST("/list", listAudit)
+}
+
+func listAudit(c *gin.Context) {
+	var req msg.ListAuditReq
+	err := c.ShouldBind(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	data, err := logic.ListAudit(c, req)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, data)
+}
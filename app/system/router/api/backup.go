@@ -0,0 +1,51 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"overlink.top/app/system/logic"
+	"overlink.top/app/system/msg"
+)
+
+func AddRouterBackup(g *gin.RouterGroup) {
+	group := g.Group("/backup")
+	group.POST("/export", exportBackup)
+	group.POST("/import", importBackup)
+}
+
+func exportBackup(c *gin.Context) {
+	var req msg.ExportBackupReq
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	data, err := logic.ExportBackup(c, req)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	filename := fmt.Sprintf("showta-backup-%s.json", data.CreatedAt.Format("20060102150405"))
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.JSON(http.StatusOK, data)
+}
+
+func importBackup(c *gin.Context) {
+	var req msg.ImportBackupReq
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = logic.ImportBackup(c, req)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, nil)
+}
@@ -3,7 +3,9 @@ package api
 import (
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"overlink.top/app/lib/util"
 	"overlink.top/app/system/logic"
+	"overlink.top/app/system/model"
 	"overlink.top/app/system/msg"
 )
 
@@ -12,6 +14,8 @@ func AddRouterFile(g *gin.RouterGroup) {
 	group.POST("/list", listFile)
 	group.POST("/get", getFile)
 	group.POST("/subdir", subdir)
+	group.POST("/search", searchFile)
+	group.POST("/archive", archiveFile)
 }
 
 func listFile(c *gin.Context) {
@@ -32,6 +36,14 @@ func listFile(c *gin.Context) {
 		return
 	}
 
+	if req.Refresh {
+		err = logic.RefreshPath(c, req.Rpath)
+		if err != nil {
+			msg.RespError(c, http.StatusForbidden, err)
+			return
+		}
+	}
+
 	data, err := logic.ViewListFile(c, req.Rpath)
 	if err != nil {
 		msg.RespError(c, http.StatusInternalServerError, err)
@@ -71,9 +83,40 @@ func getFile(c *gin.Context) {
 
 func ProxyFile(c *gin.Context) {
 	rpath := c.Param("path")
+	ctx := logic.WithClientIp(c.Request.Context(), util.ClientIPSimple(c.Request))
+	if user, ok := c.Get("identity"); ok {
+		ctx = logic.WithIdentity(ctx, user.(*model.User))
+	}
+
+	c.Request = c.Request.WithContext(ctx)
+
 	logic.ProxyFile(c.Request, c.Writer, rpath)
 }
 
+func Thumb(c *gin.Context) {
+	rpath := c.Param("path")
+	ctx := logic.WithClientIp(c.Request.Context(), util.ClientIPSimple(c.Request))
+	if user, ok := c.Get("identity"); ok {
+		ctx = logic.WithIdentity(ctx, user.(*model.User))
+	}
+
+	c.Request = c.Request.WithContext(ctx)
+
+	logic.Thumb(c.Request, c.Writer, rpath)
+}
+
+func Hls(c *gin.Context) {
+	rpath := c.Param("path")
+	ctx := logic.WithClientIp(c.Request.Context(), util.ClientIPSimple(c.Request))
+	if user, ok := c.Get("identity"); ok {
+		ctx = logic.WithIdentity(ctx, user.(*model.User))
+	}
+
+	c.Request = c.Request.WithContext(ctx)
+
+	logic.Hls(c.Request, c.Writer, rpath)
+}
+
 func subdir(c *gin.Context) {
 	var req msg.SubdirReq
 	err := c.ShouldBindJSON(&req)
@@ -90,3 +133,42 @@ func subdir(c *gin.Context) {
 
 	msg.Response(c, data)
 }
+
+func archiveFile(c *gin.Context) {
+	var req msg.ArchiveFileReq
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = logic.ArchiveFile(c, c.Writer, req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case msg.ErrNoPermission, msg.ErrAccessPwd:
+			status = http.StatusForbidden
+		case msg.ErrTooLarge:
+			status = http.StatusRequestEntityTooLarge
+		}
+
+		msg.RespError(c, status, err)
+	}
+}
+
+func searchFile(c *gin.Context) {
+	var req msg.SearchFileReq
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	data, err := logic.SearchFile(c, req)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, data)
+}
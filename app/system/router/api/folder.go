@@ -18,7 +18,7 @@ func AddRouterFolder(g *gin.RouterGroup) {
 }
 
 func listFolderSetting(c *gin.Context) {
-    list, err := model.GetAllFolderSetting()
+    list, err := logic.ListFolderSetting(c)
     if err != nil {
         msg.RespError(c, http.StatusInternalServerError, err)
         return
@@ -35,7 +35,7 @@ func getFolderSetting(c *gin.Context) {
         return
     }
 
-    data, err := model.GetFolderSetting(req.ID)
+    data, err := logic.GetFolderSetting(c, req.ID)
     if err != nil {
         msg.RespError(c, http.StatusInternalServerError, err)
         return
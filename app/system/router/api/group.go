@@ -0,0 +1,114 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"overlink.top/app/system/logic"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+)
+
+func AddRouterGroup(g *gin.RouterGroup) {
+	group := g.Group("/group")
+	group.GET("/list", listGroup)
+	group.POST("/add", addGroup)
+	group.POST("/update", updateGroup)
+	group.POST("/delete", deleteGroup)
+	group.POST("/members", listGroupMember)
+	group.POST("/set_members", setGroupMember)
+}
+
+func listGroup(c *gin.Context) {
+	list, err := model.GetAllGroup()
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, list)
+}
+
+func addGroup(c *gin.Context) {
+	var req model.Group
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = logic.AddGroup(c, req)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, nil)
+}
+
+func updateGroup(c *gin.Context) {
+	var req model.Group
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = logic.UpdateGroup(c, req)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, nil)
+}
+
+func deleteGroup(c *gin.Context) {
+	var req model.Group
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = logic.DeleteGroup(c, req.ID)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, nil)
+}
+
+func listGroupMember(c *gin.Context) {
+	var req model.Group
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	data, err := model.GetGroupMember(req.ID)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, data)
+}
+
+func setGroupMember(c *gin.Context) {
+	var req msg.GroupMemberReq
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = logic.SetGroupMember(c, req)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, nil)
+}
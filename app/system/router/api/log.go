@@ -0,0 +1,124 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"overlink.top/app/internal/jwt"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/log"
+	"overlink.top/app/system/logic"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+	"time"
+)
+
+const tailTokenTTL = time.Minute
+
+func AddRouterLog(g *gin.RouterGroup) {
+	group := g.Group("/log")
+	group.POST("/files", listLogFile)
+	group.POST("/list", listLog)
+	group.POST("/tail_token", tailToken)
+	group.POST("/level", getLogLevel)
+	group.POST("/set_level", setLogLevel)
+}
+
+func listLogFile(c *gin.Context) {
+	list, err := logic.ListLogFile(c)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, list)
+}
+
+func listLog(c *gin.Context) {
+	var req msg.ListLogReq
+	err := c.ShouldBind(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	data, err := logic.ListLog(c, req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	msg.Response(c, data)
+}
+
+// tailToken mints the token EventSource passes to TailLog, it only has to be
+// valid when the stream is opened.
+func tailToken(c *gin.Context) {
+	user := c.MustGet("identity").(*model.User)
+	token, err := jwt.GenScopedToken(user.Username, user.PwdStamp, conf.AudienceLogTail, tailTokenTTL)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, msg.TailTokenResp{Token: token, Expire: int64(tailTokenTTL.Seconds())})
+}
+
+// TailLog streams new entries as server-sent events, filters are the same
+// query parameters as listLog except paging and time.
+func TailLog(c *gin.Context) {
+	var req msg.ListLogReq
+	err := c.ShouldBindQuery(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	req.Start, req.End = 0, 0
+	ch, cancel := log.Subscribe()
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case data := <-ch:
+			line, _ := logic.ParseLogLine(string(data))
+			if logic.MatchLogLine(line, req) {
+				c.SSEvent("log", line)
+			}
+		case <-ticker.C:
+			// Keeps proxies from closing an idle stream
+			c.SSEvent("ping", time.Now().Unix())
+		case <-c.Request.Context().Done():
+			return false
+		}
+
+		return true
+	})
+}
+
+func getLogLevel(c *gin.Context) {
+	msg.Response(c, logic.GetLogLevel(c))
+}
+
+func setLogLevel(c *gin.Context) {
+	var req msg.SetLogLevelReq
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = logic.SetLogLevel(c, req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	msg.Response(c, nil)
+}
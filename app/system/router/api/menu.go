@@ -3,6 +3,7 @@ package api
 import (
 	"github.com/gin-gonic/gin"
 	"overlink.top/app/system/conf"
+	"overlink.top/app/system/logic"
 	"overlink.top/app/system/model"
 	"overlink.top/app/system/msg"
 )
@@ -11,7 +12,15 @@ func GetMenu(c *gin.Context) {
 	user := c.MustGet("identity").(*model.User)
 	if user.IsSuper() {
 		msg.Response(c, conf.AdminMenuList)
-	} else {
-		msg.Response(c, conf.CommonMenuList)
+		return
 	}
+
+	menuList := []conf.Menu{}
+	for _, v := range conf.AdminMenuList {
+		if !v.IsAdmin || logic.HasPerm(user, conf.MenuPermMap[v.Name]) {
+			menuList = append(menuList, v)
+		}
+	}
+
+	msg.Response(c, menuList)
 }
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"overlink.top/app/system/logic"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+)
+
+func AddRouterRole(g *gin.RouterGroup) {
+	group := g.Group("/role")
+	group.GET("/list", listRole)
+	group.POST("/add", addRole)
+	group.POST("/update", updateRole)
+	group.POST("/delete", deleteRole)
+}
+
+func listRole(c *gin.Context) {
+	list, err := model.GetAllRole()
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, list)
+}
+
+func addRole(c *gin.Context) {
+	var req model.Role
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = logic.AddRole(c, req)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, nil)
+}
+
+func updateRole(c *gin.Context) {
+	var req model.Role
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = logic.UpdateRole(c, req)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, nil)
+}
+
+func deleteRole(c *gin.Context) {
+	var req model.Role
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = logic.DeleteRole(c, req.ID)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, nil)
+}
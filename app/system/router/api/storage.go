@@ -17,13 +17,16 @@ func AddRouterStorage(g *gin.RouterGroup) {
 	group.POST("/update", updateStorage)
 	group.POST("/switch", switchStorage)
 	group.POST("/delete", deleteStorage)
+	group.POST("/refresh", refreshStorage)
+	group.POST("/snapshot", takeSnapshot)
+	group.GET("/snapshot_status", listSnapshotStatus)
 
 	group.GET("/listname", listEngineName)
 	group.GET("/listform", listEngineForm)
 }
 
 func listStorage(c *gin.Context) {
-	list, err := model.GetAllStorage()
+	list, err := logic.ListStorage(c)
 	if err != nil {
 		msg.RespError(c, http.StatusInternalServerError, err)
 		return
@@ -40,7 +43,7 @@ func getStorage(c *gin.Context) {
 		return
 	}
 
-	data, err := model.GetStorage(req.ID)
+	data, err := logic.GetStorage(c, req.ID)
 	if err != nil {
 		msg.RespError(c, http.StatusInternalServerError, err)
 		return
@@ -126,3 +129,47 @@ func listEngineForm(c *gin.Context) {
 	resp := logic.GetAllEngineForm()
 	msg.Response(c, resp)
 }
+
+func refreshStorage(c *gin.Context) {
+	var req msg.RefreshReq
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = logic.RefreshTree(c, req.Path)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, nil)
+}
+
+func takeSnapshot(c *gin.Context) {
+	var req model.Storage
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = logic.TakeSnapshot(c, req.ID)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, nil)
+}
+
+func listSnapshotStatus(c *gin.Context) {
+	list, err := logic.ListSnapshotStatus(c)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, list)
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"overlink.top/app/system/logic"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
+)
+
+func AddRouterToken(g *gin.RouterGroup) {
+	group := g.Group("/token")
+	group.GET("/list", listToken)
+	group.POST("/create", createToken)
+	group.POST("/revoke", revokeToken)
+}
+
+func listToken(c *gin.Context) {
+	list, err := logic.ListApiToken(c)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, list)
+}
+
+func createToken(c *gin.Context) {
+	var req msg.CreateTokenReq
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	data, err := logic.CreateApiToken(c, req)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, data)
+}
+
+func revokeToken(c *gin.Context) {
+	var req model.ApiToken
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		msg.RespError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = logic.RevokeApiToken(c, req.ID)
+	if err != nil {
+		msg.RespError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	msg.Response(c, nil)
+}
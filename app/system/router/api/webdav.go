@@ -4,7 +4,11 @@ import (
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"overlink.top/app/internal/webdav"
+	"overlink.top/app/lib/util"
+	"overlink.top/app/system/conf"
 	"overlink.top/app/system/logic"
+	"overlink.top/app/system/model"
+	"overlink.top/app/system/msg"
 )
 
 var webdavHandler *webdav.Handler
@@ -12,7 +16,7 @@ var webdavHandler *webdav.Handler
 func AddRouterWebdav(r *gin.Engine) {
 	webdavHandler = &webdav.Handler{
 		Prefix:     "/dav",
-		FileSystem: webdav.Dir("mnt"),
+		FileSystem: webdav.JailFileSystem(webdav.Dir("mnt")),
 		LockSystem: webdav.NewMemLS(),
 	}
 
@@ -32,12 +36,26 @@ func webdavAuth(c *gin.Context) {
 		return
 	}
 
-	if _, err := logic.Auth(c, username, password); err != nil {
+	var user *model.User
+	var err error
+	if logic.IsApiToken(password) {
+		user, err = logic.VerifyApiToken(c, util.ClientIPSimple(c.Request), password, conf.ScopeWebdav)
+		if err == nil && user.Username != username {
+			err = msg.ErrAuthAccount
+		}
+	} else {
+		user, err = logic.AuthUser(c, username, password)
+	}
+
+	if err != nil {
 		http.Error(c.Writer, "WebDAV: need authorized!", http.StatusUnauthorized)
 		c.Abort()
 		return
 	}
 
+	c.Set("identity", user)
+	ctx := logic.WithClientIp(c.Request.Context(), util.ClientIPSimple(c.Request))
+	c.Request = c.Request.WithContext(logic.WithIdentity(ctx, user))
 	c.Next()
 }
 
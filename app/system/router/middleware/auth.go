@@ -5,8 +5,13 @@ import (
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"overlink.top/app/internal/jwt"
+	"overlink.top/app/internal/sign"
+	"overlink.top/app/lib/util"
+	"overlink.top/app/system/conf"
+	"overlink.top/app/system/logic"
 	"overlink.top/app/system/model"
 	"overlink.top/app/system/msg"
+	"strings"
 )
 
 const (
@@ -27,6 +32,84 @@ func StrictAuth(c *gin.Context) {
 	auth(c, Strict)
 }
 
+// LinkAuth guards /fd/. A valid signature grants access to exactly that path,
+// otherwise the caller is resolved like PermissiveAuth so home directories
+// and ACLs apply. Without signing and without an enabled guest the links stay
+// public as before, except for paths a home directory or an ACL covers.
+func LinkAuth(c *gin.Context) {
+	sig := c.Query("sig")
+	if sig != "" && sign.Check(util.StandardPath(c.Param("path")), sig) == nil {
+		c.Next()
+		return
+	}
+
+	if c.GetHeader("Authorization") != "" {
+		auth(c, Permissive)
+		return
+	}
+
+	if conf.GlobalSign {
+		msg.RespError(c, http.StatusForbidden, sign.ErrInvalidSign)
+		c.Abort()
+		return
+	}
+
+	guest, err := model.GetUserByName("guest")
+	if err != nil || !guest.Enable {
+		if !logic.IsPublicPath(c.Param("path")) {
+			msg.RespError(c, http.StatusForbidden, msg.ErrNoPermission)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+		return
+	}
+
+	auth(c, Permissive)
+}
+
+// QueryTokenAuth is StrictAuth for routes browsers open without headers, such
+// as EventSource streams. A short-lived token minted for audience may come in
+// the token query parameter instead of the Authorization header.
+func QueryTokenAuth(audience string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" || c.GetHeader("Authorization") != "" {
+			auth(c, Strict)
+			return
+		}
+
+		appClaims, err := jwt.ParseToken(token)
+		if err != nil {
+			msg.RespError(c, http.StatusUnauthorized, err)
+			c.Abort()
+			return
+		}
+
+		if !appClaims.VerifyAudience(audience, true) {
+			msg.RespError(c, http.StatusUnauthorized, msg.ErrTokenInvalid)
+			c.Abort()
+			return
+		}
+
+		claimsAuth(c, appClaims, Strict)
+	}
+}
+
+// DelegatedAuth is StrictAuth that also lets in users whose custom role
+// grants perm, the logic layer further limits them to their own mounts.
+func DelegatedAuth(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("delegate", perm)
+		auth(c, Strict)
+	}
+}
+
+func canAdmin(c *gin.Context, user *model.User) bool {
+	return user.IsSuper() || logic.HasPerm(user, c.GetString("delegate"))
+}
+
 func auth(c *gin.Context, permission int) {
 	token := c.GetHeader("Authorization")
 	if token == "" {
@@ -54,6 +137,12 @@ func auth(c *gin.Context, permission int) {
 		return
 	}
 
+	token = strings.TrimPrefix(token, "Bearer ")
+	if logic.IsApiToken(token) {
+		apiTokenAuth(c, token, permission)
+		return
+	}
+
 	appClaims, err := jwt.ParseToken(token)
 	if err != nil {
 		msg.RespError(c, http.StatusUnauthorized, err)
@@ -61,6 +150,18 @@ func auth(c *gin.Context, permission int) {
 		return
 	}
 
+	// Scoped tokens travel in URLs, they never stand in for a login
+	if len(appClaims.Audience) > 0 {
+		msg.RespError(c, http.StatusUnauthorized, msg.ErrTokenInvalid)
+		c.Abort()
+		return
+	}
+
+	claimsAuth(c, appClaims, permission)
+}
+
+// claimsAuth finishes a login token check once its signature is verified.
+func claimsAuth(c *gin.Context, appClaims *jwt.AppClaims, permission int) {
 	user, err := model.GetUserByName(appClaims.Username)
 	if err != nil {
 		msg.RespError(c, http.StatusUnauthorized, err)
@@ -68,7 +169,7 @@ func auth(c *gin.Context, permission int) {
 		return
 	}
 
-	if permission == Strict && !user.IsSuper() {
+	if permission == Strict && !canAdmin(c, user) {
 		msg.RespError(c, http.StatusForbidden, fmt.Errorf("no permission"))
 		c.Abort()
 		return
@@ -89,3 +190,28 @@ func auth(c *gin.Context, permission int) {
 	c.Set("identity", user)
 	c.Next()
 }
+
+// apiTokenAuth accepts personal tokens, read scope is enough for the file
+// APIs while everything under /admin needs the admin scope.
+func apiTokenAuth(c *gin.Context, token string, permission int) {
+	scope := conf.ScopeAdmin
+	if permission == Permissive {
+		scope = conf.ScopeRead
+	}
+
+	user, err := logic.VerifyApiToken(c, util.ClientIPSimple(c.Request), token, scope)
+	if err != nil {
+		msg.RespError(c, http.StatusUnauthorized, err)
+		c.Abort()
+		return
+	}
+
+	if permission == Strict && !canAdmin(c, user) {
+		msg.RespError(c, http.StatusForbidden, fmt.Errorf("no permission"))
+		c.Abort()
+		return
+	}
+
+	c.Set("identity", user)
+	c.Next()
+}
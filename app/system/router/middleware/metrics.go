@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"net"
+	"net/http"
+	"overlink.top/app/internal/metrics"
+	"overlink.top/app/lib/util"
+	"overlink.top/app/system/conf"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metrics records every request under its route pattern, never the raw path,
+// so file paths don't end up as label values.
+func Metrics(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+
+	method := c.Request.Method
+	metrics.HttpRequests.WithLabelValues(route, method, strconv.Itoa(c.Writer.Status())).Inc()
+	metrics.HttpDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+}
+
+// MetricsAuth lets in scrapers presenting the configured bearer token or
+// coming from an allowed address, entries of allow_ips may be CIDRs.
+func MetricsAuth(c *gin.Context) {
+	cfg := conf.AppConf.Metrics
+	if !cfg.Enable {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if cfg.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) == 1 {
+		c.Next()
+		return
+	}
+
+	if ipAllowed(util.ClientIP(c.Request, conf.AppConf.Server.TrustedProxies), cfg.AllowIps) {
+		c.Next()
+		return
+	}
+
+	c.AbortWithStatus(http.StatusForbidden)
+}
+
+func ipAllowed(ip string, list []string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	for _, v := range list {
+		v = strings.TrimSpace(v)
+		if _, ipNet, err := net.ParseCIDR(v); err == nil {
+			if ipNet.Contains(addr) {
+				return true
+			}
+		} else if other := net.ParseIP(v); other != nil && other.Equal(addr) {
+			return true
+		}
+	}
+
+	return false
+}
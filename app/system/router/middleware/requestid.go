@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"overlink.top/app/lib/util"
+	"overlink.top/app/system/log"
+)
+
+const requestIdHeader = "X-Request-ID"
+
+// RequestId keeps the id sent by a proxy or client, or makes one up, and
+// echoes it in the response so users can quote it when reporting problems.
+func RequestId(c *gin.Context) {
+	id := c.GetHeader(requestIdHeader)
+	if !validRequestId(id) {
+		id = util.GenRandStr(20)
+	}
+
+	c.Header(requestIdHeader, id)
+	c.Request = c.Request.WithContext(log.WithRequestId(c.Request.Context(), id))
+	c.Next()
+}
+
+// validRequestId refuses ids that could forge or break log lines
+func validRequestId(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' || r == ':') {
+			return false
+		}
+	}
+
+	return true
+}
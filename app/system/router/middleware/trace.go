@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"net/http"
+	"overlink.top/app/internal/tracing"
+	"overlink.top/app/system/log"
+)
+
+// Trace opens a server span per request named after the route pattern, the
+// span rides on c.Request so logic and engines called with c continue it.
+func Trace(c *gin.Context) {
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+
+	ctx, span := tracing.StartServer(c.Request, c.Request.Method+" "+route,
+		attribute.String("http.method", c.Request.Method),
+		attribute.String("http.route", route),
+		attribute.String("http.target", c.Request.URL.Path),
+		attribute.String("request.id", log.RequestId(c.Request.Context())),
+	)
+	defer span.End()
+
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(attribute.Int("http.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
@@ -3,6 +3,7 @@ package router
 import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"overlink.top/app/system/conf"
 	"overlink.top/app/system/log"
 	"overlink.top/app/system/router/api"
 	"overlink.top/app/system/router/middleware"
@@ -10,12 +11,21 @@ import (
 
 func InitRouter() *gin.Engine {
 	r := gin.New()
+	// Lets logic read the request span through the *gin.Context it gets as ctx
+	r.ContextWithFallback = true
 	r.Use(log.GinLogger(), log.GinRecovery(true))
 	r.Use(Cors())
+	r.Use(middleware.RequestId)
+	r.Use(middleware.Trace)
+	r.Use(middleware.Metrics)
 
 	r.GET("/dist/favicon.ico", api.Favicon)
 	r.GET("/preference", api.GetPreference)
-	r.GET("/fd/*path", api.ProxyFile)
+	r.GET("/metrics", middleware.MetricsAuth, api.Metrics)
+	r.GET("/fd/*path", middleware.LinkAuth, api.ProxyFile)
+	r.HEAD("/fd/*path", middleware.LinkAuth, api.ProxyFile)
+	r.GET("/thumb/*path", middleware.LinkAuth, api.Thumb)
+	r.GET("/hls/*path", middleware.LinkAuth, api.Hls)
 
 	pa := r.Group("", middleware.PermissiveAuth)
 	api.AddRouterFile(pa)
@@ -28,12 +38,21 @@ func InitRouter() *gin.Engine {
 	ea.GET("/menu", api.GetMenu)
 	ea.GET("/user/about", api.AboutUser)
 	ea.POST("/user/reset_pwd", api.ResetPwd)
+	api.AddRouterToken(ea)
 
 	sa := admin.Group("", middleware.StrictAuth)
 	api.AddRouterUser(sa)
-	api.AddRouterStorage(sa)
-	api.AddRouterFolder(sa)
-	api.AddRouterPreference(sa)
+	api.AddRouterRole(sa)
+	api.AddRouterGroup(sa)
+	api.AddRouterAcl(sa)
+	api.AddRouterAudit(sa)
+	api.AddRouterLog(sa)
+	admin.GET("/log/tail", middleware.QueryTokenAuth(conf.AudienceLogTail), api.TailLog)
+	api.AddRouterBackup(sa)
+
+	api.AddRouterStorage(admin.Group("", middleware.DelegatedAuth(conf.PermStorage)))
+	api.AddRouterFolder(admin.Group("", middleware.DelegatedAuth(conf.PermFolder)))
+	api.AddRouterPreference(admin.Group("", middleware.DelegatedAuth(conf.PermPreference)))
 
 	api.EmbedWeb(r, func(handlers ...gin.HandlerFunc) {
 		r.NoRoute(handlers...)
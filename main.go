@@ -1,17 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"os"
+	"os/signal"
 	"overlink.top/app/system/conf"
 	"overlink.top/app/system/log"
 	"overlink.top/app/system/logic"
 	"overlink.top/app/system/model"
 	"overlink.top/app/system/router"
+	"syscall"
+	"time"
 )
 
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	conf.InitConf()
 	log.InitCore(conf.AppConf.Log)
@@ -35,19 +41,40 @@ func main() {
 		Handler: routerInit,
 	}
 
-	var err error
-	if conf.AppConf.Server.Https {
-		log.StdInfof("start https server listening %s", endPoint)
-		sslCertFile := conf.AbsPath(conf.AppConf.Server.SSLCertPem)
-		sslKeyFile := conf.AbsPath(conf.AppConf.Server.SSLKeyPem)
-		err = server.ListenAndServeTLS(sslCertFile, sslKeyFile)
-	} else {
-		log.StdInfof("start http server listening %s", endPoint)
-		err = server.ListenAndServe()
-	}
+	go func() {
+		var err error
+		if conf.AppConf.Server.Https {
+			log.StdInfof("start https server listening %s", endPoint)
+			sslCertFile := conf.AbsPath(conf.AppConf.Server.SSLCertPem)
+			sslKeyFile := conf.AbsPath(conf.AppConf.Server.SSLKeyPem)
+			err = server.ListenAndServeTLS(sslCertFile, sslKeyFile)
+		} else {
+			log.StdInfof("start http server listening %s", endPoint)
+			err = server.ListenAndServe()
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			log.StdError(err)
+			os.Exit(0)
+		}
+	}()
 
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.StdInfof("shutting down")
+
+	// Requests get a while to finish, whatever is left is cut off
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
 	if err != nil {
-		log.StdError(err)
-		os.Exit(0)
+		log.StdErrorf("shutdown server err: %+v", err)
 	}
+
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer flushCancel()
+
+	logic.Shutdown(flushCtx)
 }